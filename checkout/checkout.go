@@ -2,29 +2,108 @@ package checkout
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/barcode"
+	"github.com/TheFodfather/checkoutapi/pricing/coupon"
+	"github.com/TheFodfather/checkoutapi/pricing/promotion"
+	"github.com/TheFodfather/checkoutapi/pricing/tax"
 	"github.com/google/uuid"
 )
 
 // PricingService defines the dependency needed to get pricing rules.
 type PricingService interface {
-	GetRules() map[string]domain.PricingRule
+	GetPriceList() domain.PriceList
+}
+
+// CurrencyPricingService is a PricingService with price lists in several
+// currencies. Sessions in a currency other than that of the default price
+// list need one to be priced.
+type CurrencyPricingService interface {
+	PricingService
+	GetPriceListIn(currency string) (domain.PriceList, error)
+}
+
+// StorePricingService is a PricingService that prices each store from its
+// own price lists. Sessions created for a store need one to be priced.
+type StorePricingService interface {
+	PricingService
+	GetPriceListFor(store, currency string) (domain.PriceList, error)
+}
+
+// CataloguePricingService is a PricingService that indexes the barcodes of
+// each version of its price lists, so that sessions scanning barcodes need
+// not index them themselves.
+type CataloguePricingService interface {
+	PricingService
+	GetCatalogueFor(store, currency string) (*barcode.Catalogue, error)
 }
 
 type session struct {
 	id           string
+	version      int
+	state        domain.SessionState
+	completion   *domain.Completion
+	createdAt    time.Time
+	updatedAt    time.Time // Last change to the basket or state
 	scannedItems map[string]int
-	pricer       PricingService // Dependency on the pricing service
+	markedPrices map[string][]int64    // Prices marked on packs of measured SKUs
+	lockedRules  map[string]lockedRule // Rule each scanned SKU is charged at
+	promotions   *lockedPromotions     // Captured with the first locked rule
+	coupons      []domain.Coupon       // In the order they were applied
+	currency     string                // Every price is charged in this currency
+	store        string                // Store the session is priced for, if any
+	priceLock    PriceLock
+	startPrices  *domain.PriceList  // Captured at creation when locking on start
+	catalogue    *barcode.Catalogue // Barcodes of the price list of catalogueOf
+	catalogueOf  int                // Version of the price list catalogue indexes
+	pricer       PricingService     // Dependency on the pricing service
+	now          func() time.Time   // Clock used for timestamps
+	sync.RWMutex                    // Guards every field other than id, pricer and now
 }
 
-// New creates a new checkout session instance.
-func New(pricer PricingService) domain.ICheckout {
-	return &session{
+// New creates a new checkout session instance. A session that locks its
+// prices on start captures the price list of its currency and store here, and
+// New returns an error if there is none to capture.
+func New(pricer PricingService, opts ...Option) (domain.ICheckout, error) {
+	s := &session{
 		id:           uuid.New().String(),
+		state:        domain.StateOpen,
 		scannedItems: make(map[string]int),
+		markedPrices: make(map[string][]int64),
+		lockedRules:  make(map[string]lockedRule),
 		pricer:       pricer,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.createdAt = s.now().UTC()
+	s.updatedAt = s.createdAt
+	if s.currency == "" {
+		s.currency = currencyOf(pricer.GetPriceList())
+	}
+	if s.priceLock == LockOnStart {
+		prices, err := s.pricesNow()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock prices on start: %w", err)
+		}
+		s.startPrices = &prices
+	}
+	return s, nil
+}
+
+// currencyOf returns the currency a price list is in.
+func currencyOf(prices domain.PriceList) string {
+	if prices.Currency == "" {
+		return domain.DefaultCurrency
 	}
+	return prices.Currency
 }
 
 // GetID returns the id for a checkout session
@@ -32,30 +111,646 @@ func (s *session) GetID() string {
 	return s.id
 }
 
+// GetCurrency returns the ISO 4217 code of the currency the session charges in.
+func (s *session) GetCurrency() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.currency
+}
+
+// GetStore returns the id of the store the session is priced for, or the
+// empty string if it is not scoped to a store.
+func (s *session) GetStore() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.store
+}
+
+// GetVersion returns the version of the session, as last stamped by its repository.
+func (s *session) GetVersion() int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.version
+}
+
+// SetVersion stamps the session with a new version.
+func (s *session) SetVersion(version int) {
+	s.Lock()
+	defer s.Unlock()
+	s.version = version
+}
+
 // Scan validates an SKU against the current pricing rules and adds it to the session.
 func (s *session) Scan(SKU string) (err error) {
-	rules := s.pricer.GetRules()
-	if _, exists := rules[SKU]; !exists {
-		return fmt.Errorf("sku '%s' not found in pricing rules", SKU)
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	return s.scan(SKU)
+}
+
+// ScanMeasure adds a measured quantity of an SKU sold by measure, such as the
+// weight of produce sold by the kilogram, to the session.
+func (s *session) ScanMeasure(SKU string, measure domain.Measure) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	return s.scanMeasure(SKU, measure)
+}
+
+// ScanBarcode adds the item a barcode is for to the session, finding its SKU
+// by the barcodes of the price list new SKUs are locked against. A
+// variable-measure barcode adds the measure it carries, or a pack charged at
+// the price marked on it, of an SKU sold by measure.
+func (s *session) ScanBarcode(code string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	catalogue, err := s.currentCatalogue()
+	if err != nil {
+		return fmt.Errorf("barcode '%s' %w", code, err)
+	}
+	item, err := catalogue.Lookup(code)
+	if err != nil {
+		return err
+	}
+	switch item.Embedded {
+	case barcode.EmbeddedMeasure:
+		return s.scanMeasure(item.SKU, domain.Measure(item.Value))
+	case barcode.EmbeddedPrice:
+		return s.scanMarked(item.SKU, item.Value)
+	default:
+		return s.scan(item.SKU)
+	}
+}
+
+// scan adds a unit of an SKU to the open session.
+func (s *session) scan(SKU string) error {
+	pending, err := s.resolveRule(SKU)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCounted(SKU, pending.rule); err != nil {
+		return err
+	}
+	if err := s.checkTotal(SKU, pending.rule, s.scannedItems[SKU]+1, s.markedPrices[SKU]); err != nil {
+		return err
 	}
+	s.lockRule(SKU, pending)
 	s.scannedItems[SKU]++
+	s.touch()
+	return nil
+}
+
+// scanMeasure adds a measured quantity of an SKU sold by measure to the open
+// session.
+func (s *session) scanMeasure(SKU string, measure domain.Measure) error {
+	if measure <= 0 {
+		return fmt.Errorf("sku '%s' %w, got %s", SKU, ErrInvalidMeasure, measure)
+	}
+	pending, err := s.resolveRule(SKU)
+	if err != nil {
+		return err
+	}
+	if !pending.rule.Unit.Measured() {
+		return fmt.Errorf("sku '%s' %w", SKU, ErrNotSoldByMeasure)
+	}
+	if int64(measure) > int64(math.MaxInt-s.scannedItems[SKU]) {
+		return fmt.Errorf("sku '%s' %w: %s", SKU, domain.ErrOverflow, measure)
+	}
+	quantity := s.scannedItems[SKU] + int(measure)
+	if err := s.checkTotal(SKU, pending.rule, quantity, s.markedPrices[SKU]); err != nil {
+		return err
+	}
+	s.lockRule(SKU, pending)
+	s.scannedItems[SKU] = quantity
+	s.touch()
+	return nil
+}
+
+// scanMarked adds a pack of an SKU sold by measure to the open session, to be
+// charged the price in minor units marked on it rather than by its measure.
+func (s *session) scanMarked(SKU string, price int64) error {
+	if price <= 0 {
+		return fmt.Errorf("sku '%s' %w, got %d", SKU, ErrInvalidPrice, price)
+	}
+	pending, err := s.resolveRule(SKU)
+	if err != nil {
+		return err
+	}
+	if !pending.rule.Unit.Measured() {
+		return fmt.Errorf("sku '%s' %w", SKU, ErrNotSoldByMeasure)
+	}
+	marked := append(s.markedPrices[SKU][:len(s.markedPrices[SKU]):len(s.markedPrices[SKU])], price)
+	if err := s.checkTotal(SKU, pending.rule, s.scannedItems[SKU], marked); err != nil {
+		return err
+	}
+	s.lockRule(SKU, pending)
+	s.markedPrices[SKU] = marked
+	s.touch()
+	return nil
+}
+
+// Remove un-scans a single unit of an SKU from the session.
+func (s *session) Remove(SKU string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if err := s.checkScanned(SKU); err != nil {
+		return err
+	}
+	if err := s.checkCounted(SKU, s.lockedRules[SKU].rule); err != nil {
+		return err
+	}
+	s.scannedItems[SKU]--
+	if s.scannedItems[SKU] == 0 {
+		delete(s.scannedItems, SKU)
+	}
+	s.touch()
+	return nil
+}
+
+// Void removes every scanned unit, or the whole measured quantity, of an SKU
+// from the session.
+func (s *session) Void(SKU string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if err := s.checkScanned(SKU); err != nil {
+		return err
+	}
+	delete(s.scannedItems, SKU)
+	delete(s.markedPrices, SKU)
+	s.touch()
+	return nil
+}
+
+// SetQuantity replaces the scanned quantity of an SKU. A quantity of zero
+// removes the SKU from the session, which is the only quantity an SKU sold
+// by measure can be set to.
+func (s *session) SetQuantity(SKU string, quantity int) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if quantity < 0 {
+		return fmt.Errorf("sku '%s' %w, got %d", SKU, ErrInvalidQuantity, quantity)
+	}
+	if quantity == 0 {
+		if s.inBasket(SKU) {
+			delete(s.scannedItems, SKU)
+			delete(s.markedPrices, SKU)
+			s.touch()
+			return nil
+		}
+		// An SKU that is not in the basket is already at zero, as long as
+		// it is one that could be.
+		prices, err := s.currentPrices()
+		if err != nil {
+			return fmt.Errorf("sku '%s' %w", SKU, err)
+		}
+		if _, exists := prices.Rules[SKU]; !exists {
+			return fmt.Errorf("sku '%s' %w", SKU, ErrUnknownSKU)
+		}
+		return nil
+	}
+	pending, err := s.resolveRule(SKU)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCounted(SKU, pending.rule); err != nil {
+		return err
+	}
+	if err := s.checkTotal(SKU, pending.rule, quantity, s.markedPrices[SKU]); err != nil {
+		return err
+	}
+	s.lockRule(SKU, pending)
+	s.scannedItems[SKU] = quantity
+	s.touch()
 	return nil
 }
 
-// GetTotalPrice calculates the total price for the session based on current pricing rules.
-func (s *session) GetTotalPrice() (totalPrice int, err error) {
-	rules := s.pricer.GetRules()
-	totalPrice = 0
+// ApplyCoupon adds a coupon to the session, to be taken from its total after
+// any basket discount. Checking that the coupon can be used is up to the
+// caller; the session only enforces that it is applied once, that a coupon
+// which is not stackable is the session's only coupon, and that its amounts
+// are in the session's currency, giving them the currency if they have none.
+func (s *session) ApplyCoupon(c domain.Coupon) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	applying, err := c.In(s.currency)
+	if err != nil {
+		return fmt.Errorf("coupon '%s' %w, session is in %s", c.Code, err, s.currency)
+	}
+	for _, applied := range s.coupons {
+		if coupon.Normalize(applied.Code) == coupon.Normalize(c.Code) {
+			return fmt.Errorf("coupon '%s' %w", c.Code, ErrCouponAlreadyApplied)
+		}
+	}
+	if len(s.coupons) > 0 && (!c.Stackable || !s.coupons[0].Stackable) {
+		return fmt.Errorf("coupon '%s' %w", c.Code, ErrCouponNotStackable)
+	}
+	s.coupons = append(s.coupons, applying)
+	s.touch()
+	return nil
+}
+
+// RemoveCoupon takes a coupon off the session, matching its code in any case.
+func (s *session) RemoveCoupon(code string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	for i, applied := range s.coupons {
+		if coupon.Normalize(applied.Code) == coupon.Normalize(code) {
+			s.coupons = append(s.coupons[:i:i], s.coupons[i+1:]...)
+			s.touch()
+			return nil
+		}
+	}
+	return fmt.Errorf("coupon '%s' %w", strings.TrimSpace(code), ErrCouponNotApplied)
+}
+
+// GetCoupons returns the coupons applied to the session, in the order they
+// were applied.
+func (s *session) GetCoupons() []domain.Coupon {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]domain.Coupon(nil), s.coupons...)
+}
+
+// checkScanned reports why an SKU cannot be removed from the session, if it
+// cannot. SKUs already in the session can always be removed, even if their
+// pricing rule has since been withdrawn.
+func (s *session) checkScanned(SKU string) error {
+	if s.inBasket(SKU) {
+		return nil
+	}
+	prices, err := s.currentPrices()
+	if err != nil {
+		return fmt.Errorf("sku '%s' %w", SKU, err)
+	}
+	if _, exists := prices.Rules[SKU]; !exists {
+		return fmt.Errorf("sku '%s' %w", SKU, ErrUnknownSKU)
+	}
+	return fmt.Errorf("sku '%s' %w", SKU, ErrItemNotScanned)
+}
+
+// GetTotalPrice calculates the total price for the session based on current
+// pricing rules, returning domain.ErrOverflow if it is too large to represent.
+func (s *session) GetTotalPrice() (totalPrice domain.Money, err error) {
+	breakdown, err := s.GetBreakdown()
+	if err != nil {
+		return domain.Money{}, err
+	}
+	return breakdown.TotalPrice, nil
+}
+
+// GetBreakdown prices every scanned SKU at the rule locked for it and returns
+// one line per SKU, ordered by SKU. Scheduled offers are applied if they are
+// active now or, once the session is closed, when it was closed.
+func (s *session) GetBreakdown() (breakdown domain.Breakdown, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	at := s.now()
+	if s.state != domain.StateOpen {
+		at = s.updatedAt
+	}
+	return s.breakdown(at)
+}
+
+// GetState returns the lifecycle state of the session.
+func (s *session) GetState() domain.SessionState {
+	s.RLock()
+	defer s.RUnlock()
+	return s.state
+}
+
+// Complete freezes the session, recording its final total, the pricing
+// rules, promotions, basket discounts and tax rate table locked for it and
+// its coupons. No further changes
+// can be made to the session.
+func (s *session) Complete() (completion domain.Completion, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return domain.Completion{}, err
+	}
+	pricing := make(map[string]domain.PricingRule, len(s.scannedItems))
+	for sku := range s.lockedRules {
+		if s.inBasket(sku) {
+			pricing[sku] = s.lockedRules[sku].rule
+		}
+	}
+	completedAt := s.now().UTC()
+	breakdown, err := s.breakdown(completedAt)
+	if err != nil {
+		return domain.Completion{}, err
+	}
+	completion = domain.Completion{
+		CompletedAt: completedAt,
+		FinalTotal:  breakdown.TotalPrice,
+		Pricing:     pricing,
+		Coupons:     append([]domain.Coupon(nil), s.coupons...),
+	}
+	if s.promotions != nil {
+		completion.Promotions = s.promotions.specs
+		completion.BasketDiscounts = s.promotions.basketDiscounts
+		completion.Tax = s.promotions.tax
+	}
+	s.state = domain.StateCompleted
+	s.completion = &completion
+	s.updatedAt = completion.CompletedAt
+	return completion, nil
+}
+
+// Cancel abandons the session. No further changes can be made to the session.
+func (s *session) Cancel() (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.state = domain.StateCancelled
+	s.touch()
+	return nil
+}
+
+// GetCompletion returns a copy of the session's completion record, or nil if
+// the session has not been completed.
+func (s *session) GetCompletion() *domain.Completion {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.completion == nil {
+		return nil
+	}
+	completion := *s.completion
+	completion.Pricing = make(map[string]domain.PricingRule, len(s.completion.Pricing))
+	for sku, rule := range s.completion.Pricing {
+		completion.Pricing[sku] = rule
+	}
+	completion.Promotions = append([]domain.PromotionSpec(nil), s.completion.Promotions...)
+	completion.BasketDiscounts = append([]domain.BasketDiscount(nil), s.completion.BasketDiscounts...)
+	completion.Coupons = append([]domain.Coupon(nil), s.completion.Coupons...)
+	return &completion
+}
+
+// checkCounted returns ErrSoldByMeasure if an SKU charged at rule is sold by
+// measure, and so cannot be counted unit by unit.
+func (s *session) checkCounted(SKU string, rule domain.PricingRule) error {
+	if unit := rule.Unit; unit.Measured() {
+		return fmt.Errorf("sku '%s' %w, by the %s", SKU, ErrSoldByMeasure, unit)
+	}
+	return nil
+}
+
+// inBasket reports whether any of an SKU is in the session.
+func (s *session) inBasket(SKU string) bool {
+	return s.scannedItems[SKU] > 0 || len(s.markedPrices[SKU]) > 0
+}
+
+// checkTotal returns domain.ErrOverflow if the basket would be too large to
+// price with quantity units and packs marked at the given prices of an SKU
+// charged at rule, before any discounts.
+func (s *session) checkTotal(SKU string, rule domain.PricingRule, quantity int, marked []int64) error {
+	total, err := s.linePrice(rule, quantity, marked)
+	if err != nil {
+		return fmt.Errorf("sku '%s' %w", SKU, err)
+	}
+	for sku, locked := range s.lockedRules {
+		if sku == SKU {
+			continue
+		}
+		lineTotal, err := s.linePrice(locked.rule, s.scannedItems[sku], s.markedPrices[sku])
+		if err == nil {
+			total, err = total.Add(lineTotal)
+		}
+		if err != nil {
+			return fmt.Errorf("sku '%s' %w", SKU, err)
+		}
+	}
+	return nil
+}
+
+// linePrice returns the price of a quantity of an SKU at its rule's unit
+// price, before offers, plus the prices marked on any packs of it. The
+// quantity of an SKU sold by measure is in thousandths of its unit.
+func (s *session) linePrice(rule domain.PricingRule, quantity int, marked []int64) (price domain.Money, err error) {
+	if rule.Unit.Measured() {
+		price, err = domain.Measure(quantity).Price(rule.UnitPrice, rule.Rounding)
+	} else {
+		price, err = rule.UnitPrice.Mul(int64(quantity))
+	}
+	for _, amount := range marked {
+		if err != nil {
+			return domain.Money{}, err
+		}
+		price, err = price.Add(domain.Money{Amount: amount, Currency: s.currency})
+	}
+	return price, err
+}
+
+// money returns an amount in minor units of the session's currency.
+func (s *session) money(amount int64) domain.Money {
+	return domain.Money{Amount: int64(amount), Currency: s.currency}
+}
+
+// touch records that the session has just changed.
+func (s *session) touch() {
+	s.updatedAt = s.now().UTC()
+}
+
+// checkOpen returns ErrSessionNotOpen unless the session can still be changed.
+func (s *session) checkOpen() error {
+	if s.state != domain.StateOpen {
+		return fmt.Errorf("%w: it has been %s", ErrSessionNotOpen, s.state)
+	}
+	return nil
+}
+
+// breakdown prices the scanned items with their locked rules, applying the
+// offers of those rules and the locked price list promotions active at a
+// moment to the lines, then the locked basket discounts and the session's
+// coupons to their subtotal, and works out the tax on what is left. It
+// returns domain.ErrOverflow if any amount is too large to represent.
+func (s *session) breakdown(at time.Time) (breakdown domain.Breakdown, err error) {
+	// Offers count units, so SKUs sold by measure are left out of the basket
+	// they are applied to.
+	basket := domain.Basket{
+		Items:      make(map[string]int, len(s.scannedItems)),
+		UnitPrices: make(map[string]int64, len(s.scannedItems)),
+		Currency:   s.currency,
+	}
+	skus := make([]string, 0, len(s.scannedItems))
 	for sku, count := range s.scannedItems {
-		rule := rules[sku]
-		if rule.SpecialPrice != nil && count >= rule.SpecialPrice.Quantity {
-			numOffers := count / rule.SpecialPrice.Quantity
-			totalPrice += numOffers * rule.SpecialPrice.Price
-			remaining := count % rule.SpecialPrice.Quantity
-			totalPrice += remaining * rule.UnitPrice
-		} else {
-			totalPrice += count * rule.UnitPrice
+		skus = append(skus, sku)
+		if s.lockedRules[sku].rule.Unit.Measured() {
+			continue
+		}
+		basket.Items[sku] = count
+		basket.UnitPrices[sku] = s.lockedRules[sku].rule.UnitPrice.Amount
+	}
+	for sku := range s.markedPrices {
+		if _, scanned := s.scannedItems[sku]; !scanned {
+			skus = append(skus, sku)
+		}
+	}
+	sort.Strings(skus)
+
+	var promotions []domain.Promotion
+	for _, sku := range skus {
+		if rule := s.lockedRules[sku].rule; !rule.Unit.Measured() {
+			promotions = append(promotions, rulePromotions(sku, rule)...)
+		}
+	}
+	if s.promotions != nil {
+		promotions = append(promotions, s.promotions.promotions...)
+	}
+	result, err := promotion.Apply(basket, promotion.ActiveAt(promotions, at))
+	if err != nil {
+		return domain.Breakdown{}, err
+	}
+
+	breakdown.Lines = make([]domain.LineItem, 0, len(skus))
+	breakdown.Subtotal = s.money(0)
+	breakdown.TotalSavings = s.money(0)
+	for _, sku := range skus {
+		locked := s.lockedRules[sku]
+		count := s.scannedItems[sku]
+		atUnitPrice, err := s.linePrice(locked.rule, count, s.markedPrices[sku])
+		if err != nil {
+			return domain.Breakdown{}, fmt.Errorf("sku '%s' %w", sku, err)
+		}
+		lineTotal, err := atUnitPrice.Sub(s.money(result.Discounts[sku]))
+		if err != nil {
+			return domain.Breakdown{}, fmt.Errorf("sku '%s' %w", sku, err)
+		}
+		line := domain.LineItem{
+			SKU:            sku,
+			Quantity:       count,
+			UnitPrice:      locked.rule.UnitPrice,
+			OffersApplied:  result.Applications[sku],
+			LineTotal:      lineTotal,
+			Savings:        s.money(result.Discounts[sku]),
+			PricingVersion: locked.version,
+		}
+		if s.promotions != nil && s.promotions.tax != nil {
+			line.TaxCategory = tax.CategoryOf(*s.promotions.tax, locked.rule)
+		}
+		if locked.rule.Unit.Measured() {
+			line.Unit = locked.rule.Unit
+			line.Measure = domain.Measure(count)
+			line.Packs = len(s.markedPrices[sku])
+		}
+		breakdown.Lines = append(breakdown.Lines, line)
+		if breakdown.TotalSavings, err = breakdown.TotalSavings.Add(line.Savings); err != nil {
+			return domain.Breakdown{}, err
+		}
+		if breakdown.Subtotal, err = breakdown.Subtotal.Add(line.LineTotal); err != nil {
+			return domain.Breakdown{}, err
+		}
+	}
+	breakdown.Promotions = result.Applied
+
+	var basketDiscounts []domain.BasketDiscount
+	if s.promotions != nil {
+		basketDiscounts = s.promotions.basketDiscounts
+	}
+	if breakdown.Discounts, err = promotion.ApplyBasketDiscounts(breakdown.Subtotal, basketDiscounts); err != nil {
+		return domain.Breakdown{}, err
+	}
+	afterBasketDiscounts := breakdown.Subtotal
+	for _, discount := range breakdown.Discounts {
+		if afterBasketDiscounts, err = afterBasketDiscounts.Sub(discount.Amount); err != nil {
+			return domain.Breakdown{}, err
+		}
+	}
+	coupons, err := coupon.Apply(breakdown.Subtotal, afterBasketDiscounts, s.coupons)
+	if err != nil {
+		return domain.Breakdown{}, err
+	}
+	breakdown.Discounts = append(breakdown.Discounts, coupons...)
+	discounted := s.money(0)
+	for _, discount := range breakdown.Discounts {
+		if discounted, err = discounted.Add(discount.Amount); err != nil {
+			return domain.Breakdown{}, err
+		}
+	}
+	if breakdown.TotalSavings, err = breakdown.TotalSavings.Add(discounted); err != nil {
+		return domain.Breakdown{}, err
+	}
+	if breakdown.TotalPrice, err = breakdown.Subtotal.Sub(discounted); err != nil {
+		return domain.Breakdown{}, err
+	}
+
+	if s.promotions != nil && s.promotions.tax != nil {
+		summary, err := tax.Calculate(*s.promotions.tax, breakdown.Lines, discounted)
+		if err != nil {
+			return domain.Breakdown{}, err
+		}
+		breakdown.Tax = &summary
+		breakdown.TotalPrice = summary.Gross
+	}
+	return breakdown, nil
+}
+
+// rulePromotions re-expresses the offers of an SKU's pricing rule as
+// promotions, its special price first, limited to the rule's schedule. Offers
+// that cannot be applied are ignored, as special prices always were.
+func rulePromotions(sku string, rule domain.PricingRule) (promotions []domain.Promotion) {
+	if rule.Schedule != nil {
+		schedule, err := promotion.NewSchedule(*rule.Schedule)
+		if err != nil {
+			return nil
+		}
+		unscheduled := rule
+		unscheduled.Schedule = nil
+		for _, offer := range rulePromotions(sku, unscheduled) {
+			promotions = append(promotions, promotion.WithSchedule(offer, schedule))
+		}
+		return promotions
+	}
+
+	if rule.SpecialPrice != nil {
+		if multiBuy, err := promotion.FromSpecialPrice(sku, *rule.SpecialPrice); err == nil {
+			promotions = append(promotions, multiBuy)
+		}
+	}
+	if rule.BuyGet != nil {
+		if buyGet, err := promotion.FromBuyGet(sku, *rule.BuyGet); err == nil {
+			promotions = append(promotions, buyGet)
+		}
+	}
+	if rule.Tiers != nil {
+		if tiered, err := promotion.FromTiers(sku, *rule.Tiers); err == nil {
+			promotions = append(promotions, tiered)
 		}
 	}
-	return totalPrice, nil
+	return promotions
 }
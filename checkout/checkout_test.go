@@ -1,21 +1,45 @@
 package checkout
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/barcode"
 )
 
 type mockPricingService struct{}
 
-func (m *mockPricingService) GetRules() map[string]domain.PricingRule {
-	return map[string]domain.PricingRule{
-		"A": {UnitPrice: 50, SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: 130}},
-		"B": {UnitPrice: 30, SpecialPrice: &domain.SpecialPrice{Quantity: 2, Price: 45}},
-		"C": {UnitPrice: 20, SpecialPrice: nil},
-		"D": {UnitPrice: 15, SpecialPrice: nil},
+func (m *mockPricingService) GetPriceList() domain.PriceList {
+	return domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: gbp(130)}},
+			"B": {UnitPrice: gbp(30), SpecialPrice: &domain.SpecialPrice{Quantity: 2, Price: gbp(45)}},
+			"C": {UnitPrice: gbp(20), SpecialPrice: nil},
+			"D": {UnitPrice: gbp(15), SpecialPrice: nil},
+		},
+	}
+}
+
+// newSession creates a session, failing the test if it cannot be created.
+func newSession(t *testing.T, pricer PricingService, opts ...Option) domain.ICheckout {
+	t.Helper()
+	co, err := New(pricer, opts...)
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
 	}
+	return co
+}
+
+// gbp returns an amount in pence, the currency of the test price lists.
+func gbp(amount int) domain.Money {
+	return domain.Money{Amount: int64(amount), Currency: "GBP"}
 }
 
 func TestScan(t *testing.T) {
@@ -79,8 +103,12 @@ func TestScan(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			s := &session{
 				id:           "test-session-id",
+				state:        domain.StateOpen,
 				scannedItems: tc.initialItems,
+				lockedRules:  make(map[string]lockedRule),
+				currency:     "GBP",
 				pricer:       mockPricer,
+				now:          time.Now,
 			}
 
 			err := s.Scan(tc.skuToScan)
@@ -130,7 +158,7 @@ func TestCheckoutTotalPrice(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockPricer := &mockPricingService{}
-			co := New(mockPricer)
+			co := newSession(t, mockPricer)
 			var scanErr error
 			for _, sku := range tc.skusToScan {
 				if err := co.Scan(sku); err != nil {
@@ -151,9 +179,1392 @@ func TestCheckoutTotalPrice(t *testing.T) {
 			if err != nil {
 				t.Fatalf("GetTotalPrice() returned an unexpected error: %v", err)
 			}
-			if total != tc.expectedTotal {
-				t.Errorf("Expected total price to be %d, but got %d", tc.expectedTotal, total)
+			if total != gbp(tc.expectedTotal) {
+				t.Errorf("Expected total price to be %d, but got %d", tc.expectedTotal, total.Amount)
+			}
+		})
+	}
+}
+
+func TestGetBreakdown(t *testing.T) {
+	co := newSession(t, &mockPricingService{})
+	for _, sku := range []string{"C", "B", "A", "B", "A", "A", "A", "D"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+
+	expectedLines := []domain.LineItem{
+		{SKU: "A", Quantity: 4, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(180), Savings: gbp(20), PricingVersion: 1},
+		{SKU: "B", Quantity: 2, UnitPrice: gbp(30), OffersApplied: 1, LineTotal: gbp(45), Savings: gbp(15), PricingVersion: 1},
+		{SKU: "C", Quantity: 1, UnitPrice: gbp(20), OffersApplied: 0, LineTotal: gbp(20), Savings: gbp(0), PricingVersion: 1},
+		{SKU: "D", Quantity: 1, UnitPrice: gbp(15), OffersApplied: 0, LineTotal: gbp(15), Savings: gbp(0), PricingVersion: 1},
+	}
+	if len(breakdown.Lines) != len(expectedLines) {
+		t.Fatalf("Expected %d lines, got %d", len(expectedLines), len(breakdown.Lines))
+	}
+	for i, expected := range expectedLines {
+		if breakdown.Lines[i] != expected {
+			t.Errorf("Line %d: expected %+v, got %+v", i, expected, breakdown.Lines[i])
+		}
+	}
+	if breakdown.TotalSavings != gbp(35) {
+		t.Errorf("Expected total savings to be 35, but got %d", breakdown.TotalSavings.Amount)
+	}
+	if breakdown.TotalPrice != gbp(260) {
+		t.Errorf("Expected total price to be 260, but got %d", breakdown.TotalPrice.Amount)
+	}
+
+	total, err := co.GetTotalPrice()
+	if err != nil {
+		t.Fatalf("GetTotalPrice() returned an unexpected error: %v", err)
+	}
+	if total != breakdown.TotalPrice {
+		t.Errorf("Expected GetTotalPrice() to match the breakdown total %d, got %d", breakdown.TotalPrice.Amount, total.Amount)
+	}
+}
+
+func TestBuyGetRules(t *testing.T) {
+	pricer := &switchablePricingService{prices: domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), BuyGet: &domain.BuyGet{Buy: 1, Get: 1, GetSKU: "C", PercentOff: 100}},
+			"B": {UnitPrice: gbp(30), BuyGet: &domain.BuyGet{Buy: 2, Get: 1, PercentOff: 100}},
+			"C": {UnitPrice: gbp(20)},
+			"D": {UnitPrice: gbp(15), BuyGet: &domain.BuyGet{Buy: 1, Get: 1, PercentOff: 50}},
+		},
+	}}
+	co := newSession(t, pricer)
+	for _, sku := range []string{"A", "B", "B", "B", "C", "C", "D", "D"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	expectedLines := []domain.LineItem{
+		{SKU: "A", Quantity: 1, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(50), Savings: gbp(0), PricingVersion: 1},
+		{SKU: "B", Quantity: 3, UnitPrice: gbp(30), OffersApplied: 1, LineTotal: gbp(60), Savings: gbp(30), PricingVersion: 1},
+		{SKU: "C", Quantity: 2, UnitPrice: gbp(20), OffersApplied: 1, LineTotal: gbp(20), Savings: gbp(20), PricingVersion: 1},
+		{SKU: "D", Quantity: 2, UnitPrice: gbp(15), OffersApplied: 1, LineTotal: gbp(23), Savings: gbp(7), PricingVersion: 1},
+	}
+	if len(breakdown.Lines) != len(expectedLines) {
+		t.Fatalf("Expected %d lines, got %d", len(expectedLines), len(breakdown.Lines))
+	}
+	for i, expected := range expectedLines {
+		if breakdown.Lines[i] != expected {
+			t.Errorf("Line %d: expected %+v, got %+v", i, expected, breakdown.Lines[i])
+		}
+	}
+	if breakdown.TotalPrice != gbp(153) {
+		t.Errorf("Expected total price to be 153, but got %d", breakdown.TotalPrice.Amount)
+	}
+}
+
+func TestTieredRules(t *testing.T) {
+	tiers := []domain.PriceTier{{MinQuantity: 10, UnitPrice: gbp(45)}, {MinQuantity: 50, UnitPrice: gbp(40)}}
+	pricer := &switchablePricingService{prices: domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), Tiers: &domain.TieredPrice{Mode: domain.TierAllUnits, Tiers: tiers}},
+			"B": {UnitPrice: gbp(50), Tiers: &domain.TieredPrice{Mode: domain.TierGraduated, Tiers: tiers}},
+		},
+	}}
+	co := newSession(t, pricer)
+	if err := co.SetQuantity("A", 12); err != nil {
+		t.Fatalf("SetQuantity() returned an unexpected error: %v", err)
+	}
+	if err := co.SetQuantity("B", 12); err != nil {
+		t.Fatalf("SetQuantity() returned an unexpected error: %v", err)
+	}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	expectedLines := []domain.LineItem{
+		{SKU: "A", Quantity: 12, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(540), Savings: gbp(60), PricingVersion: 1},
+		{SKU: "B", Quantity: 12, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(585), Savings: gbp(15), PricingVersion: 1},
+	}
+	for i, expected := range expectedLines {
+		if breakdown.Lines[i] != expected {
+			t.Errorf("Line %d: expected %+v, got %+v", i, expected, breakdown.Lines[i])
+		}
+	}
+}
+
+func TestRemoveVoidAndSetQuantity(t *testing.T) {
+	mockPricer := &mockPricingService{}
+
+	testCases := []struct {
+		name          string
+		initialItems  map[string]int
+		change        func(co *session) error
+		expectedErr   error
+		expectedItems map[string]int
+	}{
+		{
+			name:          "Remove one of several units",
+			initialItems:  map[string]int{"A": 3},
+			change:        func(co *session) error { return co.Remove("A") },
+			expectedItems: map[string]int{"A": 2},
+		},
+		{
+			name:          "Remove the last unit drops the SKU",
+			initialItems:  map[string]int{"A": 1, "B": 1},
+			change:        func(co *session) error { return co.Remove("A") },
+			expectedItems: map[string]int{"B": 1},
+		},
+		{
+			name:          "Remove an SKU that was never scanned",
+			initialItems:  map[string]int{"A": 1},
+			change:        func(co *session) error { return co.Remove("B") },
+			expectedErr:   ErrItemNotScanned,
+			expectedItems: map[string]int{"A": 1},
+		},
+		{
+			name:          "Remove an unknown SKU",
+			initialItems:  make(map[string]int),
+			change:        func(co *session) error { return co.Remove("Z") },
+			expectedErr:   ErrUnknownSKU,
+			expectedItems: make(map[string]int),
+		},
+		{
+			name:          "Remove a scanned SKU whose rule was withdrawn",
+			initialItems:  map[string]int{"Z": 2},
+			change:        func(co *session) error { return co.Remove("Z") },
+			expectedItems: map[string]int{"Z": 1},
+		},
+		{
+			name:          "Void a line",
+			initialItems:  map[string]int{"A": 4, "C": 1},
+			change:        func(co *session) error { return co.Void("A") },
+			expectedItems: map[string]int{"C": 1},
+		},
+		{
+			name:          "Void an SKU that was never scanned",
+			initialItems:  map[string]int{"A": 1},
+			change:        func(co *session) error { return co.Void("D") },
+			expectedErr:   ErrItemNotScanned,
+			expectedItems: map[string]int{"A": 1},
+		},
+		{
+			name:          "Set quantity of a new SKU",
+			initialItems:  make(map[string]int),
+			change:        func(co *session) error { return co.SetQuantity("B", 5) },
+			expectedItems: map[string]int{"B": 5},
+		},
+		{
+			name:          "Set quantity of a scanned SKU",
+			initialItems:  map[string]int{"A": 4},
+			change:        func(co *session) error { return co.SetQuantity("A", 2) },
+			expectedItems: map[string]int{"A": 2},
+		},
+		{
+			name:          "Set quantity to zero drops the SKU",
+			initialItems:  map[string]int{"A": 4, "B": 1},
+			change:        func(co *session) error { return co.SetQuantity("A", 0) },
+			expectedItems: map[string]int{"B": 1},
+		},
+		{
+			name:          "Set a negative quantity",
+			initialItems:  map[string]int{"A": 4},
+			change:        func(co *session) error { return co.SetQuantity("A", -1) },
+			expectedErr:   ErrInvalidQuantity,
+			expectedItems: map[string]int{"A": 4},
+		},
+		{
+			name:          "Set quantity of an unknown SKU",
+			initialItems:  make(map[string]int),
+			change:        func(co *session) error { return co.SetQuantity("Z", 1) },
+			expectedErr:   ErrUnknownSKU,
+			expectedItems: make(map[string]int),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &session{
+				id:           "test-session-id",
+				state:        domain.StateOpen,
+				scannedItems: tc.initialItems,
+				lockedRules:  make(map[string]lockedRule),
+				currency:     "GBP",
+				pricer:       mockPricer,
+				now:          time.Now,
+			}
+
+			err := tc.change(s)
+
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Errorf("Expected error: %v, but got: %v", tc.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, but got: %v", err)
+			}
+
+			if len(s.scannedItems) != len(tc.expectedItems) {
+				t.Errorf("Expected scannedItems length %d, got %d", len(tc.expectedItems), len(s.scannedItems))
+			}
+			for sku, count := range tc.expectedItems {
+				if s.scannedItems[sku] != count {
+					t.Errorf("For SKU %q: Expected count %d, got %d", sku, count, s.scannedItems[sku])
+				}
+			}
+		})
+	}
+}
+
+func TestTotalPriceAfterRemovingAcrossOfferThreshold(t *testing.T) {
+	co := newSession(t, &mockPricingService{})
+	for _, sku := range []string{"A", "A", "A", "B", "B"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name          string
+		change        func() error
+		expectedTotal int
+	}{
+		{name: "Both offers applied", change: func() error { return nil }, expectedTotal: 175},
+		{name: "Removing an A breaks its offer", change: func() error { return co.Remove("A") }, expectedTotal: 145},
+		{name: "Raising A to four restores its offer", change: func() error { return co.SetQuantity("A", 4) }, expectedTotal: 225},
+		{name: "Voiding B drops its offer entirely", change: func() error { return co.Void("B") }, expectedTotal: 180},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		total, err := co.GetTotalPrice()
+		if err != nil {
+			t.Fatalf("%s: GetTotalPrice() returned an unexpected error: %v", step.name, err)
+		}
+		if total != gbp(step.expectedTotal) {
+			t.Errorf("%s: expected total price to be %d, but got %d", step.name, step.expectedTotal, total.Amount)
+		}
+	}
+}
+
+// switchablePricingService serves whichever price list it currently holds, to
+// simulate a hot-reload of pricing.json.
+type switchablePricingService struct {
+	prices domain.PriceList
+}
+
+func (m *switchablePricingService) GetPriceList() domain.PriceList {
+	return m.prices
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	t.Run("completing freezes the basket and its pricing", func(t *testing.T) {
+		pricer := &switchablePricingService{prices: (&mockPricingService{}).GetPriceList()}
+		co := newSession(t, pricer)
+		for _, sku := range []string{"A", "A", "A", "C"} {
+			if err := co.Scan(sku); err != nil {
+				t.Fatalf("Got unexpected error during scan: %v", err)
+			}
+		}
+
+		completion, err := co.Complete()
+		if err != nil {
+			t.Fatalf("Complete() returned an unexpected error: %v", err)
+		}
+		if co.GetState() != domain.StateCompleted {
+			t.Errorf("Expected state %q, got %q", domain.StateCompleted, co.GetState())
+		}
+		if completion.FinalTotal != gbp(150) {
+			t.Errorf("Expected final total 150, got %d", completion.FinalTotal.Amount)
+		}
+		if len(completion.Pricing) != 2 || completion.Pricing["A"].UnitPrice != gbp(50) || completion.Pricing["C"].UnitPrice != gbp(20) {
+			t.Errorf("Expected pricing snapshot for A and C, got %+v", completion.Pricing)
+		}
+		if completion.CompletedAt.IsZero() {
+			t.Error("Expected the completion time to be recorded")
+		}
+
+		pricer.prices = domain.PriceList{Version: 2, Rules: map[string]domain.PricingRule{"A": {UnitPrice: gbp(99)}, "C": {UnitPrice: gbp(99)}}}
+		total, err := co.GetTotalPrice()
+		if err != nil {
+			t.Fatalf("GetTotalPrice() returned an unexpected error: %v", err)
+		}
+		if total != gbp(150) {
+			t.Errorf("Expected a completed session to keep its total of 150 after a price change, got %d", total.Amount)
+		}
+	})
+
+	t.Run("changes are rejected once a session is not open", func(t *testing.T) {
+		completed := newSession(t, &mockPricingService{})
+		if _, err := completed.Complete(); err != nil {
+			t.Fatalf("Complete() returned an unexpected error: %v", err)
+		}
+		cancelled := newSession(t, &mockPricingService{})
+		if err := cancelled.Cancel(); err != nil {
+			t.Fatalf("Cancel() returned an unexpected error: %v", err)
+		}
+		if cancelled.GetState() != domain.StateCancelled {
+			t.Errorf("Expected state %q, got %q", domain.StateCancelled, cancelled.GetState())
+		}
+
+		for _, co := range []domain.ICheckout{completed, cancelled} {
+			changes := map[string]func() error{
+				"Scan":        func() error { return co.Scan("A") },
+				"Remove":      func() error { return co.Remove("A") },
+				"Void":        func() error { return co.Void("A") },
+				"SetQuantity": func() error { return co.SetQuantity("A", 2) },
+				"Complete":    func() error { _, err := co.Complete(); return err },
+				"Cancel":      func() error { return co.Cancel() },
+			}
+			for name, change := range changes {
+				if err := change(); !errors.Is(err, ErrSessionNotOpen) {
+					t.Errorf("%s on a %s session: expected ErrSessionNotOpen, got %v", name, co.GetState(), err)
+				}
+			}
+		}
+		if completed.GetCompletion() == nil {
+			t.Error("Expected a completed session to have a completion record")
+		}
+		if cancelled.GetCompletion() != nil {
+			t.Error("Expected a cancelled session to have no completion record")
+		}
+	})
+}
+
+func TestPriceLocking(t *testing.T) {
+	reloaded := domain.PriceList{
+		Version: 2,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(60), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: gbp(150)}},
+			"C": {UnitPrice: gbp(25)},
+			"E": {UnitPrice: gbp(10)},
+		},
+	}
+
+	t.Run("lock on scan keeps the price each SKU was first scanned at", func(t *testing.T) {
+		pricer := &switchablePricingService{prices: (&mockPricingService{}).GetPriceList()}
+		co := newSession(t, pricer)
+		if err := co.Scan("A"); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+
+		pricer.prices = reloaded
+		for _, sku := range []string{"A", "A", "C", "E"} {
+			if err := co.Scan(sku); err != nil {
+				t.Fatalf("Got unexpected error during scan: %v", err)
+			}
+		}
+
+		breakdown, err := co.GetBreakdown()
+		if err != nil {
+			t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+		}
+		expectedLines := []domain.LineItem{
+			{SKU: "A", Quantity: 3, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(130), Savings: gbp(20), PricingVersion: 1},
+			{SKU: "C", Quantity: 1, UnitPrice: gbp(25), LineTotal: gbp(25), Savings: gbp(0), PricingVersion: 2},
+			{SKU: "E", Quantity: 1, UnitPrice: gbp(10), LineTotal: gbp(10), Savings: gbp(0), PricingVersion: 2},
+		}
+		if len(breakdown.Lines) != len(expectedLines) {
+			t.Fatalf("Expected %d lines, got %d", len(expectedLines), len(breakdown.Lines))
+		}
+		for i, expected := range expectedLines {
+			if breakdown.Lines[i] != expected {
+				t.Errorf("Line %d: expected %+v, got %+v", i, expected, breakdown.Lines[i])
+			}
+		}
+	})
+
+	t.Run("lock on start prices the whole session at the price list it started with", func(t *testing.T) {
+		pricer := &switchablePricingService{prices: (&mockPricingService{}).GetPriceList()}
+		co := newSession(t, pricer, WithPriceLock(LockOnStart))
+
+		pricer.prices = reloaded
+		for _, sku := range []string{"A", "C"} {
+			if err := co.Scan(sku); err != nil {
+				t.Fatalf("Got unexpected error during scan: %v", err)
+			}
+		}
+		if err := co.Scan("E"); !errors.Is(err, ErrUnknownSKU) {
+			t.Errorf("Expected ErrUnknownSKU scanning an SKU added after the session started, got %v", err)
+		}
+
+		breakdown, err := co.GetBreakdown()
+		if err != nil {
+			t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+		}
+		if breakdown.TotalPrice != gbp(70) {
+			t.Errorf("Expected total price to be 70, but got %d", breakdown.TotalPrice.Amount)
+		}
+		for _, line := range breakdown.Lines {
+			if line.PricingVersion != 1 {
+				t.Errorf("Expected line %s to be priced against version 1, got %d", line.SKU, line.PricingVersion)
+			}
+		}
+	})
+}
+
+func TestPriceListPromotions(t *testing.T) {
+	prices := domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: gbp(130)}},
+			"C": {UnitPrice: gbp(20)},
+		},
+		Promotions: []domain.PromotionSpec{
+			{ID: "c-pair", Type: "multibuy", Params: json.RawMessage(`{"sku":"C","quantity":2,"price":30}`)},
+		},
+	}
+	pricer := &switchablePricingService{prices: prices}
+	co := newSession(t, pricer)
+	for _, sku := range []string{"A", "A", "A", "C", "C", "C"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	// Promotions are locked with the first scan, so withdrawing one does not
+	// reprice the basket.
+	pricer.prices = domain.PriceList{Version: 2, Rules: prices.Rules}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	if breakdown.TotalPrice != gbp(180) || breakdown.TotalSavings != gbp(30) {
+		t.Errorf("Expected total 180 with savings 30, got total %d with savings %d", breakdown.TotalPrice.Amount, breakdown.TotalSavings.Amount)
+	}
+	expectedPromotions := []domain.AppliedPromotion{
+		{ID: "multibuy-A", Type: "multibuy", Applications: 1, Savings: gbp(20)},
+		{ID: "c-pair", Type: "multibuy", Applications: 1, Savings: gbp(10)},
+	}
+	if !reflect.DeepEqual(breakdown.Promotions, expectedPromotions) {
+		t.Errorf("Expected promotions %+v, got %+v", expectedPromotions, breakdown.Promotions)
+	}
+
+	completion, err := co.Complete()
+	if err != nil {
+		t.Fatalf("Complete() returned an unexpected error: %v", err)
+	}
+	if len(completion.Promotions) != 1 || completion.Promotions[0].ID != "c-pair" {
+		t.Errorf("Expected the completion to record promotion c-pair, got %+v", completion.Promotions)
+	}
+
+	t.Run("invalid promotions are rejected on scan", func(t *testing.T) {
+		broken := prices
+		broken.Promotions = []domain.PromotionSpec{{ID: "x", Type: "mystery", Params: json.RawMessage(`{}`)}}
+		if err := newSession(t, &switchablePricingService{prices: broken}).Scan("A"); err == nil {
+			t.Error("Expected an error scanning against a price list with an unknown promotion type, but got nil")
+		}
+	})
+}
+
+func TestScheduledOffers(t *testing.T) {
+	happyHour := &domain.Schedule{Windows: []domain.RecurringWindow{{Start: "17:00", End: "19:00"}}}
+	prices := domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: gbp(130)}, Schedule: happyHour},
+			"C": {UnitPrice: gbp(20)},
+		},
+		Promotions: []domain.PromotionSpec{
+			{ID: "c-pair", Type: "multibuy", Params: json.RawMessage(`{"sku":"C","quantity":2,"price":30}`), Schedule: happyHour},
+		},
+	}
+	now := time.Date(2025, 7, 7, 16, 0, 0, 0, time.UTC)
+	co := newSession(t, &switchablePricingService{prices: prices}, WithClock(func() time.Time { return now }))
+	for _, sku := range []string{"A", "A", "A", "C", "C"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		at       time.Time
+		expected int
+	}{
+		{at: time.Date(2025, 7, 7, 16, 59, 0, 0, time.UTC), expected: 190},
+		{at: time.Date(2025, 7, 7, 17, 0, 0, 0, time.UTC), expected: 160},
+		{at: time.Date(2025, 7, 7, 19, 0, 0, 0, time.UTC), expected: 190},
+	} {
+		now = tc.at
+		if total, _ := co.GetTotalPrice(); total != gbp(tc.expected) {
+			t.Errorf("Expected total %d at %s, got %d", tc.expected, tc.at.Format("15:04"), total.Amount)
+		}
+	}
+
+	// A completed session stays priced as it was at completion.
+	now = time.Date(2025, 7, 7, 18, 0, 0, 0, time.UTC)
+	completion, err := co.Complete()
+	if err != nil {
+		t.Fatalf("Complete() returned an unexpected error: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if total, _ := co.GetTotalPrice(); total != gbp(160) || completion.FinalTotal != gbp(160) {
+		t.Errorf("Expected a final total of 160 after happy hour ends, got %d (completion %d)", total.Amount, completion.FinalTotal.Amount)
+	}
+}
+
+func TestBasketDiscounts(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	prices.BasketDiscounts = []domain.BasketDiscount{
+		{ID: "ten-percent", Type: domain.DiscountPercent, SpendOver: gbp(200), Percent: 10},
+	}
+	pricer := &switchablePricingService{prices: prices}
+	co := newSession(t, pricer)
+	for _, sku := range []string{"A", "A", "A", "B", "B", "C", "D"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	// Basket discounts are locked with the first scan.
+	pricer.prices = (&mockPricingService{}).GetPriceList()
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	expectedDiscounts := []domain.DiscountLine{{ID: "ten-percent", Type: "percent", Amount: gbp(21)}}
+	if breakdown.Subtotal != gbp(210) || !reflect.DeepEqual(breakdown.Discounts, expectedDiscounts) {
+		t.Errorf("Expected subtotal 210 with discounts %+v, got %d with %+v", expectedDiscounts, breakdown.Subtotal.Amount, breakdown.Discounts)
+	}
+	if breakdown.TotalPrice != gbp(189) || breakdown.TotalSavings != gbp(35+21) {
+		t.Errorf("Expected total 189 with savings 56, got %d with savings %d", breakdown.TotalPrice.Amount, breakdown.TotalSavings.Amount)
+	}
+
+	if err := co.Void("A"); err != nil {
+		t.Fatalf("Void() returned an unexpected error: %v", err)
+	}
+	breakdown, _ = co.GetBreakdown()
+	if len(breakdown.Discounts) != 0 || breakdown.TotalPrice != gbp(80) {
+		t.Errorf("Expected no discount on a subtotal of 80, got %+v totalling %d", breakdown.Discounts, breakdown.TotalPrice.Amount)
+	}
+}
+
+func TestCoupons(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	prices.BasketDiscounts = []domain.BasketDiscount{
+		{ID: "ten-percent", Type: domain.DiscountPercent, SpendOver: gbp(200), Percent: 10},
+	}
+	co := newSession(t, &switchablePricingService{prices: prices})
+	for _, sku := range []string{"A", "A", "A", "B", "B", "C", "D"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	tenPercent := domain.Coupon{Code: "TEN", Type: domain.DiscountPercent, Percent: 10, Stackable: true}
+	fiveOff := domain.Coupon{Code: "FIVE", Type: domain.DiscountFixed, Amount: gbp(5), Stackable: true}
+	solo := domain.Coupon{Code: "SOLO", Type: domain.DiscountFixed, Amount: gbp(30)}
+	for _, coupon := range []domain.Coupon{tenPercent, fiveOff} {
+		if err := co.ApplyCoupon(coupon); err != nil {
+			t.Fatalf("ApplyCoupon(%s) returned an unexpected error: %v", coupon.Code, err)
+		}
+	}
+
+	// Coupons are taken after the basket discount, each from what is left.
+	breakdown, _ := co.GetBreakdown()
+	expectedDiscounts := []domain.DiscountLine{
+		{ID: "ten-percent", Type: "percent", Amount: gbp(21)},
+		{ID: "TEN", Type: "coupon", Amount: gbp(18)},
+		{ID: "FIVE", Type: "coupon", Amount: gbp(5)},
+	}
+	if !reflect.DeepEqual(breakdown.Discounts, expectedDiscounts) {
+		t.Errorf("Expected discounts %+v, got %+v", expectedDiscounts, breakdown.Discounts)
+	}
+	if breakdown.TotalPrice != gbp(166) || breakdown.TotalSavings != gbp(35+21+18+5) {
+		t.Errorf("Expected total 166 with savings 79, got %d with savings %d", breakdown.TotalPrice.Amount, breakdown.TotalSavings.Amount)
+	}
+
+	testCases := []struct {
+		name     string
+		change   func() error
+		expected error
+	}{
+		{name: "Apply a coupon twice", change: func() error {
+			return co.ApplyCoupon(domain.Coupon{Code: "five", Type: domain.DiscountFixed, Amount: gbp(5)})
+		}, expected: ErrCouponAlreadyApplied},
+		{name: "Combine a coupon that is not stackable", change: func() error { return co.ApplyCoupon(solo) }, expected: ErrCouponNotStackable},
+		{name: "Apply a coupon issued in another currency", change: func() error {
+			return co.ApplyCoupon(domain.Coupon{Code: "EUROS", Type: domain.DiscountPercent, Currency: "EUR", Percent: 10, Stackable: true})
+		}, expected: domain.ErrCurrencyMismatch},
+		{name: "Remove a coupon in another case", change: func() error { return co.RemoveCoupon("ten") }},
+		{name: "Remove a coupon that is not applied", change: func() error { return co.RemoveCoupon("TEN") }, expected: ErrCouponNotApplied},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.change(); !errors.Is(err, tc.expected) {
+				t.Errorf("Expected error %v, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	if total, _ := co.GetTotalPrice(); total != gbp(184) {
+		t.Errorf("Expected total 184 once 'TEN' is removed, got %d", total.Amount)
+	}
+	if err := co.RemoveCoupon("FIVE"); err != nil {
+		t.Fatalf("RemoveCoupon() returned an unexpected error: %v", err)
+	}
+	if err := co.ApplyCoupon(solo); err != nil {
+		t.Fatalf("Expected a coupon that is not stackable to apply alone, got %v", err)
+	}
+	if err := co.ApplyCoupon(fiveOff); !errors.Is(err, ErrCouponNotStackable) {
+		t.Errorf("Expected error %v adding to a coupon that is not stackable, got %v", ErrCouponNotStackable, err)
+	}
+
+	completion, err := co.Complete()
+	if err != nil {
+		t.Fatalf("Complete() returned an unexpected error: %v", err)
+	}
+	applied, _ := solo.In("GBP")
+	if completion.FinalTotal != gbp(159) || !reflect.DeepEqual(completion.Coupons, []domain.Coupon{applied}) {
+		t.Errorf("Expected a final total of 159 with coupon 'SOLO', got %d with %+v", completion.FinalTotal.Amount, completion.Coupons)
+	}
+	if err := co.RemoveCoupon("SOLO"); !errors.Is(err, ErrSessionNotOpen) {
+		t.Errorf("Expected error %v removing a coupon after completion, got %v", ErrSessionNotOpen, err)
+	}
+}
+
+func TestTax(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	c := prices.Rules["C"]
+	c.TaxCategory = "zero"
+	prices.Rules["C"] = c
+	prices.Tax = &domain.TaxConfig{
+		Mode:            domain.TaxExclusive,
+		Rounding:        domain.RoundPerInvoice,
+		Rates:           map[string]int{"standard": 2000, "zero": 0},
+		DefaultCategory: "standard",
+	}
+	pricer := &switchablePricingService{prices: prices}
+	co := newSession(t, pricer)
+	for _, sku := range []string{"A", "A", "A", "B", "C"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	// Exclusive prices have their tax added to the total.
+	breakdown, _ := co.GetBreakdown()
+	expected := &domain.TaxSummary{Mode: domain.TaxExclusive, Net: gbp(180), Tax: gbp(32), Gross: gbp(212), Rates: []domain.TaxLine{
+		{Category: "standard", Rate: 2000, Net: gbp(160), Tax: gbp(32), Gross: gbp(192)},
+		{Category: "zero", Rate: 0, Net: gbp(20), Tax: gbp(0), Gross: gbp(20)},
+	}}
+	if !reflect.DeepEqual(breakdown.Tax, expected) {
+		t.Errorf("Expected tax %+v, got %+v", expected, breakdown.Tax)
+	}
+	if breakdown.Subtotal != gbp(180) || breakdown.TotalPrice != gbp(212) {
+		t.Errorf("Expected subtotal 180 and total 212, got %d and %d", breakdown.Subtotal.Amount, breakdown.TotalPrice.Amount)
+	}
+	if category := breakdown.Lines[2].TaxCategory; category != "zero" {
+		t.Errorf("Expected line 'C' in tax category 'zero', got '%s'", category)
+	}
+
+	// The tax table is locked with the first scan, so a rule in a category
+	// it has no rate for cannot be added later.
+	pricer.prices = domain.PriceList{Version: 2, Rules: map[string]domain.PricingRule{
+		"E": {UnitPrice: gbp(10), TaxCategory: "luxury"},
+	}}
+	if err := co.Scan("E"); err == nil {
+		t.Error("Expected an error scanning an SKU with no tax rate, but got nil")
+	}
+
+	completion, err := co.Complete()
+	if err != nil {
+		t.Fatalf("Complete() returned an unexpected error: %v", err)
+	}
+	if completion.FinalTotal != gbp(212) || !reflect.DeepEqual(completion.Tax, prices.Tax) {
+		t.Errorf("Expected a final total of 212 with the locked tax table, got %d with %+v", completion.FinalTotal.Amount, completion.Tax)
+	}
+}
+
+func TestCurrencyAndOverflow(t *testing.T) {
+	pricer := &switchablePricingService{prices: (&mockPricingService{}).GetPriceList()}
+	co := newSession(t, pricer)
+	if err := co.Scan("A"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	if total, err := co.GetTotalPrice(); err != nil || total != gbp(50) {
+		t.Errorf("Expected a total of 50 in the default currency, got %v (err %v)", total, err)
+	}
+
+	// A quantity whose price cannot be represented is rejected and leaves the basket as it was.
+	if err := co.SetQuantity("B", math.MaxInt64/20); !errors.Is(err, domain.ErrOverflow) {
+		t.Errorf("Expected error %v, got %v", domain.ErrOverflow, err)
+	}
+	if total, err := co.GetTotalPrice(); err != nil || total != gbp(50) {
+		t.Errorf("Expected the total to stay 50 after a rejected quantity, got %v (err %v)", total, err)
+	}
+
+	// Prices in another currency are never charged in the session.
+	pricer.prices = domain.PriceList{Version: 2, Currency: "EUR", Rules: map[string]domain.PricingRule{
+		"C": {UnitPrice: domain.Money{Amount: 25}},
+	}}
+	if err := co.Scan("C"); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v scanning a euro price, got %v", domain.ErrCurrencyMismatch, err)
+	}
+	pricer.prices = domain.PriceList{Version: 3, Rules: map[string]domain.PricingRule{
+		"C": {UnitPrice: domain.Money{Amount: 25, Currency: "EUR"}},
+	}}
+	if err := co.Scan("C"); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v scanning a rule priced in euros, got %v", domain.ErrCurrencyMismatch, err)
+	}
+
+	// A session takes the currency of the price list it was created from.
+	pricer.prices.Currency = "EUR"
+	euros := newSession(t, pricer)
+	if err := euros.Scan("C"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	if total, _ := euros.GetTotalPrice(); total != (domain.Money{Amount: 25, Currency: "EUR"}) {
+		t.Errorf("Expected a total of 25 EUR, got %v", total)
+	}
+}
+
+func TestRejectedChangesLeaveSessionUnchanged(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	prices.Tax = &domain.TaxConfig{Mode: domain.TaxExclusive, Rounding: domain.RoundPerInvoice, Rates: map[string]int{"standard": 2000}, DefaultCategory: "standard"}
+	prices.Rules["E"] = domain.PricingRule{UnitPrice: gbp(10), TaxCategory: "luxury"}
+	co := newSession(t, &switchablePricingService{prices: prices})
+
+	testCases := []struct {
+		name   string
+		change func() error
+	}{
+		// The first SKU would lock the promotions and tax table along with its rule.
+		{name: "A first quantity too large to price", change: func() error { return co.SetQuantity("B", math.MaxInt64/20) }},
+		{name: "Scanning an SKU with no tax rate", change: func() error { return co.Scan("E") }},
+		{name: "A quantity too large to price", change: func() error { return co.SetQuantity("B", math.MaxInt64/20) }},
+	}
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if i > 0 {
+				if err := co.Scan("A"); err != nil {
+					t.Fatalf("Got unexpected error during scan: %v", err)
+				}
+			}
+			before, _ := json.Marshal(co)
+			if err := tc.change(); err == nil {
+				t.Fatal("Expected the change to be rejected, but got nil")
+			}
+			if after, _ := json.Marshal(co); string(after) != string(before) {
+				t.Errorf("Expected a rejected change to leave the session as %s, got %s", before, after)
+			}
+		})
+	}
+}
+
+func TestLargeAmounts(t *testing.T) {
+	taxed := func(price int) domain.ICheckout {
+		prices := domain.PriceList{Version: 1, Rules: map[string]domain.PricingRule{"TV": {UnitPrice: gbp(price)}}}
+		prices.Tax = &domain.TaxConfig{Mode: domain.TaxExclusive, Rounding: domain.RoundPerInvoice, Rates: map[string]int{"standard": 2000}, DefaultCategory: "standard"}
+		co := newSession(t, &switchablePricingService{prices: prices})
+		if err := co.Scan("TV"); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+		return co
+	}
+
+	// Tax on an amount near the limit is worked out without overflowing.
+	if total, err := taxed(3e18).GetTotalPrice(); err != nil || total != gbp(36e17) {
+		t.Errorf("Expected a total of 36e17 with tax, got %v (err %v)", total, err)
+	}
+	// A total that cannot be represented is reported instead of wrapping.
+	if _, err := taxed(8e18).GetBreakdown(); !errors.Is(err, domain.ErrOverflow) {
+		t.Errorf("Expected error %v, got %v", domain.ErrOverflow, err)
+	}
+
+	prices := domain.PriceList{Version: 1, Rules: map[string]domain.PricingRule{"TV": {UnitPrice: gbp(2e17)}}}
+	prices.BasketDiscounts = []domain.BasketDiscount{{ID: "half", Type: domain.DiscountPercent, SpendOver: gbp(0), Percent: 50}}
+	co := newSession(t, &switchablePricingService{prices: prices})
+	if err := co.Scan("TV"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	if breakdown.TotalPrice != gbp(1e17) || breakdown.TotalSavings != gbp(1e17) {
+		t.Errorf("Expected a total and savings of 1e17, got %d and %d", breakdown.TotalPrice.Amount, breakdown.TotalSavings.Amount)
+	}
+}
+
+// multiCurrencyPricingService serves a price list in each of several
+// currencies, with the GBP price list as the default.
+type multiCurrencyPricingService struct {
+	lists map[string]domain.PriceList
+}
+
+func (m *multiCurrencyPricingService) GetPriceList() domain.PriceList {
+	return m.lists["GBP"]
+}
+
+func (m *multiCurrencyPricingService) GetPriceListIn(currency string) (domain.PriceList, error) {
+	prices, ok := m.lists[currency]
+	if !ok {
+		return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	return prices, nil
+}
+
+func TestSessionCurrency(t *testing.T) {
+	eur := func(amount int) domain.Money {
+		return domain.Money{Amount: int64(amount), Currency: "EUR"}
+	}
+	pricer := &multiCurrencyPricingService{lists: map[string]domain.PriceList{
+		"GBP": (&mockPricingService{}).GetPriceList(),
+		"EUR": {Version: 1, Currency: "EUR", Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: eur(60), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: eur(150)}},
+			"B": {UnitPrice: domain.Money{Amount: 35}},
+		}},
+	}}
+
+	if currency := newSession(t, pricer).GetCurrency(); currency != "GBP" {
+		t.Errorf("Expected a session in the default currency GBP, got %s", currency)
+	}
+
+	euros := newSession(t, pricer, WithCurrency("EUR"))
+	if currency := euros.GetCurrency(); currency != "EUR" {
+		t.Errorf("Expected a session in EUR, got %s", currency)
+	}
+	for _, sku := range []string{"A", "A", "A", "B"} {
+		if err := euros.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+	if total, err := euros.GetTotalPrice(); err != nil || total != eur(185) {
+		t.Errorf("Expected a total of 185 EUR from the EUR price list and offers, got %v (err %v)", total, err)
+	}
+	if err := euros.Scan("C"); !errors.Is(err, ErrUnknownSKU) {
+		t.Errorf("Expected error %v for an SKU only priced in GBP, got %v", ErrUnknownSKU, err)
+	}
+
+	data, err := json.Marshal(euros)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	restored, err := Restore(pricer, data, WithCurrency("GBP"))
+	if err != nil {
+		t.Fatalf("Restore() returned an unexpected error: %v", err)
+	}
+	if restored.GetCurrency() != "EUR" {
+		t.Errorf("Expected the restored session to keep its currency EUR, got %s", restored.GetCurrency())
+	}
+
+	// A currency with no price list cannot price anything.
+	dollars := newSession(t, pricer, WithCurrency("USD"))
+	if err := dollars.Scan("A"); !errors.Is(err, domain.ErrCurrencyNotPriced) {
+		t.Errorf("Expected error %v, got %v", domain.ErrCurrencyNotPriced, err)
+	}
+	// Nor can a session locking its prices on start be created in it.
+	if _, err := New(pricer, WithCurrency("USD"), WithPriceLock(LockOnStart)); !errors.Is(err, domain.ErrCurrencyNotPriced) {
+		t.Errorf("Expected error %v creating a session, got %v", domain.ErrCurrencyNotPriced, err)
+	}
+
+	// Without price lists by currency, only the default price list's currency can be priced.
+	single := newSession(t, &switchablePricingService{prices: pricer.lists["GBP"]}, WithCurrency("EUR"))
+	if err := single.Scan("A"); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v, got %v", domain.ErrCurrencyMismatch, err)
+	}
+}
+
+// storePricingService prices each configured store from its own price list.
+type storePricingService struct {
+	switchablePricingService
+	stores map[string]domain.PriceList
+}
+
+func (m *storePricingService) GetPriceListFor(store, currency string) (domain.PriceList, error) {
+	prices, ok := m.stores[store]
+	if store == "" {
+		prices, ok = m.prices, true
+	}
+	if !ok {
+		return domain.PriceList{}, fmt.Errorf("store '%s' %w", store, domain.ErrUnknownStore)
+	}
+	if currencyOf(prices) != currency {
+		return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	return prices, nil
+}
+
+func TestSessionStore(t *testing.T) {
+	base := (&mockPricingService{}).GetPriceList()
+	pricer := &storePricingService{
+		switchablePricingService: switchablePricingService{prices: base},
+		stores: map[string]domain.PriceList{
+			"manchester": {Version: 1, Rules: map[string]domain.PricingRule{"A": {UnitPrice: gbp(45)}}},
+		},
+	}
+
+	if store := newSession(t, pricer).GetStore(); store != "" {
+		t.Errorf("Expected a session not scoped to a store, got %q", store)
+	}
+	if err := newSession(t, pricer).Scan("B"); err != nil {
+		t.Errorf("Expected a session without a store to be priced from the price list, got %v", err)
+	}
+
+	co := newSession(t, pricer, WithStore("manchester"), WithPriceLock(LockOnStart))
+	if store := co.GetStore(); store != "manchester" {
+		t.Errorf("Expected a session for manchester, got %q", store)
+	}
+	pricer.stores["manchester"] = domain.PriceList{Version: 2, Rules: map[string]domain.PricingRule{"A": {UnitPrice: gbp(99)}}}
+	for _, sku := range []string{"A", "A"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+	if total, err := co.GetTotalPrice(); err != nil || total != gbp(90) {
+		t.Errorf("Expected a total of 90 from the store's prices at creation, got %v (err %v)", total, err)
+	}
+	if err := co.Scan("B"); !errors.Is(err, ErrUnknownSKU) {
+		t.Errorf("Expected error %v for an SKU the store does not price, got %v", ErrUnknownSKU, err)
+	}
+
+	data, err := json.Marshal(co)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	restored, err := Restore(pricer, data, WithStore("leeds"))
+	if err != nil {
+		t.Fatalf("Restore() returned an unexpected error: %v", err)
+	}
+	if restored.GetStore() != "manchester" {
+		t.Errorf("Expected the restored session to keep its store manchester, got %q", restored.GetStore())
+	}
+
+	for name, tc := range map[string]struct {
+		pricer PricingService
+		store  string
+	}{
+		"an unconfigured store":                   {pricer: pricer, store: "york"},
+		"a pricing service without store pricing": {pricer: &switchablePricingService{prices: base}, store: "manchester"},
+	} {
+		if err := newSession(t, tc.pricer, WithStore(tc.store)).Scan("A"); !errors.Is(err, domain.ErrUnknownStore) {
+			t.Errorf("%s: expected error %v, got %v", name, domain.ErrUnknownStore, err)
+		}
+	}
+}
+
+func TestScanMeasure(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	prices.Rules["BANANA"] = domain.PricingRule{UnitPrice: gbp(120), Unit: domain.UnitKilogram}
+	prices.Rules["MILK"] = domain.PricingRule{UnitPrice: gbp(95), Unit: domain.UnitLitre, Rounding: domain.RoundDown}
+	co := newSession(t, &switchablePricingService{prices: prices})
+
+	for _, step := range []struct {
+		sku     string
+		measure domain.Measure
+	}{{sku: "BANANA", measure: 456}, {sku: "BANANA", measure: 250}, {sku: "MILK", measure: 1500}} {
+		if err := co.ScanMeasure(step.sku, step.measure); err != nil {
+			t.Fatalf("ScanMeasure() returned an unexpected error: %v", err)
+		}
+	}
+	for _, sku := range []string{"A", "A", "A"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	expected := []domain.LineItem{
+		{SKU: "A", Quantity: 3, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(130), Savings: gbp(20), PricingVersion: 1},
+		{SKU: "BANANA", Quantity: 706, UnitPrice: gbp(120), LineTotal: gbp(85), Savings: gbp(0), PricingVersion: 1, Unit: domain.UnitKilogram, Measure: 706},
+		{SKU: "MILK", Quantity: 1500, UnitPrice: gbp(95), LineTotal: gbp(142), Savings: gbp(0), PricingVersion: 1, Unit: domain.UnitLitre, Measure: 1500},
+	}
+	if !reflect.DeepEqual(breakdown.Lines, expected) {
+		t.Errorf("Expected lines %+v, got %+v", expected, breakdown.Lines)
+	}
+	if breakdown.TotalPrice != gbp(357) {
+		t.Errorf("Expected a total of 357, got %v", breakdown.TotalPrice)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		change   func() error
+		expected error
+	}{
+		{name: "scanning a unit of an SKU sold by measure", change: func() error { return co.Scan("BANANA") }, expected: ErrSoldByMeasure},
+		{name: "removing a unit of an SKU sold by measure", change: func() error { return co.Remove("BANANA") }, expected: ErrSoldByMeasure},
+		{name: "setting the quantity of an SKU sold by measure", change: func() error { return co.SetQuantity("BANANA", 2) }, expected: ErrSoldByMeasure},
+		{name: "scanning a measure of an SKU sold by the unit", change: func() error { return co.ScanMeasure("A", 1000) }, expected: ErrNotSoldByMeasure},
+		{name: "scanning a measure of zero", change: func() error { return co.ScanMeasure("BANANA", 0) }, expected: ErrInvalidMeasure},
+		{name: "scanning a measure of an unknown SKU", change: func() error { return co.ScanMeasure("Z", 1000) }, expected: ErrUnknownSKU},
+		{name: "scanning a measure too large to price", change: func() error { return co.ScanMeasure("BANANA", math.MaxInt64/100) }, expected: domain.ErrOverflow},
+	} {
+		if err := tc.change(); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+
+	if err := co.Void("MILK"); err != nil {
+		t.Fatalf("Void() returned an unexpected error: %v", err)
+	}
+	if err := co.SetQuantity("BANANA", 0); err != nil {
+		t.Fatalf("SetQuantity() returned an unexpected error: %v", err)
+	}
+	if total, _ := co.GetTotalPrice(); total != gbp(130) {
+		t.Errorf("Expected a total of 130 once the measured SKUs are removed, got %v", total)
+	}
+}
+
+func TestRejectedScanDoesNotLockRule(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	prices.Rules["BANANA"] = domain.PricingRule{UnitPrice: gbp(120), Unit: domain.UnitKilogram}
+	repriced := domain.PriceList{Version: 2, Rules: map[string]domain.PricingRule{
+		"BANANA": {UnitPrice: gbp(150), Unit: domain.UnitKilogram},
+		"C":      {UnitPrice: gbp(25)},
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		rejected func(co domain.ICheckout) error
+		expected error
+		accepted func(co domain.ICheckout) error
+		sku      string
+	}{
+		{
+			name:     "scanning a unit of an SKU sold by measure",
+			rejected: func(co domain.ICheckout) error { return co.Scan("BANANA") }, expected: ErrSoldByMeasure,
+			accepted: func(co domain.ICheckout) error { return co.ScanMeasure("BANANA", 1000) }, sku: "BANANA",
+		},
+		{
+			name:     "setting the quantity of an SKU sold by measure",
+			rejected: func(co domain.ICheckout) error { return co.SetQuantity("BANANA", 2) }, expected: ErrSoldByMeasure,
+			accepted: func(co domain.ICheckout) error { return co.ScanMeasure("BANANA", 1000) }, sku: "BANANA",
+		},
+		{
+			name:     "scanning a measure of an SKU sold by the unit",
+			rejected: func(co domain.ICheckout) error { return co.ScanMeasure("C", 1000) }, expected: ErrNotSoldByMeasure,
+			accepted: func(co domain.ICheckout) error { return co.Scan("C") }, sku: "C",
+		},
+		{
+			name:     "scanning a measure too large to price",
+			rejected: func(co domain.ICheckout) error { return co.ScanMeasure("BANANA", math.MaxInt64/100) }, expected: domain.ErrOverflow,
+			accepted: func(co domain.ICheckout) error { return co.ScanMeasure("BANANA", 1000) }, sku: "BANANA",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pricer := &switchablePricingService{prices: prices}
+			co := newSession(t, pricer)
+			if err := tc.rejected(co); !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+
+			// The rejected scan locked nothing, so the SKU is charged at the
+			// price list in force when it is first accepted.
+			pricer.prices = repriced
+			if err := tc.accepted(co); err != nil {
+				t.Fatalf("Got unexpected error during scan: %v", err)
+			}
+			breakdown, _ := co.GetBreakdown()
+			if len(breakdown.Lines) != 1 || breakdown.Lines[0].SKU != tc.sku || breakdown.Lines[0].PricingVersion != 2 {
+				t.Errorf("Expected one line of '%s' at pricing version 2, got %+v", tc.sku, breakdown.Lines)
 			}
 		})
 	}
 }
+
+func TestScanBarcode(t *testing.T) {
+	withCheckDigit := func(digits string) string { return digits + string(barcode.CheckDigit(digits)) }
+	prices := (&mockPricingService{}).GetPriceList()
+	rule := prices.Rules["A"]
+	rule.Barcodes = []string{"4006381333931", "73513537"}
+	prices.Rules["A"] = rule
+	prices.Rules["BANANA"] = domain.PricingRule{UnitPrice: gbp(120), Unit: domain.UnitKilogram, ItemReference: "12345"}
+	pricer := &switchablePricingService{prices: prices}
+	co := newSession(t, pricer)
+
+	for _, code := range []string{
+		"4006381333931",
+		"73513537",
+		"4006381333931",
+		withCheckDigit("201234500250"), // A pack marked at 250
+		withCheckDigit("251234500456"), // 456 grams
+		withCheckDigit("021234500199"), // A pack marked at 199, as a UPC-A would read
+	} {
+		if err := co.ScanBarcode(code); err != nil {
+			t.Fatalf("ScanBarcode(%q) returned an unexpected error: %v", code, err)
+		}
+	}
+
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+	}
+	expected := []domain.LineItem{
+		{SKU: "A", Quantity: 3, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(130), Savings: gbp(20), PricingVersion: 1},
+		{SKU: "BANANA", Quantity: 456, UnitPrice: gbp(120), LineTotal: gbp(504), Savings: gbp(0), PricingVersion: 1, Unit: domain.UnitKilogram, Measure: 456, Packs: 2},
+	}
+	if !reflect.DeepEqual(breakdown.Lines, expected) {
+		t.Errorf("Expected lines %+v, got %+v", expected, breakdown.Lines)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		code     string
+		expected error
+	}{
+		{name: "unknown barcode", code: "5901234123457", expected: barcode.ErrUnknownBarcode},
+		{name: "unknown item reference", code: withCheckDigit("205432100250"), expected: barcode.ErrUnknownBarcode},
+		{name: "wrong check digit", code: "4006381333930", expected: barcode.ErrCheckDigit},
+		{name: "SKU code", code: "A", expected: barcode.ErrInvalidBarcode},
+		{name: "pack marked at nothing", code: withCheckDigit("201234500000"), expected: ErrInvalidPrice},
+		{name: "measure of nothing", code: withCheckDigit("251234500000"), expected: ErrInvalidMeasure},
+	} {
+		if err := co.ScanBarcode(tc.code); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+	if err := co.Remove("BANANA"); !errors.Is(err, ErrSoldByMeasure) {
+		t.Errorf("Expected error %v removing a pack, got %v", ErrSoldByMeasure, err)
+	}
+
+	data, err := json.Marshal(co)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	restored, err := Restore(pricer, data)
+	if err != nil {
+		t.Fatalf("Restore() returned an unexpected error: %v", err)
+	}
+	if restoredBreakdown, _ := restored.GetBreakdown(); !reflect.DeepEqual(restoredBreakdown.Lines, expected) {
+		t.Errorf("Expected restored lines %+v, got %+v", expected, restoredBreakdown.Lines)
+	}
+
+	if err := co.Void("BANANA"); err != nil {
+		t.Fatalf("Void() returned an unexpected error: %v", err)
+	}
+	if total, _ := co.GetTotalPrice(); total != gbp(130) {
+		t.Errorf("Expected a total of 130 once the packs are voided, got %v", total)
+	}
+
+	packsOnly := newSession(t, pricer)
+	if err := packsOnly.ScanBarcode(withCheckDigit("201234500250")); err != nil {
+		t.Fatalf("ScanBarcode() returned an unexpected error: %v", err)
+	}
+	if breakdown, _ := packsOnly.GetBreakdown(); len(breakdown.Lines) != 1 || breakdown.Lines[0].Packs != 1 || breakdown.TotalPrice != gbp(250) {
+		t.Errorf("Expected a single pack of BANANA at 250, got %+v", breakdown)
+	}
+	if err := packsOnly.SetQuantity("BANANA", 0); err != nil {
+		t.Fatalf("SetQuantity() returned an unexpected error: %v", err)
+	}
+	if total, _ := packsOnly.GetTotalPrice(); total != gbp(0) {
+		t.Errorf("Expected an empty basket once the pack is removed, got %v", total)
+	}
+}
+
+// cataloguePricingService serves a barcode catalogue of its own, counting
+// the times it is asked for it.
+type cataloguePricingService struct {
+	switchablePricingService
+	catalogue *barcode.Catalogue
+	requests  int
+}
+
+func (m *cataloguePricingService) GetCatalogueFor(store, currency string) (*barcode.Catalogue, error) {
+	m.requests++
+	return m.catalogue, nil
+}
+
+func TestScanBarcodeFromPricingCatalogue(t *testing.T) {
+	prices := (&mockPricingService{}).GetPriceList()
+	catalogue, err := barcode.NewCatalogue(map[string]domain.PricingRule{"C": {Barcodes: []string{"4006381333931"}}})
+	if err != nil {
+		t.Fatalf("NewCatalogue() returned an unexpected error: %v", err)
+	}
+	pricer := &cataloguePricingService{switchablePricingService: switchablePricingService{prices: prices}, catalogue: catalogue}
+
+	co := newSession(t, pricer)
+	if err := co.ScanBarcode("4006381333931"); err != nil {
+		t.Fatalf("ScanBarcode() returned an unexpected error: %v", err)
+	}
+	if total, _ := co.GetTotalPrice(); total != gbp(20) || pricer.requests != 1 {
+		t.Errorf("Expected the pricing service's catalogue to scan C at 20, got %v after %d requests", total, pricer.requests)
+	}
+
+	// A pricing service that returns no catalogue fails the scan.
+	empty := &cataloguePricingService{switchablePricingService: switchablePricingService{prices: prices}}
+	if err := newSession(t, empty).ScanBarcode("4006381333931"); err == nil {
+		t.Error("Expected an error scanning with no barcode catalogue, got nil")
+	}
+
+	// A session locked on start looks barcodes up in the price list it
+	// started with, which has none.
+	locked := newSession(t, pricer, WithPriceLock(LockOnStart))
+	if err := locked.ScanBarcode("4006381333931"); !errors.Is(err, barcode.ErrUnknownBarcode) {
+		t.Errorf("Expected error %v, got %v", barcode.ErrUnknownBarcode, err)
+	}
+}
+
+func TestParsePriceLock(t *testing.T) {
+	for _, lock := range []PriceLock{LockOnScan, LockOnStart} {
+		parsed, err := ParsePriceLock(lock.String())
+		if err != nil {
+			t.Errorf("ParsePriceLock(%q) returned an unexpected error: %v", lock, err)
+		}
+		if parsed != lock {
+			t.Errorf("ParsePriceLock(%q): expected %v, got %v", lock, lock, parsed)
+		}
+	}
+	if _, err := ParsePriceLock("never"); err == nil {
+		t.Error("Expected an error parsing an unknown price lock mode, but got nil")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	pricer := &switchablePricingService{prices: (&mockPricingService{}).GetPriceList()}
+	co := newSession(t, pricer, WithPriceLock(LockOnStart))
+	for _, sku := range []string{"A", "A", "A", "B"} {
+		if err := co.Scan(sku); err != nil {
+			t.Fatalf("Got unexpected error during scan: %v", err)
+		}
+	}
+	if err := co.ApplyCoupon(domain.Coupon{Code: "TEN", Type: domain.DiscountPercent, Percent: 10}); err != nil {
+		t.Fatalf("ApplyCoupon() returned an unexpected error: %v", err)
+	}
+	co.SetVersion(4)
+
+	data, err := json.Marshal(co)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	pricer.prices = domain.PriceList{Version: 2, Rules: map[string]domain.PricingRule{"A": {UnitPrice: gbp(99)}}}
+	restored, err := Restore(pricer, data)
+	if err != nil {
+		t.Fatalf("Restore() returned an unexpected error: %v", err)
+	}
+
+	if restored.GetID() != co.GetID() || restored.GetVersion() != 4 || restored.GetState() != domain.StateOpen {
+		t.Errorf("Expected session %s at version 4, got %s at version %d in state %s", co.GetID(), restored.GetID(), restored.GetVersion(), restored.GetState())
+	}
+	original, _ := co.GetBreakdown()
+	breakdown, _ := restored.GetBreakdown()
+	if breakdown.TotalPrice != original.TotalPrice || len(breakdown.Lines) != len(original.Lines) {
+		t.Errorf("Expected restored breakdown %+v, got %+v", original, breakdown)
+	}
+	if !reflect.DeepEqual(restored.GetCoupons(), co.GetCoupons()) {
+		t.Errorf("Expected restored coupons %+v, got %+v", co.GetCoupons(), restored.GetCoupons())
+	}
+	// The restored session keeps the price list it started with.
+	if err := restored.Scan("C"); err != nil {
+		t.Errorf("Expected to scan C from the start price list, got %v", err)
+	}
+
+	// Data written before the format was versioned is still readable.
+	legacy := `{"id":"x","version":2,"state":"open","priceLock":"scan","items":{"A":1},"lockedRules":{"A":{"rule":{"unitPrice":50},"pricingVersion":1}}}`
+	if restored, err := Restore(pricer, []byte(legacy)); err != nil {
+		t.Errorf("Expected to restore an unversioned session, got %v", err)
+	} else if total, _ := restored.GetTotalPrice(); total != gbp(50) {
+		t.Errorf("Expected restored unversioned session to total 50, got %d", total.Amount)
+	}
+
+	// Data written in version 2, before weighed items, is read unchanged.
+	version2 := `{"formatVersion":2,"id":"x","state":"open","currency":"GBP","priceLock":"scan","items":{"A":2},"lockedRules":{"A":{"rule":{"unitPrice":{"amount":50,"currency":"GBP"}},"pricingVersion":1}}}`
+	if restored, err := Restore(pricer, []byte(version2)); err != nil {
+		t.Errorf("Expected to restore a version 2 session, got %v", err)
+	} else if total, _ := restored.GetTotalPrice(); total != gbp(100) {
+		t.Errorf("Expected restored version 2 session to total 100, got %d", total.Amount)
+	}
+
+	// Data written in version 3 holds offer prices as bare integers.
+	version3 := `{"formatVersion":3,"id":"x","state":"open","currency":"GBP","priceLock":"scan","items":{"A":3},"lockedRules":{"A":{"rule":{"unitPrice":{"amount":50,"currency":"GBP"},"specialPrice":{"quantity":3,"price":130}},"pricingVersion":1}}}`
+	if restored, err := Restore(pricer, []byte(version3)); err != nil {
+		t.Errorf("Expected to restore a version 3 session, got %v", err)
+	} else if total, _ := restored.GetTotalPrice(); total != gbp(130) {
+		t.Errorf("Expected restored version 3 session to total 130, got %d", total.Amount)
+	}
+
+	newer := fmt.Sprintf(`{"formatVersion":%d,"id":"x","state":"open","priceLock":"scan"}`, SessionFormatVersion+1)
+	if _, err := Restore(pricer, []byte(newer)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat restoring a newer format, got %v", err)
+	}
+
+	for name, data := range map[string]string{
+		"malformed JSON":        `{"id":`,
+		"missing id":            `{"state":"open","priceLock":"scan"}`,
+		"unknown state":         `{"id":"x","state":"paid","priceLock":"scan"}`,
+		"unknown price lock":    `{"id":"x","state":"open","priceLock":"never"}`,
+		"item without a rule":   `{"id":"x","state":"open","priceLock":"scan","items":{"A":1}}`,
+		"non-positive quantity": `{"id":"x","state":"open","priceLock":"scan","items":{"A":0},"lockedRules":{"A":{"rule":{"unitPrice":50}}}}`,
+		"pack without a rule":   `{"id":"x","state":"open","priceLock":"scan","markedPrices":{"A":[250]}}`,
+		"pack marked at zero":   `{"id":"x","state":"open","priceLock":"scan","markedPrices":{"A":[0]},"lockedRules":{"A":{"rule":{"unitPrice":50,"unit":"kg"}}}}`,
+		"invalid coupon":        `{"id":"x","state":"open","priceLock":"scan","coupons":[{"code":"X","type":"bogof"}]}`,
+	} {
+		if _, err := Restore(pricer, []byte(data)); err == nil {
+			t.Errorf("Expected an error restoring a session with %s, but got nil", name)
+		}
+	}
+}
+
+func TestSessionData(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	now := func() time.Time { return clock }
+	co := newSession(t, &mockPricingService{}, WithClock(now))
+	created := clock.UTC()
+	clock = clock.Add(time.Minute)
+	if err := co.Scan("A"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	scanned := clock.UTC()
+	clock = clock.Add(time.Minute)
+	if err := co.Scan("Z"); err == nil {
+		t.Fatal("Expected an error scanning an unknown SKU, but got nil")
+	}
+
+	raw, err := json.Marshal(co)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	var data SessionData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatalf("Could not parse serialised session: %v", err)
+	}
+	expected := SessionData{
+		FormatVersion: SessionFormatVersion,
+		ID:            co.GetID(),
+		State:         domain.StateOpen,
+		Currency:      "GBP",
+		CreatedAt:     created,
+		UpdatedAt:     scanned,
+		Items:         map[string]int{"A": 1},
+		LockedRules: map[string]LockedRule{
+			"A": {Rule: (&mockPricingService{}).GetPriceList().Rules["A"], PricingVersion: 1},
+		},
+		LockedPromotions: &LockedPromotions{PricingVersion: 1},
+		PriceLock:        "scan",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected session data %+v, got %+v", expected, data)
+	}
+
+	// Restored sessions keep their timestamps and take the clock from options.
+	restored, err := RestoreData(&mockPricingService{}, data, WithClock(now))
+	if err != nil {
+		t.Fatalf("RestoreData() returned an unexpected error: %v", err)
+	}
+	if _, err := restored.Complete(); err != nil {
+		t.Fatalf("Complete() returned an unexpected error: %v", err)
+	}
+	raw, _ = json.Marshal(restored)
+	var completed SessionData
+	if err := json.Unmarshal(raw, &completed); err != nil {
+		t.Fatalf("Could not parse serialised session: %v", err)
+	}
+	if !completed.CreatedAt.Equal(created) || !completed.UpdatedAt.Equal(clock) || !completed.Completion.CompletedAt.Equal(clock) {
+		t.Errorf("Expected created %v and completed %v, got created %v, updated %v and completed %v",
+			created, clock, completed.CreatedAt, completed.UpdatedAt, completed.Completion.CompletedAt)
+	}
+}
@@ -0,0 +1,34 @@
+package checkout
+
+import "errors"
+
+var (
+	// ErrUnknownSKU is returned when an SKU has no entry in the pricing rules.
+	ErrUnknownSKU = errors.New("not found in pricing rules")
+	// ErrItemNotScanned is returned when removing an SKU that is not in the session.
+	ErrItemNotScanned = errors.New("has not been scanned")
+	// ErrInvalidQuantity is returned when setting a negative quantity for an SKU.
+	ErrInvalidQuantity = errors.New("quantity must not be negative")
+	// ErrInvalidMeasure is returned when scanning a measured quantity that is not positive.
+	ErrInvalidMeasure = errors.New("measure must be positive")
+	// ErrInvalidPrice is returned when scanning a pack marked with a price that is not positive.
+	ErrInvalidPrice = errors.New("marked price must be positive")
+	// ErrSoldByMeasure is returned when counting units of an SKU sold by
+	// measure, which must be scanned with its measured quantity.
+	ErrSoldByMeasure = errors.New("is sold by measure")
+	// ErrNotSoldByMeasure is returned when scanning a measured quantity, or a
+	// pack marked with its price, of an SKU sold by the unit.
+	ErrNotSoldByMeasure = errors.New("is not sold by measure")
+	// ErrSessionNotOpen is returned when changing a completed or cancelled session.
+	ErrSessionNotOpen = errors.New("session is not open")
+	// ErrCouponAlreadyApplied is returned when applying a coupon the session already has.
+	ErrCouponAlreadyApplied = errors.New("has already been applied")
+	// ErrCouponNotApplied is returned when removing a coupon the session does not have.
+	ErrCouponNotApplied = errors.New("has not been applied")
+	// ErrCouponNotStackable is returned when combining a coupon with one that
+	// must be used alone.
+	ErrCouponNotStackable = errors.New("cannot be combined with other coupons")
+	// ErrUnsupportedFormat is returned when restoring a session serialised in
+	// a newer format than this package understands.
+	ErrUnsupportedFormat = errors.New("unsupported session format")
+)
@@ -2,97 +2,451 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/TheFodfather/checkoutapi/checkout"
 	"github.com/TheFodfather/checkoutapi/checkout/repository"
 	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/coupon"
 )
 
+var (
+	errMissingQuantity    = errors.New("quantity is required")
+	errMissingCode        = errors.New("code is required")
+	errBarcodeWithSKU     = errors.New("scan either a barcode or an sku, not both")
+	errPreconditionFailed = errors.New("session has been changed since it was read")
+)
+
+// StoreHeader is the request header naming the store a request is made for.
+// Sessions created with it are priced for the store, and can only be read or
+// changed by requests naming the same store; sessions created without it can
+// only be used by requests without it.
+const StoreHeader = "X-Store-ID"
+
 type PricingService interface {
-	GetRules() map[string]domain.PricingRule
+	GetPriceList() domain.PriceList
+}
+
+// PriceScheduler is implemented by pricing services that plan price changes
+// ahead, to list those still to come.
+type PriceScheduler interface {
+	Upcoming() []domain.PriceChange
+}
+
+// CouponRegistry defines the dependency needed to look up and redeem coupons.
+type CouponRegistry interface {
+	Lookup(code string) (domain.Coupon, error)
+	Redeem(codes ...string) error
+	Release(codes ...string)
 }
 
 type HTTPHandler struct {
-	repo   repository.SessionRepository
-	pricer PricingService
+	repo        repository.SessionRepository
+	pricer      PricingService
+	coupons     CouponRegistry
+	sessionOpts []checkout.Option
 }
 
-func New(repo repository.SessionRepository, pricer PricingService) *HTTPHandler {
-	return &HTTPHandler{repo: repo, pricer: pricer}
+// New creates a handler whose new checkout sessions are configured with
+// sessionOpts and can be given the coupons in coupons.
+func New(repo repository.SessionRepository, pricer PricingService, coupons CouponRegistry, sessionOpts ...checkout.Option) *HTTPHandler {
+	return &HTTPHandler{repo: repo, pricer: pricer, coupons: coupons, sessionOpts: sessionOpts}
 }
 
 func (h *HTTPHandler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /checkouts", h.handleCreateCheckout)
 	mux.HandleFunc("GET /checkouts/{checkoutID}", h.handleGetTotalPrice)
 	mux.HandleFunc("POST /checkouts/{checkoutID}/scan", h.handleScanItem)
+	mux.HandleFunc("POST /checkouts/{checkoutID}/unscan", h.handleUnscanItem)
+	mux.HandleFunc("PUT /checkouts/{checkoutID}/items/{sku}", h.handleSetItemQuantity)
+	mux.HandleFunc("DELETE /checkouts/{checkoutID}/items/{sku}", h.handleVoidItem)
+	mux.HandleFunc("POST /checkouts/{checkoutID}/coupons", h.handleApplyCoupon)
+	mux.HandleFunc("DELETE /checkouts/{checkoutID}/coupons/{code}", h.handleRemoveCoupon)
+	mux.HandleFunc("POST /checkouts/{checkoutID}/complete", h.handleCompleteCheckout)
+	mux.HandleFunc("POST /checkouts/{checkoutID}/cancel", h.handleCancelCheckout)
+	mux.HandleFunc("GET /pricing/schedule", h.handleGetPriceSchedule)
 }
 
+// handleCreateCheckout starts a session for the store named in the request
+// header, if any, in the currency named in the request body or else that of
+// the default price list.
 func (h *HTTPHandler) handleCreateCheckout(w http.ResponseWriter, r *http.Request) {
-	session := checkout.New(h.pricer)
-	if err := h.repo.Save(session); err != nil {
+	var reqBody struct {
+		Currency string `json:"currency"`
+	}
+	// The body is optional: a request without one creates a session in the
+	// default currency.
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("WARN: Failed to decode request body for new checkout err=%q", err)
+			respondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	opts := h.sessionOpts
+	currency := strings.ToUpper(strings.TrimSpace(reqBody.Currency))
+	store := r.Header.Get(StoreHeader)
+	if currency != "" || store != "" {
+		if currency == "" {
+			currency = h.defaultCurrency()
+		}
+		if err := h.checkPricing(store, currency); err != nil {
+			log.Printf("INFO: Refused new checkout: %v", err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts = append(opts[:len(opts):len(opts)], checkout.WithCurrency(currency), checkout.WithStore(store))
+	}
+
+	session, err := checkout.New(h.pricer, opts...)
+	if err != nil {
+		log.Printf("ERROR: Failed to create checkout: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "could not create session")
+		return
+	}
+	if err := h.sessions(r).Save(session); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "could not save session")
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, map[string]string{"checkoutId": session.GetID()})
+	response := map[string]string{"checkoutId": session.GetID(), "currency": session.GetCurrency()}
+	if store != "" {
+		response["store"] = store
+	}
+	w.Header().Set("ETag", etag(session.GetVersion()))
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
-func (h *HTTPHandler) handleScanItem(w http.ResponseWriter, r *http.Request) {
-	checkoutID := r.PathValue("checkoutID")
+// sessions returns the repository of the sessions a request can use: those of
+// the store named in its StoreHeader.
+func (h *HTTPHandler) sessions(r *http.Request) repository.SessionRepository {
+	return repository.ForStore(h.repo, r.Header.Get(StoreHeader))
+}
 
-	session, err := h.repo.Get(checkoutID)
-	if err != nil {
-		log.Printf("INFO: Session not found for checkoutID=%q err=%q", checkoutID, err)
-		respondWithError(w, http.StatusNotFound, "session not found")
-		return
+// defaultCurrency returns the currency of the default price list.
+func (h *HTTPHandler) defaultCurrency() string {
+	if currency := h.pricer.GetPriceList().Currency; currency != "" {
+		return currency
+	}
+	return domain.DefaultCurrency
+}
+
+// checkPricing checks that a session for a store can be priced in a currency:
+// that it is an ISO 4217 currency the pricing service has a price list in for
+// the store. The empty store is always priced.
+func (h *HTTPHandler) checkPricing(store, currency string) error {
+	if err := domain.ValidateCurrency(currency); err != nil {
+		return err
 	}
+	if pricer, ok := h.pricer.(checkout.StorePricingService); ok {
+		_, err := pricer.GetPriceListFor(store, currency)
+		return err
+	}
+	if store != "" {
+		return fmt.Errorf("store '%s' %w", store, domain.ErrUnknownStore)
+	}
+	if pricer, ok := h.pricer.(checkout.CurrencyPricingService); ok {
+		_, err := pricer.GetPriceListIn(currency)
+		return err
+	}
+	if currency != h.defaultCurrency() {
+		return fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	return nil
+}
 
+// handleScanItem scans one unit of an SKU or, when the request gives its
+// weight, a measured quantity of an SKU sold by measure.
+func (h *HTTPHandler) handleScanItem(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		SKU     string          `json:"sku"`
+		Weight  *domain.Measure `json:"weight"`
+		Barcode string          `json:"barcode"`
+	}
+	h.mutateSession(w, r, &reqBody, func(session domain.ICheckout) error {
+		if reqBody.Barcode != "" {
+			if reqBody.SKU != "" || reqBody.Weight != nil {
+				return errBarcodeWithSKU
+			}
+			return session.ScanBarcode(reqBody.Barcode)
+		}
+		if reqBody.Weight != nil {
+			return session.ScanMeasure(reqBody.SKU, *reqBody.Weight)
+		}
+		return session.Scan(reqBody.SKU)
+	})
+}
+
+func (h *HTTPHandler) handleUnscanItem(w http.ResponseWriter, r *http.Request) {
 	var reqBody struct {
 		SKU string `json:"sku"`
 	}
+	h.mutateSession(w, r, &reqBody, func(session domain.ICheckout) error {
+		return session.Remove(reqBody.SKU)
+	})
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-		log.Printf("WARN: Failed to decode request body for checkoutID=%q err=%q", checkoutID, err)
-		respondWithError(w, http.StatusBadRequest, "invalid request body")
-		return
+func (h *HTTPHandler) handleSetItemQuantity(w http.ResponseWriter, r *http.Request) {
+	sku := r.PathValue("sku")
+	var reqBody struct {
+		Quantity *int `json:"quantity"`
+	}
+	h.mutateSession(w, r, &reqBody, func(session domain.ICheckout) error {
+		if reqBody.Quantity == nil {
+			return errMissingQuantity
+		}
+		return session.SetQuantity(sku, *reqBody.Quantity)
+	})
+}
+
+func (h *HTTPHandler) handleVoidItem(w http.ResponseWriter, r *http.Request) {
+	sku := r.PathValue("sku")
+	h.mutateSession(w, r, nil, func(session domain.ICheckout) error {
+		return session.Void(sku)
+	})
+}
+
+func (h *HTTPHandler) handleApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		Code string `json:"code"`
 	}
+	h.mutateSession(w, r, &reqBody, func(session domain.ICheckout) error {
+		if strings.TrimSpace(reqBody.Code) == "" {
+			return errMissingCode
+		}
+		applied, err := h.coupons.Lookup(reqBody.Code)
+		if err != nil {
+			return err
+		}
+		return session.ApplyCoupon(applied)
+	})
+}
 
-	if err := session.Scan(reqBody.SKU); err != nil {
-		log.Printf("WARN: Invalid SKU scan for checkoutID=%q: err=%q", checkoutID, err)
-		respondWithError(w, http.StatusBadRequest, err.Error())
+func (h *HTTPHandler) handleRemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	h.mutateSession(w, r, nil, func(session domain.ICheckout) error {
+		return session.RemoveCoupon(code)
+	})
+}
+
+// handleCompleteCheckout redeems the session's coupons as it completes it, so
+// that a coupon used up by another session since it was applied is rejected.
+// The coupons are given back if the session cannot be completed or saved.
+func (h *HTTPHandler) handleCompleteCheckout(w http.ResponseWriter, r *http.Request) {
+	var completed domain.ICheckout
+	var redeemed []string
+	version, ok := h.updateSession(w, r, nil, func(session domain.ICheckout) error {
+		if session.GetState() != domain.StateOpen {
+			_, err := session.Complete()
+			return err
+		}
+		codes := couponCodes(session.GetCoupons())
+		if err := h.coupons.Redeem(codes...); err != nil {
+			return err
+		}
+		if _, err := session.Complete(); err != nil {
+			h.coupons.Release(codes...)
+			return err
+		}
+		completed, redeemed = session, codes
+		return nil
+	})
+	if !ok {
+		h.coupons.Release(redeemed...)
 		return
 	}
+	h.respondWithCheckout(w, http.StatusOK, completed, version)
+}
+
+func (h *HTTPHandler) handleCancelCheckout(w http.ResponseWriter, r *http.Request) {
+	h.mutateSession(w, r, nil, func(session domain.ICheckout) error {
+		return session.Cancel()
+	})
+}
+
+// handleGetPriceSchedule lists the scheduled price changes still to come, if
+// the pricing service plans any, with the version of the current price list.
+func (h *HTTPHandler) handleGetPriceSchedule(w http.ResponseWriter, r *http.Request) {
+	upcoming := []domain.PriceChange{}
+	if scheduler, ok := h.pricer.(PriceScheduler); ok {
+		upcoming = append(upcoming, scheduler.Upcoming()...)
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Version  int                  `json:"version"`
+		Upcoming []domain.PriceChange `json:"upcoming"`
+	}{
+		Version:  h.pricer.GetPriceList().Version,
+		Upcoming: upcoming,
+	})
+}
+
+// mutateSession applies mutate to the session named in the request path as
+// described for updateSession, responding with 204 No Content on success.
+func (h *HTTPHandler) mutateSession(w http.ResponseWriter, r *http.Request, reqBody any, mutate func(domain.ICheckout) error) {
+	if version, ok := h.updateSession(w, r, reqBody, mutate); ok {
+		w.Header().Set("ETag", etag(version))
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// updateSession decodes the request body into reqBody when it is non-nil, then
+// applies mutate to the session named in the request path as a single atomic
+// update. When the request carries an If-Match header the update only goes
+// ahead if it matches the session's current ETag. On success it returns the
+// session's new version; on failure it writes the error response.
+func (h *HTTPHandler) updateSession(w http.ResponseWriter, r *http.Request, reqBody any, mutate func(domain.ICheckout) error) (version int, ok bool) {
+	checkoutID := r.PathValue("checkoutID")
+
+	if reqBody != nil {
+		if err := json.NewDecoder(r.Body).Decode(reqBody); err != nil {
+			log.Printf("WARN: Failed to decode request body for checkoutID=%q err=%q", checkoutID, err)
+			respondWithError(w, http.StatusBadRequest, "invalid request body")
+			return 0, false
+		}
+	}
 
-	if err := h.repo.Save(session); err != nil {
-		log.Printf("ERROR: Failed to save session after scan for checkoutID %q: %v", checkoutID, err)
+	var mutateErr error
+	var newVersion int
+	err := h.sessions(r).Update(checkoutID, func(session domain.ICheckout) error {
+		if !etagMatches(r.Header.Get("If-Match"), session.GetVersion()) {
+			mutateErr = errPreconditionFailed
+			return mutateErr
+		}
+		mutateErr = mutate(session)
+		newVersion = session.GetVersion() + 1
+		return mutateErr
+	})
+	switch {
+	case err == nil:
+		return newVersion, true
+	case errors.Is(err, repository.ErrSessionNotFound):
+		log.Printf("INFO: Session not found for checkoutID=%q err=%q", checkoutID, err)
+		respondWithError(w, http.StatusNotFound, "session not found")
+	case errors.Is(err, repository.ErrSessionExpired):
+		log.Printf("INFO: Session expired for checkoutID=%q err=%q", checkoutID, err)
+		respondWithError(w, http.StatusGone, "session expired")
+	case errors.Is(err, errPreconditionFailed):
+		log.Printf("INFO: Stale If-Match for checkoutID=%q: if-match=%q", checkoutID, r.Header.Get("If-Match"))
+		respondWithError(w, http.StatusPreconditionFailed, err.Error())
+	case mutateErr != nil:
+		log.Printf("WARN: Invalid change for checkoutID=%q: err=%q", checkoutID, err)
+		respondWithError(w, statusForSessionError(err), err.Error())
+	default:
+		log.Printf("ERROR: Failed to save session after change for checkoutID %q: %v", checkoutID, err)
 		respondWithError(w, http.StatusInternalServerError, "could not save session")
-		return
 	}
+	return 0, false
+}
 
-	respondWithJSON(w, http.StatusNoContent, nil)
+// statusForSessionError maps an error from a session operation to an HTTP status code.
+func statusForSessionError(err error) int {
+	switch {
+	case errors.Is(err, checkout.ErrItemNotScanned), errors.Is(err, checkout.ErrCouponNotApplied):
+		return http.StatusNotFound
+	case errors.Is(err, checkout.ErrSessionNotOpen), errors.Is(err, checkout.ErrCouponAlreadyApplied),
+		errors.Is(err, checkout.ErrCouponNotStackable), errors.Is(err, coupon.ErrCouponExhausted):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
 }
 
 func (h *HTTPHandler) handleGetTotalPrice(w http.ResponseWriter, r *http.Request) {
 	checkoutID := r.PathValue("checkoutID")
 
-	session, err := h.repo.Get(checkoutID)
+	session, err := h.sessions(r).Get(checkoutID)
+	if errors.Is(err, repository.ErrSessionExpired) {
+		log.Printf("INFO: Session expired for checkoutID %q err=%q", checkoutID, err)
+		respondWithError(w, http.StatusGone, "session expired")
+		return
+	}
 	if err != nil {
 		log.Printf("INFO: Session not found for checkoutID %q err=%q", checkoutID, err)
 		respondWithError(w, http.StatusNotFound, "session not found")
 		return
 	}
 
-	totalPrice, _ := session.GetTotalPrice()
+	// Read the version before pricing: if the session changes in between, the
+	// ETag is stale rather than newer than the body, so If-Match fails safely.
+	h.respondWithCheckout(w, http.StatusOK, session, session.GetVersion())
+}
+
+// respondWithCheckout writes the itemised state of a session, tagged with version.
+func (h *HTTPHandler) respondWithCheckout(w http.ResponseWriter, code int, session domain.ICheckout, version int) {
+	breakdown, err := session.GetBreakdown()
+	if err != nil {
+		log.Printf("ERROR: Failed to price session for checkoutID %q: %v", session.GetID(), err)
+		respondWithError(w, http.StatusInternalServerError, "could not price session")
+		return
+	}
 
 	response := struct {
-		CheckoutID string `json:"checkoutId"`
-		TotalPrice int    `json:"totalPrice"`
+		CheckoutID   string                    `json:"checkoutId"`
+		State        domain.SessionState       `json:"state"`
+		Currency     string                    `json:"currency"`
+		Store        string                    `json:"store,omitempty"`
+		TotalPrice   domain.Money              `json:"totalPrice"`
+		TotalSavings domain.Money              `json:"totalSavings"`
+		Lines        []domain.LineItem         `json:"lines"`
+		Promotions   []domain.AppliedPromotion `json:"promotions"`
+		Subtotal     domain.Money              `json:"subtotal"`
+		Discounts    []domain.DiscountLine     `json:"discounts"`
+		Tax          *domain.TaxSummary        `json:"tax,omitempty"`
+		Coupons      []string                  `json:"coupons"`
+		Completion   *domain.Completion        `json:"completion,omitempty"`
 	}{
-		CheckoutID: session.GetID(),
-		TotalPrice: totalPrice,
+		CheckoutID:   session.GetID(),
+		State:        session.GetState(),
+		Currency:     session.GetCurrency(),
+		Store:        session.GetStore(),
+		TotalPrice:   breakdown.TotalPrice,
+		TotalSavings: breakdown.TotalSavings,
+		Lines:        breakdown.Lines,
+		Promotions:   breakdown.Promotions,
+		Subtotal:     breakdown.Subtotal,
+		Discounts:    breakdown.Discounts,
+		Tax:          breakdown.Tax,
+		Coupons:      couponCodes(session.GetCoupons()),
+		Completion:   session.GetCompletion(),
+	}
+	w.Header().Set("ETag", etag(version))
+	respondWithJSON(w, code, response)
+}
+
+// couponCodes returns the codes of coupons, in order.
+func couponCodes(coupons []domain.Coupon) []string {
+	codes := make([]string, 0, len(coupons))
+	for _, c := range coupons {
+		codes = append(codes, c.Code)
+	}
+	return codes
+}
+
+// etag formats a session version as a strong entity tag.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// etagMatches reports whether an If-Match header value allows a change to a
+// session at version. An empty header places no precondition on the change.
+func etagMatches(ifMatch string, version int) bool {
+	if ifMatch == "" {
+		return true
+	}
+	current := etag(version)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
 	}
-	respondWithJSON(w, http.StatusOK, response)
+	return false
 }
 
 func respondWithError(w http.ResponseWriter, code int, message string) {
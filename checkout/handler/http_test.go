@@ -3,24 +3,33 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/TheFodfather/checkoutapi/checkout"
 	"github.com/TheFodfather/checkoutapi/checkout/repository"
 	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/coupon"
 )
 
 // mockHandlerPricingService provides a mock implementation of the PricingService for testing.
 type mockHandlerPricingService struct{}
 
-// GetRules returns a predefined set of pricing rules for testing purposes.
-func (m *mockHandlerPricingService) GetRules() map[string]domain.PricingRule {
-	return map[string]domain.PricingRule{
-		"A": {UnitPrice: 50, SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: 130}},
-		"B": {UnitPrice: 30, SpecialPrice: &domain.SpecialPrice{Quantity: 2, Price: 45}},
-		"C": {UnitPrice: 20},
-		"D": {UnitPrice: 15},
+// GetPriceList returns a predefined set of pricing rules for testing purposes.
+func (m *mockHandlerPricingService) GetPriceList() domain.PriceList {
+	return domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: gbp(50), SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: gbp(130)}, Barcodes: []string{"4006381333931"}},
+			"B": {UnitPrice: gbp(30), SpecialPrice: &domain.SpecialPrice{Quantity: 2, Price: gbp(45)}},
+			"C": {UnitPrice: gbp(20)},
+			"D": {UnitPrice: gbp(15)},
+			"W": {UnitPrice: gbp(120), Unit: domain.UnitKilogram, ItemReference: "12345"},
+		},
 	}
 }
 
@@ -62,6 +71,188 @@ func TestCreateCheckout(t *testing.T) {
 			t.Errorf("Expected unique checkout IDs, but got the same ID twice: %s", id1)
 		}
 	})
+
+	t.Run("a session that cannot lock its prices on start is not created", func(t *testing.T) {
+		mux := http.NewServeMux()
+		repo := repository.NewInMemoryRepository()
+		New(repo, &unpricedPricingService{}, newTestCoupons(t), checkout.WithPriceLock(checkout.LockOnStart)).RegisterRoutes(mux)
+		req, _ := http.NewRequest("POST", "/checkouts", nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusInternalServerError {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+		}
+	})
+}
+
+// unpricedPricingService has no price list in the currency of its default one.
+type unpricedPricingService struct {
+	mockHandlerPricingService
+}
+
+func (m *unpricedPricingService) GetPriceListIn(currency string) (domain.PriceList, error) {
+	return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+}
+
+// mockMultiCurrencyPricingService is a pricing service with a EUR price list
+// besides the default one in GBP.
+type mockMultiCurrencyPricingService struct {
+	mockHandlerPricingService
+}
+
+func (m *mockMultiCurrencyPricingService) GetPriceListIn(currency string) (domain.PriceList, error) {
+	switch currency {
+	case "GBP":
+		return m.GetPriceList(), nil
+	case "EUR":
+		return domain.PriceList{Version: 1, Currency: "EUR", Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: domain.Money{Amount: 60, Currency: "EUR"}},
+		}}, nil
+	default:
+		return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+}
+
+func TestCreateCheckoutInCurrency(t *testing.T) {
+	create := func(t *testing.T, server http.Handler, payload string) (int, map[string]string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", "/checkouts", bytes.NewBufferString(payload))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var body map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Could not parse response body: %v", err)
+		}
+		return rr.Code, body
+	}
+
+	mux := http.NewServeMux()
+	New(repository.NewInMemoryRepository(), &mockMultiCurrencyPricingService{}, newTestCoupons(t)).RegisterRoutes(mux)
+
+	t.Run("sessions are priced in the currency they are created in", func(t *testing.T) {
+		status, body := create(t, mux, `{"currency": " eur "}`)
+		if status != http.StatusCreated || body["currency"] != "EUR" {
+			t.Fatalf("Expected a session created in EUR, got status %d and %v", status, body)
+		}
+		scanItem(t, mux, body["checkoutId"], "A")
+
+		req, _ := http.NewRequest("GET", "/checkouts/"+body["checkoutId"], nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		var checkout struct {
+			Currency   string       `json:"currency"`
+			TotalPrice domain.Money `json:"totalPrice"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &checkout); err != nil {
+			t.Fatalf("Could not parse response body: %v", err)
+		}
+		if checkout.Currency != "EUR" || checkout.TotalPrice != (domain.Money{Amount: 60, Currency: "EUR"}) {
+			t.Errorf("Expected a total of 60 EUR, got %+v", checkout)
+		}
+	})
+
+	t.Run("sessions default to the currency of the default price list", func(t *testing.T) {
+		if status, body := create(t, mux, ""); status != http.StatusCreated || body["currency"] != "GBP" {
+			t.Errorf("Expected a session created in GBP, got status %d and %v", status, body)
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		pricer  PricingService
+		payload string
+	}{
+		"unknown currency":                    {pricer: &mockMultiCurrencyPricingService{}, payload: `{"currency": "QQQ"}`},
+		"currency without a price list":       {pricer: &mockMultiCurrencyPricingService{}, payload: `{"currency": "USD"}`},
+		"currency other than the only list's": {pricer: &mockHandlerPricingService{}, payload: `{"currency": "EUR"}`},
+		"malformed request body":              {pricer: &mockMultiCurrencyPricingService{}, payload: `{"currency": `},
+	} {
+		t.Run("rejects a "+name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			New(repository.NewInMemoryRepository(), tc.pricer, newTestCoupons(t)).RegisterRoutes(mux)
+			if status, body := create(t, mux, tc.payload); status != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d with %v", http.StatusBadRequest, status, body)
+			}
+		})
+	}
+}
+
+// mockStorePricingService prices the store "manchester" with A at 45 and
+// every other store from the default price list.
+type mockStorePricingService struct {
+	mockHandlerPricingService
+}
+
+func (m *mockStorePricingService) GetPriceListFor(store, currency string) (domain.PriceList, error) {
+	if currency != "GBP" {
+		return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	prices := m.GetPriceList()
+	switch store {
+	case "":
+	case "manchester":
+		prices.Rules["A"] = domain.PricingRule{UnitPrice: gbp(45)}
+	default:
+		return domain.PriceList{}, fmt.Errorf("store '%s' %w", store, domain.ErrUnknownStore)
+	}
+	return prices, nil
+}
+
+func TestStoreScopedCheckouts(t *testing.T) {
+	mux := http.NewServeMux()
+	New(repository.NewInMemoryRepository(), &mockStorePricingService{}, newTestCoupons(t)).RegisterRoutes(mux)
+
+	send := func(method, path, store, payload string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(payload))
+		if store != "" {
+			req.Header.Set(StoreHeader, store)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("POST", "/checkouts", "manchester", "")
+	var created map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Could not parse response body: %v", err)
+	}
+	if rr.Code != http.StatusCreated || created["store"] != "manchester" {
+		t.Fatalf("Expected a session created for manchester, got status %d and %v", rr.Code, created)
+	}
+	path := "/checkouts/" + created["checkoutId"]
+	if status := send("POST", path+"/scan", "manchester", `{"sku":"A"}`).Code; status != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+
+	rr = send("GET", path, "manchester", "")
+	var checkout struct {
+		Store      string       `json:"store"`
+		TotalPrice domain.Money `json:"totalPrice"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &checkout); err != nil {
+		t.Fatalf("Could not parse response body: %v", err)
+	}
+	if checkout.Store != "manchester" || checkout.TotalPrice != gbp(45) {
+		t.Errorf("Expected a total of 45 at manchester's prices, got %+v", checkout)
+	}
+
+	for _, tc := range []struct {
+		method, path, store, payload string
+	}{
+		{method: "GET", path: path, store: "leeds"},
+		{method: "GET", path: path},
+		{method: "POST", path: path + "/scan", store: "leeds", payload: `{"sku":"A"}`},
+		{method: "POST", path: path + "/cancel"},
+	} {
+		if status := send(tc.method, tc.path, tc.store, tc.payload).Code; status != http.StatusNotFound {
+			t.Errorf("%s %s for store %q: handler returned wrong status code: got %v want %v", tc.method, tc.path, tc.store, status, http.StatusNotFound)
+		}
+	}
+
+	if status := send("POST", "/checkouts", "york", "").Code; status != http.StatusBadRequest {
+		t.Errorf("Expected status %d creating a checkout for an unconfigured store, got %d", http.StatusBadRequest, status)
+	}
 }
 
 func TestScanItem(t *testing.T) {
@@ -95,6 +286,39 @@ func TestScanItem(t *testing.T) {
 		}
 	})
 
+	for _, tc := range []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedTotal  int
+	}{
+		{name: "should scan a weighed item", payload: `{"sku":"W","weight":0.456}`, expectedStatus: http.StatusNoContent, expectedTotal: 55},
+		{name: "return 400 Bad Request for a weighed item scanned without a weight", payload: `{"sku":"W"}`, expectedStatus: http.StatusBadRequest},
+		{name: "return 400 Bad Request for a weight on an item sold each", payload: `{"sku":"A","weight":1}`, expectedStatus: http.StatusBadRequest},
+		{name: "return 400 Bad Request for a zero weight", payload: `{"sku":"W","weight":0}`, expectedStatus: http.StatusBadRequest},
+		{name: "return 400 Bad Request for a weight finer than a gram", payload: `{"sku":"W","weight":0.4561}`, expectedStatus: http.StatusBadRequest},
+		{name: "should scan an item by its barcode", payload: `{"barcode":"4006381333931"}`, expectedStatus: http.StatusNoContent, expectedTotal: 50},
+		{name: "should scan a weight embedded in a barcode", payload: `{"barcode":"2512345004561"}`, expectedStatus: http.StatusNoContent, expectedTotal: 55},
+		{name: "should scan a price embedded in a barcode", payload: `{"barcode":"2012345002500"}`, expectedStatus: http.StatusNoContent, expectedTotal: 250},
+		{name: "return 400 Bad Request for a barcode with a wrong check digit", payload: `{"barcode":"4006381333932"}`, expectedStatus: http.StatusBadRequest},
+		{name: "return 400 Bad Request for an unknown barcode", payload: `{"barcode":"5901234123457"}`, expectedStatus: http.StatusBadRequest},
+		{name: "return 400 Bad Request for a barcode with an SKU", payload: `{"sku":"A","barcode":"4006381333931"}`, expectedStatus: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			checkoutID := createCheckoutSession(t, server)
+			req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/scan", bytes.NewBufferString(tc.payload))
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			if total := getTotalPrice(t, server, checkoutID); total != tc.expectedTotal {
+				t.Errorf("Incorrect total price: got %d want %d", total, tc.expectedTotal)
+			}
+		})
+	}
+
 	t.Run("return 404 Not Found for a non-existent checkout session", func(t *testing.T) {
 		scanURL := "/checkouts/non-existent-id/scan"
 		payload := []byte(`{"sku":"A"}`)
@@ -109,6 +333,278 @@ func TestScanItem(t *testing.T) {
 	})
 }
 
+func TestChangeItems(t *testing.T) {
+	server := setupTestServer(t)
+
+	testCases := []struct {
+		name           string
+		scanned        []string
+		method         string
+		path           string
+		payload        string
+		expectedStatus int
+		expectedTotal  int
+	}{
+		{
+			name:           "unscan a single unit",
+			scanned:        []string{"A", "A", "A"},
+			method:         "POST",
+			path:           "/unscan",
+			payload:        `{"sku":"A"}`,
+			expectedStatus: http.StatusNoContent,
+			expectedTotal:  100,
+		},
+		{
+			name:           "unscan an item that was not scanned",
+			scanned:        []string{"A"},
+			method:         "POST",
+			path:           "/unscan",
+			payload:        `{"sku":"B"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedTotal:  50,
+		},
+		{
+			name:           "void a line",
+			scanned:        []string{"A", "B", "B"},
+			method:         "DELETE",
+			path:           "/items/B",
+			expectedStatus: http.StatusNoContent,
+			expectedTotal:  50,
+		},
+		{
+			name:           "void an unknown SKU",
+			scanned:        []string{"A"},
+			method:         "DELETE",
+			path:           "/items/Z",
+			expectedStatus: http.StatusBadRequest,
+			expectedTotal:  50,
+		},
+		{
+			name:           "set the quantity of a line",
+			scanned:        []string{"A"},
+			method:         "PUT",
+			path:           "/items/A",
+			payload:        `{"quantity":3}`,
+			expectedStatus: http.StatusNoContent,
+			expectedTotal:  130,
+		},
+		{
+			name:           "set a negative quantity",
+			scanned:        []string{"A"},
+			method:         "PUT",
+			path:           "/items/A",
+			payload:        `{"quantity":-2}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedTotal:  50,
+		},
+		{
+			name:           "set quantity without a quantity",
+			scanned:        []string{"A"},
+			method:         "PUT",
+			path:           "/items/A",
+			payload:        `{}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedTotal:  50,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkoutID := createCheckoutSession(t, server)
+			for _, sku := range tc.scanned {
+				scanItem(t, server, checkoutID, sku)
+			}
+
+			req, _ := http.NewRequest(tc.method, "/checkouts/"+checkoutID+tc.path, bytes.NewBufferString(tc.payload))
+			rr := httptest.NewRecorder()
+			server.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			if total := getTotalPrice(t, server, checkoutID); total != tc.expectedTotal {
+				t.Errorf("Incorrect total price: got %d want %d", total, tc.expectedTotal)
+			}
+		})
+	}
+
+	t.Run("return 404 Not Found for a non-existent checkout session", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/checkouts/non-existent-id/items/A", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
+func TestConcurrentScans(t *testing.T) {
+	server := setupTestServer(t)
+	checkoutID := createCheckoutSession(t, server)
+
+	const workers, scansPerWorker = 10, 30
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < scansPerWorker; j++ {
+				req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/scan", bytes.NewBufferString(`{"sku":"C"}`))
+				rr := httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				if status := rr.Code; status != http.StatusNoContent {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+				}
+
+				req, _ = http.NewRequest("GET", "/checkouts/"+checkoutID, nil)
+				rr = httptest.NewRecorder()
+				server.ServeHTTP(rr, req)
+				if status := rr.Code; status != http.StatusOK {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	expectedTotal := workers * scansPerWorker * 20
+	if total := getTotalPrice(t, server, checkoutID); total != expectedTotal {
+		t.Errorf("Incorrect total price: got %d want %d", total, expectedTotal)
+	}
+}
+
+func TestETags(t *testing.T) {
+	server := setupTestServer(t)
+
+	scanWithIfMatch := func(checkoutID, ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/scan", bytes.NewBufferString(`{"sku":"A"}`))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("GET returns the session version as an ETag", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		req, _ := http.NewRequest("GET", "/checkouts/"+checkoutID, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if etag := rr.Header().Get("ETag"); etag != `"1"` {
+			t.Errorf("Expected ETag %q for a new session, got %q", `"1"`, etag)
+		}
+	})
+
+	t.Run("scan with a matching If-Match succeeds and returns the new ETag", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		rr := scanWithIfMatch(checkoutID, `"1"`)
+
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `"2"` {
+			t.Errorf("Expected ETag %q after a scan, got %q", `"2"`, etag)
+		}
+	})
+
+	t.Run("return 412 Precondition Failed for a stale If-Match", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		scanItem(t, server, checkoutID, "B")
+		rr := scanWithIfMatch(checkoutID, `"1"`)
+
+		if status := rr.Code; status != http.StatusPreconditionFailed {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPreconditionFailed)
+		}
+		if total := getTotalPrice(t, server, checkoutID); total != 30 {
+			t.Errorf("Expected the rejected scan not to change the total, got %d", total)
+		}
+	})
+
+	t.Run("If-Match lists and wildcards are honoured", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		if status := scanWithIfMatch(checkoutID, `"7", "1"`).Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code for an If-Match list: got %v want %v", status, http.StatusNoContent)
+		}
+		if status := scanWithIfMatch(checkoutID, "*").Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code for a wildcard If-Match: got %v want %v", status, http.StatusNoContent)
+		}
+	})
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	server := setupTestServer(t)
+
+	t.Run("complete returns the frozen basket and rejects further scans", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		for _, sku := range []string{"B", "B", "D"} {
+			scanItem(t, server, checkoutID, sku)
+		}
+
+		req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/complete", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var body struct {
+			State      domain.SessionState `json:"state"`
+			TotalPrice domain.Money        `json:"totalPrice"`
+			Completion *domain.Completion  `json:"completion"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Could not parse response body: %v", err)
+		}
+		if body.State != domain.StateCompleted {
+			t.Errorf("Expected state %q, got %q", domain.StateCompleted, body.State)
+		}
+		if body.Completion == nil || body.Completion.FinalTotal != gbp(60) || body.TotalPrice != gbp(60) {
+			t.Errorf("Expected a final total of 60, got total %v and completion %+v", body.TotalPrice, body.Completion)
+		}
+
+		req, _ = http.NewRequest("POST", "/checkouts/"+checkoutID+"/scan", bytes.NewBufferString(`{"sku":"A"}`))
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code for a scan after completion: got %v want %v", status, http.StatusConflict)
+		}
+		if total := getTotalPrice(t, server, checkoutID); total != 60 {
+			t.Errorf("Expected the completed total to stay 60, got %d", total)
+		}
+	})
+
+	t.Run("cancel closes the session", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+
+		req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/cancel", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+
+		req, _ = http.NewRequest("POST", "/checkouts/"+checkoutID+"/complete", nil)
+		rr = httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code for completing a cancelled session: got %v want %v", status, http.StatusConflict)
+		}
+	})
+
+	t.Run("return 404 Not Found for a non-existent checkout session", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/checkouts/non-existent-id/complete", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+}
+
 func TestGetTotalPrice(t *testing.T) {
 	server := setupTestServer(t)
 
@@ -125,14 +621,14 @@ func TestGetTotalPrice(t *testing.T) {
 		}
 
 		var body struct {
-			TotalPrice int `json:"totalPrice"`
+			TotalPrice domain.Money `json:"totalPrice"`
 		}
 		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
 			t.Fatalf("Could not parse response body: %v", err)
 		}
 
-		if body.TotalPrice != 0 {
-			t.Errorf("Expected total price to be 0 for a new checkout, got %d", body.TotalPrice)
+		if body.TotalPrice != gbp(0) {
+			t.Errorf("Expected total price to be 0 GBP for a new checkout, got %v", body.TotalPrice)
 		}
 	})
 
@@ -164,14 +660,57 @@ func TestGetTotalPrice(t *testing.T) {
 		}
 
 		var body struct {
-			TotalPrice int `json:"totalPrice"`
+			TotalPrice domain.Money `json:"totalPrice"`
 		}
 		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
 			t.Fatalf("Could not parse response body: %v", err)
 		}
 
-		if body.TotalPrice != expectedTotal {
-			t.Errorf("Incorrect total price: got %d want %d", body.TotalPrice, expectedTotal)
+		if body.TotalPrice.Amount != int64(expectedTotal) {
+			t.Errorf("Incorrect total price: got %d want %d", body.TotalPrice.Amount, expectedTotal)
+		}
+	})
+
+	t.Run("itemised lines and savings after scanning items", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		for _, sku := range []string{"A", "A", "A", "C"} {
+			scanItem(t, server, checkoutID, sku)
+		}
+
+		req, _ := http.NewRequest("GET", "/checkouts/"+checkoutID, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var body struct {
+			TotalPrice   domain.Money      `json:"totalPrice"`
+			TotalSavings domain.Money      `json:"totalSavings"`
+			Lines        []domain.LineItem `json:"lines"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Could not parse response body: %v", err)
+		}
+
+		if body.TotalPrice != gbp(150) {
+			t.Errorf("Incorrect total price: got %v want %v", body.TotalPrice, gbp(150))
+		}
+		if body.TotalSavings != gbp(20) {
+			t.Errorf("Incorrect total savings: got %v want %v", body.TotalSavings, gbp(20))
+		}
+		expectedLines := []domain.LineItem{
+			{SKU: "A", Quantity: 3, UnitPrice: gbp(50), OffersApplied: 1, LineTotal: gbp(130), Savings: gbp(20), PricingVersion: 1},
+			{SKU: "C", Quantity: 1, UnitPrice: gbp(20), LineTotal: gbp(20), Savings: gbp(0), PricingVersion: 1},
+		}
+		if len(body.Lines) != len(expectedLines) {
+			t.Fatalf("Expected %d lines, got %d", len(expectedLines), len(body.Lines))
+		}
+		for i, expected := range expectedLines {
+			if body.Lines[i] != expected {
+				t.Errorf("Line %d: expected %+v, got %+v", i, expected, body.Lines[i])
+			}
 		}
 	})
 
@@ -187,16 +726,193 @@ func TestGetTotalPrice(t *testing.T) {
 	})
 }
 
+func TestCoupons(t *testing.T) {
+	server := setupTestServer(t)
+
+	applyCoupon := func(checkoutID, code string) int {
+		req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/coupons", bytes.NewBufferString(`{"code":"`+code+`"}`))
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	removeCoupon := func(checkoutID, code string) int {
+		req, _ := http.NewRequest("DELETE", "/checkouts/"+checkoutID+"/coupons/"+code, nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	complete := func(checkoutID string) int {
+		req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/complete", nil)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("applying and removing coupons", func(t *testing.T) {
+		checkoutID := createCheckoutSession(t, server)
+		for _, sku := range []string{"B", "B", "D"} {
+			scanItem(t, server, checkoutID, sku)
+		}
+
+		testCases := []struct {
+			name           string
+			change         func() int
+			expectedStatus int
+			expectedTotal  int
+		}{
+			{name: "Apply a coupon", change: func() int { return applyCoupon(checkoutID, "welcome10") }, expectedStatus: http.StatusNoContent, expectedTotal: 54},
+			{name: "Apply an unknown coupon", change: func() int { return applyCoupon(checkoutID, "NOPE") }, expectedStatus: http.StatusBadRequest, expectedTotal: 54},
+			{name: "Apply an expired coupon", change: func() int { return applyCoupon(checkoutID, "EXPIRED") }, expectedStatus: http.StatusBadRequest, expectedTotal: 54},
+			{name: "Apply a coupon that is not valid yet", change: func() int { return applyCoupon(checkoutID, "FUTURE") }, expectedStatus: http.StatusBadRequest, expectedTotal: 54},
+			{name: "Apply a coupon issued in another currency", change: func() int { return applyCoupon(checkoutID, "EUROS") }, expectedStatus: http.StatusBadRequest, expectedTotal: 54},
+			{name: "Apply without a code", change: func() int { return applyCoupon(checkoutID, "") }, expectedStatus: http.StatusBadRequest, expectedTotal: 54},
+			{name: "Apply a coupon twice", change: func() int { return applyCoupon(checkoutID, "WELCOME10") }, expectedStatus: http.StatusConflict, expectedTotal: 54},
+			{name: "Combine a coupon that is not stackable", change: func() int { return applyCoupon(checkoutID, "ONCE") }, expectedStatus: http.StatusConflict, expectedTotal: 54},
+			{name: "Remove a coupon", change: func() int { return removeCoupon(checkoutID, "WELCOME10") }, expectedStatus: http.StatusNoContent, expectedTotal: 60},
+			{name: "Remove a coupon that is not applied", change: func() int { return removeCoupon(checkoutID, "WELCOME10") }, expectedStatus: http.StatusNotFound, expectedTotal: 60},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if status := tc.change(); status != tc.expectedStatus {
+					t.Errorf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+				}
+				if total := getTotalPrice(t, server, checkoutID); total != tc.expectedTotal {
+					t.Errorf("Expected total %d, got %d", tc.expectedTotal, total)
+				}
+			})
+		}
+	})
+
+	t.Run("a single-use coupon is redeemed by the first session to complete", func(t *testing.T) {
+		first, second := createCheckoutSession(t, server), createCheckoutSession(t, server)
+		for _, checkoutID := range []string{first, second} {
+			scanItem(t, server, checkoutID, "A")
+			if status := applyCoupon(checkoutID, "ONCE"); status != http.StatusNoContent {
+				t.Fatalf("handler returned wrong status code applying an unused coupon: got %v want %v", status, http.StatusNoContent)
+			}
+		}
+
+		if status := complete(first); status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if status := complete(second); status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code completing with a used coupon: got %v want %v", status, http.StatusConflict)
+		}
+		if status := applyCoupon(createCheckoutSession(t, server), "ONCE"); status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code applying a used coupon: got %v want %v", status, http.StatusConflict)
+		}
+
+		// The session that lost the coupon is still open to complete without it.
+		if status := removeCoupon(second, "ONCE"); status != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if status := complete(second); status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+}
+
+// mockSchedulingPricingService is a pricing service with a price change planned.
+type mockSchedulingPricingService struct {
+	mockHandlerPricingService
+}
+
+func (m *mockSchedulingPricingService) Upcoming() []domain.PriceChange {
+	return []domain.PriceChange{{
+		EffectiveFrom: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		Rules:         map[string]*domain.PricingRule{"A": {UnitPrice: gbp(55)}},
+	}}
+}
+
+func TestGetPriceSchedule(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pricer   PricingService
+		expected int
+	}{
+		"pricing service without a schedule": {pricer: &mockHandlerPricingService{}, expected: 0},
+		"pricing service with a schedule":    {pricer: &mockSchedulingPricingService{}, expected: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			New(repository.NewInMemoryRepository(), tc.pricer, newTestCoupons(t)).RegisterRoutes(mux)
+
+			req, _ := http.NewRequest("GET", "/pricing/schedule", nil)
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if status := rr.Code; status != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+			}
+
+			var body struct {
+				Version  int                  `json:"version"`
+				Upcoming []domain.PriceChange `json:"upcoming"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Could not parse response body: %v", err)
+			}
+			if body.Version != 1 || body.Upcoming == nil || len(body.Upcoming) != tc.expected {
+				t.Errorf("Expected version 1 with %d upcoming changes, got %+v", tc.expected, body)
+			}
+		})
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	now := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+	repo := repository.NewInMemoryRepository(repository.WithIdleTTL(time.Minute), repository.WithClock(func() time.Time { return now }))
+	defer repo.Close()
+	handler := New(repo, &mockHandlerPricingService{}, newTestCoupons(t))
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	checkoutID := createCheckoutSession(t, mux)
+	now = now.Add(2 * time.Minute)
+
+	for _, tc := range []struct {
+		method, path, payload string
+	}{
+		{method: "GET", path: "/checkouts/" + checkoutID},
+		{method: "POST", path: "/checkouts/" + checkoutID + "/scan", payload: `{"sku":"A"}`},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.payload))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusGone {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", tc.method, tc.path, status, http.StatusGone)
+		}
+	}
+}
+
 // setupTestServer initializes a new test server with an in-memory repository and a mock pricer.
 func setupTestServer(t *testing.T) http.Handler {
 	repo := repository.NewInMemoryRepository()
 	pricer := &mockHandlerPricingService{}
-	handler := New(repo, pricer)
+	handler := New(repo, pricer, newTestCoupons(t))
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux)
 	return mux
 }
 
+// newTestCoupons returns a coupon registry for testing: a stackable 10% off,
+// a single-use 20 off, and coupons that have expired or are not valid yet.
+func newTestCoupons(t *testing.T) *coupon.Registry {
+	past := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	registry, err := coupon.NewRegistry([]domain.Coupon{
+		{Code: "WELCOME10", Type: domain.DiscountPercent, Percent: 10, Stackable: true},
+		{Code: "ONCE", Type: domain.DiscountFixed, Amount: gbp(20), MaxUses: 1},
+		{Code: "EXPIRED", Type: domain.DiscountFixed, Amount: gbp(20), ValidUntil: &past},
+		{Code: "FUTURE", Type: domain.DiscountFixed, Amount: gbp(20), ValidFrom: &future},
+		{Code: "EUROS", Type: domain.DiscountPercent, Currency: "EUR", Percent: 10, Stackable: true},
+	})
+	if err != nil {
+		t.Fatalf("Could not create coupons: %v", err)
+	}
+	return registry
+}
+
 func createCheckoutSession(t *testing.T, server http.Handler) string {
 	req, _ := http.NewRequest("POST", "/checkouts", nil)
 	rr := httptest.NewRecorder()
@@ -217,3 +933,37 @@ func createCheckoutSession(t *testing.T, server http.Handler) string {
 	}
 	return checkoutID
 }
+
+func scanItem(t *testing.T, server http.Handler, checkoutID, sku string) {
+	payload := []byte(`{"sku":"` + sku + `"}`)
+	req, _ := http.NewRequest("POST", "/checkouts/"+checkoutID+"/scan", bytes.NewBuffer(payload))
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Fatalf("Failed to scan item %s: got status %v", sku, status)
+	}
+}
+
+func getTotalPrice(t *testing.T, server http.Handler, checkoutID string) int {
+	req, _ := http.NewRequest("GET", "/checkouts/"+checkoutID, nil)
+	rr := httptest.NewRecorder()
+	server.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Failed to get total price: got status %v, want %v", status, http.StatusOK)
+	}
+
+	var body struct {
+		TotalPrice domain.Money `json:"totalPrice"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Could not parse response body: %v", err)
+	}
+	return int(body.TotalPrice.Amount)
+}
+
+// gbp returns an amount in pence, the currency of the test price lists.
+func gbp(amount int) domain.Money {
+	return domain.Money{Amount: int64(amount), Currency: "GBP"}
+}
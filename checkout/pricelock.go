@@ -0,0 +1,245 @@
+package checkout
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/barcode"
+	"github.com/TheFodfather/checkoutapi/pricing/promotion"
+	"github.com/TheFodfather/checkoutapi/pricing/tax"
+)
+
+// PriceLock controls when a session captures the prices it charges, so that a
+// reload of the pricing rules never reprices items already in a basket.
+type PriceLock int
+
+const (
+	// LockOnScan captures an SKU's pricing rule when it is first scanned. Every
+	// unit of that SKU is then charged at the captured rule.
+	LockOnScan PriceLock = iota
+	// LockOnStart captures the whole price list when the session is created.
+	LockOnStart
+)
+
+func (l PriceLock) String() string {
+	switch l {
+	case LockOnScan:
+		return "scan"
+	case LockOnStart:
+		return "start"
+	default:
+		return fmt.Sprintf("PriceLock(%d)", int(l))
+	}
+}
+
+// ParsePriceLock parses the name of a price lock mode, "scan" or "start".
+func ParsePriceLock(name string) (PriceLock, error) {
+	switch name {
+	case "scan":
+		return LockOnScan, nil
+	case "start":
+		return LockOnStart, nil
+	default:
+		return 0, fmt.Errorf("unknown price lock mode '%s', expected 'scan' or 'start'", name)
+	}
+}
+
+// Option configures a checkout session.
+type Option func(*session)
+
+// WithPriceLock sets when the session captures the prices it charges. Sessions
+// lock on scan by default.
+func WithPriceLock(lock PriceLock) Option {
+	return func(s *session) {
+		s.priceLock = lock
+	}
+}
+
+// WithCurrency sets the ISO 4217 code of the currency the session charges in,
+// which must be one the pricing service has a price list in. Sessions charge
+// in the currency of the default price list by default.
+func WithCurrency(currency string) Option {
+	return func(s *session) {
+		s.currency = currency
+	}
+}
+
+// WithStore scopes the session to a store, pricing it from the store's price
+// lists. Sessions are not scoped to a store by default.
+func WithStore(store string) Option {
+	return func(s *session) {
+		s.store = store
+	}
+}
+
+// WithClock sets the clock the session timestamps changes with. Sessions use
+// the system clock by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *session) {
+		s.now = now
+	}
+}
+
+// lockedRule is the pricing rule an SKU is charged at, with the version of the
+// price list it was captured from.
+type lockedRule struct {
+	rule    domain.PricingRule
+	version int
+}
+
+// lockedPromotions are the price list promotions, basket discounts and tax
+// rate table a session is charged with, captured with the first SKU it
+// locks, along with the version of the price list they were taken from.
+type lockedPromotions struct {
+	specs           []domain.PromotionSpec
+	basketDiscounts []domain.BasketDiscount
+	tax             *domain.TaxConfig
+	version         int
+	promotions      []domain.Promotion
+}
+
+// newLockedPromotions checks the promotions, basket discounts and tax rate
+// table of a price list, giving the basket discounts the currency of the
+// session when they have none.
+func newLockedPromotions(specs []domain.PromotionSpec, basketDiscounts []domain.BasketDiscount, taxConfig *domain.TaxConfig, version int, currency string) (*lockedPromotions, error) {
+	promotions, err := promotion.BuildAll(specs)
+	if err != nil {
+		return nil, err
+	}
+	if err := promotion.ValidateBasketDiscounts(basketDiscounts); err != nil {
+		return nil, err
+	}
+	basketDiscounts = append([]domain.BasketDiscount(nil), basketDiscounts...)
+	for i, discount := range basketDiscounts {
+		if basketDiscounts[i], err = discount.In(currency); err != nil {
+			return nil, fmt.Errorf("basket discount '%s' %w, session is in %s", discount.ID, err, currency)
+		}
+	}
+	if taxConfig != nil {
+		if err := tax.Validate(*taxConfig, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &lockedPromotions{specs: specs, basketDiscounts: basketDiscounts, tax: taxConfig, version: version, promotions: promotions}, nil
+}
+
+// currentPrices returns the price list new SKUs are validated and locked
+// against, or an error if there is none for the session's currency and store.
+func (s *session) currentPrices() (domain.PriceList, error) {
+	if s.startPrices != nil {
+		return *s.startPrices, nil
+	}
+	return s.pricesNow()
+}
+
+// currentCatalogue returns the barcodes of the price list currentPrices
+// returns. The pricing service's catalogue is used if it has one; otherwise
+// the session indexes the barcodes itself, once for each version of the price
+// list.
+func (s *session) currentCatalogue() (*barcode.Catalogue, error) {
+	if pricer, ok := s.pricer.(CataloguePricingService); ok && s.startPrices == nil {
+		catalogue, err := pricer.GetCatalogueFor(s.store, s.currency)
+		if err == nil && catalogue == nil {
+			err = fmt.Errorf("pricing service returned no barcode catalogue for store '%s' in %s", s.store, s.currency)
+		}
+		return catalogue, err
+	}
+	prices, err := s.currentPrices()
+	if err != nil {
+		return nil, err
+	}
+	if s.catalogue == nil || s.catalogueOf != prices.Version {
+		catalogue, err := barcode.NewCatalogue(prices.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index barcodes: %w", err)
+		}
+		s.catalogue, s.catalogueOf = catalogue, prices.Version
+	}
+	return s.catalogue, nil
+}
+
+// pricesNow fetches the pricing service's current price list in the session's
+// currency, for the session's store if it has one. A pricing service with a
+// single price list always returns it, and lockRule refuses its rules if they
+// are in another currency.
+func (s *session) pricesNow() (domain.PriceList, error) {
+	if pricer, ok := s.pricer.(StorePricingService); ok {
+		return pricer.GetPriceListFor(s.store, s.currency)
+	}
+	if s.store != "" {
+		return domain.PriceList{}, fmt.Errorf("store '%s' %w", s.store, domain.ErrUnknownStore)
+	}
+	if pricer, ok := s.pricer.(CurrencyPricingService); ok {
+		return pricer.GetPriceListIn(s.currency)
+	}
+	return s.pricer.GetPriceList(), nil
+}
+
+// pendingRule is the pricing rule an SKU is charged at, resolved but not yet
+// stored in the session, with the promotions the session captures along with
+// it if it has none yet.
+type pendingRule struct {
+	lockedRule
+	promotions *lockedPromotions // Nil if the rule is already locked or the session has promotions
+	isNew      bool
+}
+
+// resolveRule returns the pricing rule an SKU is charged at without changing
+// the session: the rule locked for it or, the first time it enters the
+// session, the rule to lock. It returns ErrUnknownSKU if the SKU has no rule
+// and domain.ErrCurrencyMismatch if it is not priced in the session's
+// currency. It returns domain.ErrCurrencyNotPriced or domain.ErrUnknownStore
+// if the session's currency or store has no price list.
+// The price list's promotions, basket discounts and tax rate table are
+// captured with the first rule.
+func (s *session) resolveRule(SKU string) (pendingRule, error) {
+	if locked, ok := s.lockedRules[SKU]; ok {
+		return pendingRule{lockedRule: locked}, nil
+	}
+	prices, err := s.currentPrices()
+	if err != nil {
+		return pendingRule{}, fmt.Errorf("sku '%s' %w", SKU, err)
+	}
+	rule, exists := prices.Rules[SKU]
+	if !exists {
+		return pendingRule{}, fmt.Errorf("sku '%s' %w", SKU, ErrUnknownSKU)
+	}
+	// Prices are only ever charged in the session's currency.
+	if currency := currencyOf(prices); currency != s.currency {
+		return pendingRule{}, fmt.Errorf("sku '%s' %w: priced in %s, session is in %s", SKU, domain.ErrCurrencyMismatch, currency, s.currency)
+	}
+	if rule, err = rule.In(s.currency); err != nil {
+		return pendingRule{}, fmt.Errorf("sku '%s' %w, session is in %s", SKU, err, s.currency)
+	}
+	pending := pendingRule{lockedRule: lockedRule{rule: rule, version: prices.Version}, isNew: true}
+	promotions := s.promotions
+	if promotions == nil {
+		if promotions, err = newLockedPromotions(prices.Promotions, prices.BasketDiscounts, prices.Tax, prices.Version, s.currency); err != nil {
+			return pendingRule{}, fmt.Errorf("failed to load promotions: %w", err)
+		}
+		pending.promotions = promotions
+	}
+	// A rule locked from a later price list must still be taxable at the
+	// rates locked with the first.
+	if promotions.tax != nil {
+		if err := tax.Validate(*promotions.tax, map[string]domain.PricingRule{SKU: rule}); err != nil {
+			return pendingRule{}, fmt.Errorf("failed to lock pricing rule: %w", err)
+		}
+	}
+	return pending, nil
+}
+
+// lockRule stores a rule resolved for an SKU in the session, along with the
+// promotions captured with it. It is only called once the change the rule was
+// resolved for has been checked, so a rejected change leaves the session as
+// it was.
+func (s *session) lockRule(SKU string, pending pendingRule) {
+	if !pending.isNew {
+		return
+	}
+	if pending.promotions != nil {
+		s.promotions = pending.promotions
+	}
+	s.lockedRules[SKU] = pending.lockedRule
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultMaxTombstones caps the number of evicted ids a tracker remembers, so
+// that a burst of evictions cannot grow its memory without bound.
+const defaultMaxTombstones = 100000
+
+// expiryTracker ages the sessions of a repository and picks the ones to
+// evict: those idle for idleTTL, those created more than maxAge ago and the
+// least recently used beyond maxSessions. A zero value disables each. Evicted
+// ids are remembered for a while, up to maxTombstones of them, so that the
+// repository can tell an expired session from an unknown one; an id forgotten
+// early is reported as not found.
+//
+// The tracker does not store sessions; the repository embedding it drops a
+// session through the remove function it passes in. It is not safe for
+// concurrent use, so the repository must hold its lock while using it.
+type expiryTracker struct {
+	idleTTL     time.Duration
+	maxAge      time.Duration
+	maxSessions int
+	now         func() time.Time
+
+	usage         map[string]*sessionUsage
+	recency       *list.List           // Session ids, most recently used first
+	expired       map[string]tombstone // Evicted session ids
+	evictions     *list.List           // Evicted session ids, earliest eviction first
+	maxTombstones int
+}
+
+// sessionUsage records the store of a tracked session, when it was created
+// and last used, and its place in the recency list.
+type sessionUsage struct {
+	store      string
+	createdAt  time.Time
+	lastAccess time.Time
+	recency    *list.Element
+}
+
+// tombstone records when a session was evicted, the store it belonged to and
+// its place in the eviction list.
+type tombstone struct {
+	evictedAt time.Time
+	store     string
+	eviction  *list.Element
+}
+
+func newExpiryTracker() expiryTracker {
+	return expiryTracker{
+		usage:         make(map[string]*sessionUsage),
+		recency:       list.New(),
+		expired:       make(map[string]tombstone),
+		evictions:     list.New(),
+		maxTombstones: defaultMaxTombstones,
+		now:           time.Now,
+	}
+}
+
+// enabled reports whether sessions are ever evicted.
+func (t *expiryTracker) enabled() bool {
+	return t.idleTTL > 0 || t.maxAge > 0 || t.maxSessions > 0
+}
+
+// add tracks a new session as the most recently used, forgetting any earlier
+// eviction of its id.
+func (t *expiryTracker) add(id, store string, now time.Time) {
+	t.usage[id] = &sessionUsage{store: store, createdAt: now, lastAccess: now, recency: t.recency.PushFront(id)}
+	t.unbury(id)
+}
+
+// restore tracks a session recovered from storage, replacing any earlier
+// record of it. Once every session is restored, orderByRecency lists them.
+func (t *expiryTracker) restore(id, store string, createdAt, lastAccess time.Time) {
+	t.usage[id] = &sessionUsage{store: store, createdAt: createdAt, lastAccess: lastAccess}
+}
+
+// orderByRecency lists the tracked sessions from the most to the least
+// recently used.
+func (t *expiryTracker) orderByRecency() {
+	ids := make([]string, 0, len(t.usage))
+	for id := range t.usage {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return t.usage[ids[i]].lastAccess.After(t.usage[ids[j]].lastAccess)
+	})
+	t.recency.Init()
+	for _, id := range ids {
+		t.usage[id].recency = t.recency.PushBack(id)
+	}
+}
+
+// use marks a tracked session as used now, evicting it through remove instead
+// if it has expired. It returns ErrSessionNotFound or an *ExpiredError for a
+// session that is not stored.
+func (t *expiryTracker) use(id string, remove func(id string) error) error {
+	now := t.now()
+	usage, ok := t.usage[id]
+	if ok && t.isExpired(usage, now) {
+		if err := t.evict(id, now, remove); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		return t.missing(id)
+	}
+	t.touch(id, now)
+	return nil
+}
+
+// touch marks a tracked session as used.
+func (t *expiryTracker) touch(id string, now time.Time) {
+	if usage, ok := t.usage[id]; ok {
+		usage.lastAccess = now
+		t.recency.MoveToFront(usage.recency)
+	}
+}
+
+func (t *expiryTracker) isExpired(usage *sessionUsage, now time.Time) bool {
+	if t.idleTTL > 0 && now.Sub(usage.lastAccess) >= t.idleTTL {
+		return true
+	}
+	return t.maxAge > 0 && now.Sub(usage.createdAt) >= t.maxAge
+}
+
+// missing returns the error for an id with no stored session: expired if it
+// was evicted recently, and not found otherwise.
+func (t *expiryTracker) missing(id string) error {
+	if tombstone, expired := t.expired[id]; expired {
+		return &ExpiredError{ID: id, Store: tombstone.store}
+	}
+	return fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+}
+
+// forget stops tracking a session without remembering it as expired.
+func (t *expiryTracker) forget(id string) {
+	usage, ok := t.usage[id]
+	if !ok {
+		return
+	}
+	if usage.recency != nil {
+		t.recency.Remove(usage.recency)
+	}
+	delete(t.usage, id)
+}
+
+// evict drops a session through remove and remembers that it expired.
+func (t *expiryTracker) evict(id string, now time.Time, remove func(id string) error) error {
+	if err := remove(id); err != nil {
+		return err
+	}
+	store := t.usage[id].store
+	t.forget(id)
+	t.bury(id, store, now)
+	return nil
+}
+
+// bury remembers that a session expired, forgetting the earliest evicted ids
+// beyond maxTombstones.
+func (t *expiryTracker) bury(id, store string, now time.Time) {
+	t.unbury(id)
+	t.expired[id] = tombstone{evictedAt: now, store: store, eviction: t.evictions.PushBack(id)}
+	for len(t.expired) > t.maxTombstones {
+		t.unbury(t.evictions.Front().Value.(string))
+	}
+}
+
+// unbury forgets that a session expired.
+func (t *expiryTracker) unbury(id string) {
+	if tombstone, ok := t.expired[id]; ok {
+		t.evictions.Remove(tombstone.eviction)
+		delete(t.expired, id)
+	}
+}
+
+// trim evicts the least recently used sessions beyond the cap. It stops at a
+// session that remove fails to drop, logging the error, rather than evict a
+// more recently used session in its place.
+func (t *expiryTracker) trim(now time.Time, remove func(id string) error) {
+	for t.maxSessions > 0 && len(t.usage) > t.maxSessions {
+		id := t.recency.Back().Value.(string)
+		if err := t.evict(id, now, remove); err != nil {
+			log.Printf("❌ Error evicting session %q: %v", id, err)
+			return
+		}
+	}
+}
+
+// tombstoneRetention is how long the ids of evicted sessions are remembered.
+func (t *expiryTracker) tombstoneRetention() time.Duration {
+	return max(t.idleTTL, t.maxAge, time.Hour)
+}
+
+// evictExpired evicts every expired session and the least recently used
+// sessions beyond the cap, and forgets evicted ids once they are past their
+// retention. A session that remove fails to drop is logged and left for the
+// next sweep, and the sweep carries on with the others.
+func (t *expiryTracker) evictExpired(remove func(id string) error) {
+	now := t.now()
+	for id, usage := range t.usage {
+		if t.isExpired(usage, now) {
+			if err := t.evict(id, now, remove); err != nil {
+				log.Printf("❌ Error evicting session %q: %v", id, err)
+			}
+		}
+	}
+	t.trim(now, remove)
+	retention := t.tombstoneRetention()
+	for element := t.evictions.Front(); element != nil; element = t.evictions.Front() {
+		id := element.Value.(string)
+		if now.Sub(t.expired[id].evictedAt) < retention {
+			break
+		}
+		t.unbury(id)
+	}
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExpiryTrackerKeepsEvictingPastAFailure(t *testing.T) {
+	clock := newFakeClock()
+	tracker := newExpiryTracker()
+	tracker.idleTTL = 30 * time.Minute
+	tracker.now = clock.Now
+	for _, id := range []string{"stuck", "idle", "also-idle"} {
+		tracker.add(id, "", clock.Now())
+	}
+	clock.Advance(time.Hour)
+
+	var removed []string
+	tracker.evictExpired(func(id string) error {
+		if id == "stuck" {
+			return errors.New("disk full")
+		}
+		removed = append(removed, id)
+		return nil
+	})
+
+	if len(removed) != 2 {
+		t.Errorf("Expected the sweep to carry on past the failed eviction and remove 2 sessions, got %v", removed)
+	}
+	if _, ok := tracker.usage["stuck"]; !ok {
+		t.Error("Expected the session that failed to be removed to still be tracked")
+	}
+	for _, id := range removed {
+		if err := tracker.missing(id); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("Expected %s to be remembered as expired, got %v", id, err)
+		}
+	}
+}
+
+func TestExpiryTrackerTrimStopsAtAFailure(t *testing.T) {
+	clock := newFakeClock()
+	tracker := newExpiryTracker()
+	tracker.maxSessions = 1
+	tracker.now = clock.Now
+	for _, id := range []string{"oldest", "older", "newest"} {
+		tracker.add(id, "", clock.Now())
+		clock.Advance(time.Minute)
+	}
+
+	tracker.trim(clock.Now(), func(id string) error {
+		if id == "oldest" {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+
+	if len(tracker.usage) != 3 {
+		t.Errorf("Expected no more recently used session to be evicted in place of one that failed, got %d sessions", len(tracker.usage))
+	}
+
+	tracker.trim(clock.Now(), func(string) error { return nil })
+	if _, ok := tracker.usage["newest"]; !ok || len(tracker.usage) != 1 {
+		t.Errorf("Expected only the most recently used session to remain once evictions succeed, got %d sessions", len(tracker.usage))
+	}
+}
+
+func TestExpiryTrackerCapsTombstones(t *testing.T) {
+	clock := newFakeClock()
+	tracker := newExpiryTracker()
+	tracker.maxSessions = 1
+	tracker.maxTombstones = 2
+	tracker.now = clock.Now
+	for _, id := range []string{"first", "second", "third", "fourth"} {
+		tracker.add(id, "", clock.Now())
+		tracker.trim(clock.Now(), func(string) error { return nil })
+		clock.Advance(time.Minute)
+	}
+
+	if len(tracker.expired) != 2 || tracker.evictions.Len() != 2 {
+		t.Fatalf("Expected 2 tombstones, got %d (%d in eviction order)", len(tracker.expired), tracker.evictions.Len())
+	}
+	for id, expected := range map[string]error{
+		"first":  ErrSessionNotFound,
+		"second": ErrSessionExpired,
+		"third":  ErrSessionExpired,
+	} {
+		if err := tracker.missing(id); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %s, got %v", expected, id, err)
+		}
+	}
+
+	// A session saved again under an evicted id is no longer remembered as
+	// expired, and its tombstone no longer counts against the cap.
+	tracker.add("second", "", clock.Now())
+	if len(tracker.expired) != 1 || tracker.evictions.Len() != 1 {
+		t.Errorf("Expected 1 tombstone once an id is reused, got %d (%d in eviction order)", len(tracker.expired), tracker.evictions.Len())
+	}
+}
@@ -0,0 +1,620 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/checkout"
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+const (
+	snapshotFileName = "sessions.snapshot"
+	walFileName      = "sessions.wal"
+
+	defaultSyncInterval        = time.Second
+	defaultCompactionInterval  = time.Minute
+	defaultCompactionThreshold = 10000
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log before every Save or Update returns, so no
+	// acknowledged change is lost in a crash.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs the log in the background, so a crash can lose the
+	// changes made since the last sync.
+	SyncInterval
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// ParseSyncPolicy parses the name of a sync policy: "always", "interval" or "never".
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy '%s', expected 'always', 'interval' or 'never'", name)
+}
+
+// FileOption configures a FileRepository.
+type FileOption func(*FileRepository)
+
+// WithSyncPolicy sets when the write-ahead log is fsynced, and how often when
+// the policy is SyncInterval.
+func WithSyncPolicy(policy SyncPolicy, interval time.Duration) FileOption {
+	return func(f *FileRepository) {
+		f.syncPolicy = policy
+		if interval > 0 {
+			f.syncInterval = interval
+		}
+	}
+}
+
+// WithCompaction sets how often the write-ahead log is compacted into a
+// snapshot, and the number of log records that triggers an early compaction.
+func WithCompaction(interval time.Duration, threshold int) FileOption {
+	return func(f *FileRepository) {
+		if interval > 0 {
+			f.compactionInterval = interval
+		}
+		if threshold > 0 {
+			f.compactionThreshold = threshold
+		}
+	}
+}
+
+// WithExpiry evicts sessions that have not been read or changed for idleTTL
+// or were created more than maxAge ago, and caps the number of stored
+// sessions at maxSessions, evicting the least recently used session to make
+// room for a new one. A zero value disables each.
+func WithExpiry(idleTTL, maxAge time.Duration, maxSessions int) FileOption {
+	return func(f *FileRepository) {
+		f.idleTTL = idleTTL
+		f.maxAge = maxAge
+		f.maxSessions = maxSessions
+	}
+}
+
+// WithFileClock replaces the clock used to age sessions.
+func WithFileClock(now func() time.Time) FileOption {
+	return func(f *FileRepository) {
+		f.now = now
+	}
+}
+
+// FileRepository stores sessions durably in a directory. Every change is
+// appended to a write-ahead log, which is periodically compacted into a
+// snapshot of all sessions. On startup the snapshot is loaded and the log
+// replayed, discarding a torn final record left by a crash.
+//
+// Sessions are kept serialised in memory, so Get returns a private copy and
+// saving a stale copy fails with a *VersionConflictError.
+//
+// Sessions live until they are deleted unless WithExpiry is given, in which
+// case they are evicted as by an InMemoryRepository. Deletions and evictions
+// are logged as tombstones, so they survive a restart. Sessions are aged from
+// the timestamps stored with them when they are recovered, so one that was
+// read but not changed before a restart is idle from its last change.
+type FileRepository struct {
+	dir    string
+	pricer checkout.PricingService
+
+	sessions   map[string]*fileEntry
+	wal        *os.File
+	walRecords int
+	expiryTracker
+
+	syncPolicy          SyncPolicy
+	syncInterval        time.Duration
+	compactionInterval  time.Duration
+	compactionThreshold int
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	sync.Mutex
+}
+
+// fileEntry holds a serialised session with the lock that serialises updates
+// to it. Its data and version are only changed while holding both the entry
+// lock and the repository lock, so either is enough to read them.
+type fileEntry struct {
+	data    json.RawMessage
+	version int
+	sync.Mutex
+}
+
+// walRecord is a single change in the write-ahead log. Each record is written
+// as one line holding its CRC-32 checksum and its JSON encoding.
+type walRecord struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id"`
+	Session json.RawMessage `json:"session,omitempty"`
+}
+
+const (
+	// walOpPut records a session as it was saved.
+	walOpPut = "put"
+	// walOpDelete records that a session was deleted or evicted.
+	walOpDelete = "delete"
+)
+
+// NewFileRepository opens or creates a session store in dir, recovering the
+// sessions stored there. Restored sessions are priced with pricer.
+func NewFileRepository(dir string, pricer checkout.PricingService, opts ...FileOption) (*FileRepository, error) {
+	f := &FileRepository{
+		dir:                 dir,
+		pricer:              pricer,
+		sessions:            make(map[string]*fileEntry),
+		expiryTracker:       newExpiryTracker(),
+		syncPolicy:          SyncAlways,
+		syncInterval:        defaultSyncInterval,
+		compactionInterval:  defaultCompactionInterval,
+		compactionThreshold: defaultCompactionThreshold,
+		stop:                make(chan struct{}),
+		done:                make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := f.replayWAL(); err != nil {
+		return nil, err
+	}
+	f.orderByRecency()
+	f.sweep()
+	log.Printf("✅ Recovered %d sessions from %s.", len(f.sessions), dir)
+
+	go f.runBackground()
+	return f, nil
+}
+
+func (f *FileRepository) Get(id string) (domain.ICheckout, error) {
+	f.Lock()
+	entry, err := f.entry(id)
+	var data json.RawMessage
+	if err == nil {
+		data = entry.data
+	}
+	f.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return checkout.Restore(f.pricer, data)
+}
+
+func (f *FileRepository) Save(co domain.ICheckout) error {
+	f.Lock()
+	entry, ok := f.sessions[co.GetID()]
+	if !ok {
+		defer f.Unlock()
+		return f.put(&fileEntry{}, co, true)
+	}
+	f.touch(co.GetID(), f.now())
+	f.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.version != co.GetVersion() {
+		return &VersionConflictError{ID: co.GetID(), Version: co.GetVersion(), StoredVersion: entry.version}
+	}
+	f.Lock()
+	defer f.Unlock()
+	return f.put(entry, co, false)
+}
+
+// Update restores a private copy of the session, applies fn to it and logs
+// the result, holding the session's lock throughout.
+func (f *FileRepository) Update(id string, fn func(co domain.ICheckout) error) error {
+	f.Lock()
+	entry, err := f.entry(id)
+	f.Unlock()
+	if err != nil {
+		return err
+	}
+
+	entry.Lock()
+	defer entry.Unlock()
+	co, err := checkout.Restore(f.pricer, entry.data)
+	if err != nil {
+		return err
+	}
+	if err := fn(co); err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	return f.put(entry, co, false)
+}
+
+// Delete removes a session, logging a tombstone so that it stays deleted
+// after a restart. It returns ErrSessionNotFound or ErrSessionExpired if
+// there is no session to delete.
+func (f *FileRepository) Delete(id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.wal == nil {
+		return errors.New("session repository is closed")
+	}
+	if _, ok := f.sessions[id]; !ok {
+		return f.missing(id)
+	}
+	if err := f.remove(id); err != nil {
+		return err
+	}
+	f.forget(id)
+	return nil
+}
+
+// entry looks up a live session and marks it as used, evicting it if it has
+// expired. The caller must hold the repository lock.
+func (f *FileRepository) entry(id string) (*fileEntry, error) {
+	if err := f.use(id, f.remove); err != nil {
+		return nil, err
+	}
+	return f.sessions[id], nil
+}
+
+// remove logs a tombstone for a stored session and drops it. The caller must
+// hold the repository lock.
+func (f *FileRepository) remove(id string) error {
+	if f.wal == nil {
+		return errors.New("session repository is closed")
+	}
+	if err := f.appendWAL(walRecord{Op: walOpDelete, ID: id}); err != nil {
+		return err
+	}
+	delete(f.sessions, id)
+	f.compactIfDue()
+	return nil
+}
+
+// sweep evicts every expired session and the least recently used sessions
+// beyond the cap, and forgets evicted ids once they are past their retention.
+func (f *FileRepository) sweep() {
+	f.Lock()
+	defer f.Unlock()
+	f.evictExpired(f.remove)
+}
+
+// put bumps the session's version, logs it and stores it in entry, adding the
+// entry to the repository when isNew. An existing entry that was deleted or
+// evicted meanwhile is not stored again. The caller must hold the repository
+// lock.
+func (f *FileRepository) put(entry *fileEntry, co domain.ICheckout, isNew bool) error {
+	if f.wal == nil {
+		return errors.New("session repository is closed")
+	}
+	if !isNew && f.sessions[co.GetID()] != entry {
+		return f.missing(co.GetID())
+	}
+	previous := co.GetVersion()
+	co.SetVersion(previous + 1)
+	data, err := json.Marshal(co)
+	if err != nil {
+		co.SetVersion(previous)
+		return fmt.Errorf("failed to serialise session '%s': %w", co.GetID(), err)
+	}
+	if err := f.appendWAL(walRecord{Op: walOpPut, ID: co.GetID(), Session: data}); err != nil {
+		co.SetVersion(previous)
+		return err
+	}
+	entry.data = data
+	entry.version = previous + 1
+	if isNew {
+		now := f.now()
+		f.sessions[co.GetID()] = entry
+		f.add(co.GetID(), co.GetStore(), now)
+		f.trim(now, f.remove)
+	}
+	f.compactIfDue()
+	return nil
+}
+
+// compactIfDue compacts the log once it holds the threshold number of
+// records. The caller must hold the repository lock.
+func (f *FileRepository) compactIfDue() {
+	if f.walRecords >= f.compactionThreshold {
+		if err := f.compact(); err != nil {
+			log.Printf("❌ Error compacting session log: %v", err)
+		}
+	}
+}
+
+func (f *FileRepository) appendWAL(record walRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode session log record: %w", err)
+	}
+	line := make([]byte, 0, len(payload)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(payload))...)
+	line = append(line, payload...)
+	line = append(line, '\n')
+	if _, err := f.wal.Write(line); err != nil {
+		return fmt.Errorf("failed to append to session log: %w", err)
+	}
+	if f.syncPolicy == SyncAlways {
+		if err := f.wal.Sync(); err != nil {
+			return fmt.Errorf("failed to sync session log: %w", err)
+		}
+	}
+	f.walRecords++
+	return nil
+}
+
+// Compact writes every session to a new snapshot and empties the write-ahead log.
+func (f *FileRepository) Compact() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.wal == nil {
+		return errors.New("session repository is closed")
+	}
+	return f.compact()
+}
+
+// compact replaces the snapshot and truncates the log. A crash part way
+// through leaves either the old snapshot and full log, or the new snapshot
+// and a log whose records it already contains; replaying either is safe.
+// The caller must hold the repository lock.
+func (f *FileRepository) compact() error {
+	snapshot := make(map[string]json.RawMessage, len(f.sessions))
+	for id, entry := range f.sessions {
+		snapshot[id] = entry.data
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode session snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(f.dir, snapshotFileName), data); err != nil {
+		return err
+	}
+	if err := f.wal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate session log: %w", err)
+	}
+	if _, err := f.wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind session log: %w", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync session log: %w", err)
+	}
+	f.walRecords = 0
+	return nil
+}
+
+// Close stops background work, compacts the log and closes it. It is safe
+// to call more than once.
+func (f *FileRepository) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		<-f.done
+
+		f.Lock()
+		defer f.Unlock()
+		f.closeErr = errors.Join(f.compact(), f.wal.Close())
+		f.wal = nil
+	})
+	return f.closeErr
+}
+
+func (f *FileRepository) runBackground() {
+	defer close(f.done)
+	compaction := time.NewTicker(f.compactionInterval)
+	defer compaction.Stop()
+	var syncs <-chan time.Time
+	if f.syncPolicy == SyncInterval {
+		ticker := time.NewTicker(f.syncInterval)
+		defer ticker.Stop()
+		syncs = ticker.C
+	}
+	var sweeps <-chan time.Time
+	if f.enabled() {
+		ticker := time.NewTicker(defaultJanitorInterval)
+		defer ticker.Stop()
+		sweeps = ticker.C
+	}
+
+	for {
+		select {
+		case <-syncs:
+			if err := f.wal.Sync(); err != nil {
+				log.Printf("❌ Error syncing session log: %v", err)
+			}
+		case <-compaction.C:
+			f.Lock()
+			if f.walRecords > 0 {
+				if err := f.compact(); err != nil {
+					log.Printf("❌ Error compacting session log: %v", err)
+				}
+			}
+			f.Unlock()
+		case <-sweeps:
+			f.sweep()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *FileRepository) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session snapshot: %w", err)
+	}
+	var snapshot map[string]json.RawMessage
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse session snapshot: %w", err)
+	}
+	for id, session := range snapshot {
+		if err := f.load(id, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayWAL applies the records in the write-ahead log and opens it for
+// appending. Replay stops at the first incomplete or corrupt record, which
+// can only be the last one written before a crash, and the log is truncated
+// there so that new records follow the last good one.
+func (f *FileRepository) replayWAL() error {
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open session log: %w", err)
+	}
+
+	reader := bufio.NewReader(wal)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("⚠️ Discarding incomplete session log record at offset %d", offset)
+			}
+			break
+		}
+		if err != nil {
+			wal.Close()
+			return fmt.Errorf("failed to read session log: %w", err)
+		}
+		record, err := decodeWALRecord(line)
+		if err != nil {
+			log.Printf("⚠️ Discarding corrupt session log record at offset %d: %v", offset, err)
+			break
+		}
+		if record.Op == walOpDelete {
+			delete(f.sessions, record.ID)
+			f.forget(record.ID)
+		} else if err := f.load(record.ID, record.Session); err != nil {
+			wal.Close()
+			return err
+		}
+		offset += int64(len(line))
+		f.walRecords++
+	}
+
+	if err := wal.Truncate(offset); err != nil {
+		wal.Close()
+		return fmt.Errorf("failed to truncate session log: %w", err)
+	}
+	if _, err := wal.Seek(offset, io.SeekStart); err != nil {
+		wal.Close()
+		return fmt.Errorf("failed to seek session log: %w", err)
+	}
+	f.wal = wal
+	return nil
+}
+
+// load stores a serialised session read from disk, aged from its creation
+// and last change. Sessions written without timestamps are aged from now.
+func (f *FileRepository) load(id string, data json.RawMessage) error {
+	var header struct {
+		Version   int       `json:"version"`
+		Store     string    `json:"store"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return fmt.Errorf("failed to parse stored session '%s': %w", id, err)
+	}
+	createdAt, lastAccess := header.CreatedAt, header.UpdatedAt
+	if createdAt.IsZero() {
+		createdAt = f.now()
+	}
+	if lastAccess.IsZero() {
+		lastAccess = createdAt
+	}
+	f.sessions[id] = &fileEntry{data: data, version: header.Version}
+	f.restore(id, header.Store, createdAt, lastAccess)
+	return nil
+}
+
+func decodeWALRecord(line []byte) (walRecord, error) {
+	var record walRecord
+	checksum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return record, errors.New("missing checksum")
+	}
+	expected, err := strconv.ParseUint(string(checksum), 16, 32)
+	if err != nil {
+		return record, fmt.Errorf("invalid checksum: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != uint32(expected) {
+		return record, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, err
+	}
+	if record.Op != walOpPut && record.Op != walOpDelete {
+		return record, fmt.Errorf("unknown operation '%s'", record.Op)
+	}
+	return record, nil
+}
+
+// writeFileAtomic replaces path with data so that readers, and a crash, see
+// either the old or the new contents in full.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmp, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Dir(path), err)
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
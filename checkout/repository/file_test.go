@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestFileRepository(t *testing.T) {
+	testSessionRepository(t, func(t *testing.T) SessionRepository {
+		return openFileRepository(t, t.TempDir())
+	})
+}
+
+func TestFileRepositoryRecovery(t *testing.T) {
+	pricer := &mockPricingService{}
+
+	// saveSessions stores an open session with A and B scanned and a completed
+	// session with three A, returning their ids.
+	saveSessions := func(t *testing.T, repo *FileRepository) (openID, completedID string) {
+		t.Helper()
+		open := newSession(t, pricer)
+		completed := newSession(t, pricer)
+		for _, co := range []domain.ICheckout{open, completed} {
+			if err := repo.Save(co); err != nil {
+				t.Fatalf("Save() returned an unexpected error: %v", err)
+			}
+		}
+		for _, sku := range []string{"A", "B"} {
+			if err := repo.Update(open.GetID(), func(co domain.ICheckout) error { return co.Scan(sku) }); err != nil {
+				t.Fatalf("Update() returned an unexpected error: %v", err)
+			}
+		}
+		err := repo.Update(completed.GetID(), func(co domain.ICheckout) error {
+			if err := co.SetQuantity("A", 3); err != nil {
+				return err
+			}
+			_, err := co.Complete()
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		return open.GetID(), completed.GetID()
+	}
+
+	expectRecovered := func(t *testing.T, repo *FileRepository, openID, completedID string) {
+		t.Helper()
+		open, err := repo.Get(openID)
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error for the open session: %v", err)
+		}
+		if total, _ := open.GetTotalPrice(); total.Amount != 80 || open.GetVersion() != 3 || open.GetState() != domain.StateOpen {
+			t.Errorf("Expected open session at version 3 with total 80, got version %d, state %s, total %d", open.GetVersion(), open.GetState(), total.Amount)
+		}
+		completed, err := repo.Get(completedID)
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error for the completed session: %v", err)
+		}
+		if completion := completed.GetCompletion(); completed.GetState() != domain.StateCompleted || completion == nil || completion.FinalTotal.Amount != 130 {
+			t.Errorf("Expected completed session with final total 130, got state %s and completion %+v", completed.GetState(), completion)
+		}
+	}
+
+	t.Run("sessions survive a clean restart", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := openFileRepository(t, dir)
+		openID, completedID := saveSessions(t, repo)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() returned an unexpected error: %v", err)
+		}
+
+		expectRecovered(t, openFileRepository(t, dir), openID, completedID)
+	})
+
+	t.Run("sessions survive a crash from the write-ahead log", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := openFileRepository(t, dir)
+		openID, completedID := saveSessions(t, repo)
+
+		// Copy the files as they are on disk, without closing, as a crash would leave them.
+		crashed := copyDir(t, dir)
+		expectRecovered(t, openFileRepository(t, crashed), openID, completedID)
+	})
+
+	t.Run("a torn final record is discarded", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := openFileRepository(t, dir)
+		openID, completedID := saveSessions(t, repo)
+		crashed := copyDir(t, dir)
+
+		wal, err := os.OpenFile(filepath.Join(crashed, walFileName), os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatalf("Could not open session log: %v", err)
+		}
+		if _, err := wal.WriteString(`1234abcd {"op":"put","id":"torn","sess`); err != nil {
+			t.Fatalf("Could not write torn record: %v", err)
+		}
+		wal.Close()
+
+		recovered := openFileRepository(t, crashed)
+		expectRecovered(t, recovered, openID, completedID)
+		if _, err := recovered.Get("torn"); err == nil {
+			t.Error("Expected the torn record to be discarded")
+		}
+
+		// New records must follow the last good one rather than the torn one.
+		if err := recovered.Update(openID, func(co domain.ICheckout) error { return co.Scan("B") }); err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		reopened := openFileRepository(t, copyDir(t, crashed))
+		co, err := reopened.Get(openID)
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if total, _ := co.GetTotalPrice(); total.Amount != 110 {
+			t.Errorf("Expected the update after recovery to be kept, got total %d", total.Amount)
+		}
+	})
+
+	t.Run("compaction folds the log into a snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := openFileRepository(t, dir, WithCompaction(0, 3))
+		openID, completedID := saveSessions(t, repo)
+
+		if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+			t.Errorf("Expected a snapshot after passing the compaction threshold: %v", err)
+		}
+		if err := repo.Compact(); err != nil {
+			t.Fatalf("Compact() returned an unexpected error: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(dir, walFileName))
+		if err != nil {
+			t.Fatalf("Could not stat session log: %v", err)
+		}
+		if info.Size() != 0 {
+			t.Errorf("Expected an empty session log after compaction, got %d bytes", info.Size())
+		}
+
+		expectRecovered(t, openFileRepository(t, copyDir(t, dir)), openID, completedID)
+	})
+}
+
+func TestFileRepositoryExpiry(t *testing.T) {
+	pricer := &mockPricingService{}
+	expectErr := func(t *testing.T, repo *FileRepository, id string, expected error) {
+		t.Helper()
+		_, err := repo.Get(id)
+		if expected == nil && err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if expected != nil && !errors.Is(err, expected) {
+			t.Fatalf("Expected %v, got %v", expected, err)
+		}
+	}
+	save := func(t *testing.T, repo *FileRepository) string {
+		t.Helper()
+		co := newSession(t, pricer)
+		if err := repo.Save(co); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+		return co.GetID()
+	}
+	// Sessions are stamped by the system clock, so the repository's clock
+	// starts from it too.
+	newClock := func() *fakeClock {
+		return &fakeClock{now: time.Now()}
+	}
+
+	t.Run("idle sessions expire and stay evicted after a restart", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := newClock()
+		repo := openFileRepository(t, dir, WithExpiry(30*time.Minute, 0, 0), WithFileClock(clock.Now))
+		idle, active := save(t, repo), save(t, repo)
+
+		clock.Advance(20 * time.Minute)
+		if err := repo.Update(active, func(co domain.ICheckout) error { return co.Scan("A") }); err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		clock.Advance(15 * time.Minute)
+		expectErr(t, repo, idle, ErrSessionExpired)
+		if err := repo.Update(idle, func(domain.ICheckout) error { return nil }); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("Expected ErrSessionExpired from Update(), got %v", err)
+		}
+		expectErr(t, repo, active, nil)
+
+		// The eviction is logged, so the session does not come back.
+		reopened := openFileRepository(t, copyDir(t, dir))
+		expectErr(t, reopened, idle, ErrSessionNotFound)
+		expectErr(t, reopened, active, nil)
+	})
+
+	t.Run("recovered sessions are aged from their last change", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := newClock()
+		repo := openFileRepository(t, dir)
+		id := save(t, repo)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() returned an unexpected error: %v", err)
+		}
+
+		clock.Advance(time.Hour)
+		reopened := openFileRepository(t, dir, WithExpiry(30*time.Minute, 0, 0), WithFileClock(clock.Now))
+		expectErr(t, reopened, id, ErrSessionExpired)
+	})
+
+	t.Run("the least recently used session is evicted beyond the cap", func(t *testing.T) {
+		clock := newClock()
+		repo := openFileRepository(t, t.TempDir(), WithExpiry(0, 0, 2), WithFileClock(clock.Now))
+		first := save(t, repo)
+		clock.Advance(time.Second)
+		second := save(t, repo)
+		clock.Advance(time.Second)
+		expectErr(t, repo, first, nil)
+		third := save(t, repo)
+
+		expectErr(t, repo, first, nil)
+		expectErr(t, repo, second, ErrSessionExpired)
+		expectErr(t, repo, third, nil)
+	})
+
+	t.Run("sweeping evicts expired sessions and later forgets them", func(t *testing.T) {
+		clock := newClock()
+		repo := openFileRepository(t, t.TempDir(), WithExpiry(30*time.Minute, 0, 0), WithFileClock(clock.Now))
+		idle := save(t, repo)
+		clock.Advance(35 * time.Minute)
+		repo.sweep()
+
+		repo.Lock()
+		remaining := len(repo.sessions)
+		repo.Unlock()
+		if remaining != 0 {
+			t.Errorf("Expected no session to remain after sweeping, got %d", remaining)
+		}
+		expectErr(t, repo, idle, ErrSessionExpired)
+
+		clock.Advance(2 * time.Hour)
+		repo.sweep()
+		expectErr(t, repo, idle, ErrSessionNotFound)
+	})
+
+	t.Run("deleted sessions stay deleted after a restart and compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := openFileRepository(t, dir)
+		deleted, kept := save(t, repo), save(t, repo)
+		if err := repo.Delete(deleted); err != nil {
+			t.Fatalf("Delete() returned an unexpected error: %v", err)
+		}
+		if err := repo.Delete(deleted); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound deleting a deleted session, got %v", err)
+		}
+		expectErr(t, repo, deleted, ErrSessionNotFound)
+
+		expectErr(t, openFileRepository(t, copyDir(t, dir)), deleted, ErrSessionNotFound)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() returned an unexpected error: %v", err)
+		}
+		reopened := openFileRepository(t, dir)
+		expectErr(t, reopened, deleted, ErrSessionNotFound)
+		expectErr(t, reopened, kept, nil)
+	})
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		parsed, err := ParseSyncPolicy(policy.String())
+		if err != nil {
+			t.Errorf("ParseSyncPolicy(%q) returned an unexpected error: %v", policy, err)
+		}
+		if parsed != policy {
+			t.Errorf("ParseSyncPolicy(%q): expected %v, got %v", policy, policy, parsed)
+		}
+	}
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an error parsing an unknown sync policy, but got nil")
+	}
+}
+
+func openFileRepository(t *testing.T, dir string, opts ...FileOption) *FileRepository {
+	t.Helper()
+	repo, err := NewFileRepository(dir, &mockPricingService{}, opts...)
+	if err != nil {
+		t.Fatalf("NewFileRepository() returned an unexpected error: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func copyDir(t *testing.T, dir string) string {
+	t.Helper()
+	copied := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("Could not read %s: %v", entry.Name(), err)
+		}
+		if err := os.WriteFile(filepath.Join(copied, entry.Name()), data, 0o644); err != nil {
+			t.Fatalf("Could not write %s: %v", entry.Name(), err)
+		}
+	}
+	return copied
+}
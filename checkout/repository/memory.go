@@ -1,42 +1,243 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/TheFodfather/checkoutapi/domain"
 )
 
+var (
+	// ErrSessionNotFound is returned when no session is stored under an id.
+	ErrSessionNotFound = errors.New("not found")
+	// ErrSessionExpired is returned when a session has been evicted, either for
+	// being idle or too old, or to make room for newer sessions.
+	ErrSessionExpired = errors.New("has expired")
+)
+
+// VersionConflictError is returned by Save when the session being saved is not
+// at the stored version, meaning it was changed by someone else since it was read.
+type VersionConflictError struct {
+	ID            string
+	Version       int
+	StoredVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("session with id '%s' is at version %d, cannot save stale version %d", e.ID, e.StoredVersion, e.Version)
+}
+
+// ExpiredError is returned when a session has been evicted. It matches
+// ErrSessionExpired and names the store the session belonged to.
+type ExpiredError struct {
+	ID    string
+	Store string
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("session with id '%s' %v", e.ID, ErrSessionExpired)
+}
+
+func (e *ExpiredError) Unwrap() error {
+	return ErrSessionExpired
+}
+
 // SessionRepository defines the interface for storing checkout sessions.
+// Every successful Save or Update increments the stored session's version by one.
 type SessionRepository interface {
 	Get(id string) (domain.ICheckout, error)
+	// Save stores a session, returning a *VersionConflictError if the session
+	// already exists at a different version.
 	Save(co domain.ICheckout) error
+	// Update applies fn to the stored session as a single atomic
+	// read-modify-write; no other update to the same session runs meanwhile.
+	Update(id string, fn func(co domain.ICheckout) error) error
+}
+
+// defaultJanitorInterval is how often expired sessions are swept when no
+// interval is configured.
+const defaultJanitorInterval = time.Minute
+
+// InMemoryOption configures an InMemoryRepository.
+type InMemoryOption func(*InMemoryRepository)
+
+// WithIdleTTL evicts sessions that have not been read or changed for ttl.
+func WithIdleTTL(ttl time.Duration) InMemoryOption {
+	return func(m *InMemoryRepository) {
+		m.idleTTL = ttl
+	}
+}
+
+// WithMaxAge evicts sessions once they were created more than maxAge ago,
+// however recently they were used.
+func WithMaxAge(maxAge time.Duration) InMemoryOption {
+	return func(m *InMemoryRepository) {
+		m.maxAge = maxAge
+	}
+}
+
+// WithMaxSessions caps the number of stored sessions, evicting the least
+// recently used session to make room for a new one.
+func WithMaxSessions(n int) InMemoryOption {
+	return func(m *InMemoryRepository) {
+		m.maxSessions = n
+	}
+}
+
+// WithJanitorInterval sets how often the background janitor sweeps out
+// expired sessions.
+func WithJanitorInterval(interval time.Duration) InMemoryOption {
+	return func(m *InMemoryRepository) {
+		m.janitorInterval = interval
+	}
+}
+
+// WithClock replaces the clock used to age sessions.
+func WithClock(now func() time.Time) InMemoryOption {
+	return func(m *InMemoryRepository) {
+		m.now = now
+	}
 }
 
+// InMemoryRepository stores sessions in memory. Sessions live forever unless
+// an idle TTL, max age or session cap is configured, in which case a janitor
+// goroutine evicts them until Close is called. Evicted ids are remembered for
+// a while so that Get can tell an expired session from an unknown one.
 type InMemoryRepository struct {
-	sessions map[string]domain.ICheckout
+	sessions map[string]*memoryEntry
+	expiryTracker
+
+	janitorInterval time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 	sync.RWMutex
 }
 
-func NewInMemoryRepository() *InMemoryRepository {
-	return &InMemoryRepository{
-		sessions: make(map[string]domain.ICheckout),
+// memoryEntry holds a stored session with the lock that serialises updates to it.
+type memoryEntry struct {
+	co domain.ICheckout
+	sync.Mutex
+}
+
+func NewInMemoryRepository(opts ...InMemoryOption) *InMemoryRepository {
+	m := &InMemoryRepository{
+		sessions:        make(map[string]*memoryEntry),
+		expiryTracker:   newExpiryTracker(),
+		janitorInterval: defaultJanitorInterval,
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	if m.enabled() {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.runJanitor()
+	}
+	return m
+}
+
+// Close stops the background janitor, if one is running. It is safe to call
+// more than once.
+func (m *InMemoryRepository) Close() error {
+	m.closeOnce.Do(func() {
+		if m.stop != nil {
+			close(m.stop)
+			<-m.done
+		}
+	})
+	return nil
 }
 
 func (m *InMemoryRepository) Get(id string) (domain.ICheckout, error) {
-	m.RLock()
-	defer m.RUnlock()
-	co, ok := m.sessions[id]
-	if !ok {
-		return nil, fmt.Errorf("session with id '%s' not found", id)
+	entry, err := m.entry(id)
+	if err != nil {
+		return nil, err
 	}
-	return co, nil
+	entry.Lock()
+	defer entry.Unlock()
+	return entry.co, nil
 }
 
 func (m *InMemoryRepository) Save(co domain.ICheckout) error {
+	m.Lock()
+	entry, ok := m.sessions[co.GetID()]
+	if !ok {
+		co.SetVersion(co.GetVersion() + 1)
+		now := m.now()
+		m.sessions[co.GetID()] = &memoryEntry{co: co}
+		m.add(co.GetID(), co.GetStore(), now)
+		m.trim(now, m.drop)
+		m.Unlock()
+		return nil
+	}
+	m.touch(co.GetID(), m.now())
+	m.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+	if stored := entry.co.GetVersion(); stored != co.GetVersion() {
+		return &VersionConflictError{ID: co.GetID(), Version: co.GetVersion(), StoredVersion: stored}
+	}
+	co.SetVersion(co.GetVersion() + 1)
+	entry.co = co
+	return nil
+}
+
+// Update holds the session's lock while fn runs, so concurrent updates to the
+// same session are applied one at a time while other sessions are unaffected.
+func (m *InMemoryRepository) Update(id string, fn func(co domain.ICheckout) error) error {
+	entry, err := m.entry(id)
+	if err != nil {
+		return err
+	}
+	entry.Lock()
+	defer entry.Unlock()
+	if err := fn(entry.co); err != nil {
+		return err
+	}
+	entry.co.SetVersion(entry.co.GetVersion() + 1)
+	return nil
+}
+
+// entry looks up a live session and marks it as used.
+func (m *InMemoryRepository) entry(id string) (*memoryEntry, error) {
 	m.Lock()
 	defer m.Unlock()
-	m.sessions[co.GetID()] = co
+	if err := m.use(id, m.drop); err != nil {
+		return nil, err
+	}
+	return m.sessions[id], nil
+}
+
+// drop removes a session. The caller must hold the repository lock.
+func (m *InMemoryRepository) drop(id string) error {
+	delete(m.sessions, id)
 	return nil
 }
+
+// sweep evicts every expired session and the least recently used sessions
+// beyond the cap, and forgets evicted ids once they are past their retention.
+func (m *InMemoryRepository) sweep() {
+	m.Lock()
+	defer m.Unlock()
+	m.evictExpired(m.drop)
+}
+
+func (m *InMemoryRepository) runJanitor() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.stop:
+			return
+		}
+	}
+}
@@ -1,17 +1,49 @@
 package repository
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
 
 	"github.com/google/uuid"
 )
 
-type mockCheckout struct{ id string }
+type mockCheckout struct {
+	id      string
+	version int
+	store   string
+}
+
+func (m *mockCheckout) Scan(SKU string) (err error)                          { return nil }
+func (m *mockCheckout) ScanMeasure(SKU string, measure domain.Measure) error { return nil }
+func (m *mockCheckout) ScanBarcode(code string) error                        { return nil }
+func (m *mockCheckout) Remove(SKU string) (err error)                        { return nil }
+func (m *mockCheckout) Void(SKU string) (err error)                          { return nil }
+func (m *mockCheckout) SetQuantity(SKU string, quantity int) error           { return nil }
+func (m *mockCheckout) ApplyCoupon(coupon domain.Coupon) error               { return nil }
+func (m *mockCheckout) RemoveCoupon(code string) error                       { return nil }
+func (m *mockCheckout) GetCoupons() []domain.Coupon                          { return nil }
+func (m *mockCheckout) GetTotalPrice() (domain.Money, error)                 { return domain.Money{}, nil }
+func (m *mockCheckout) GetBreakdown() (domain.Breakdown, error)              { return domain.Breakdown{}, nil }
+func (m *mockCheckout) GetID() string                                        { return m.id }
+func (m *mockCheckout) GetVersion() int                                      { return m.version }
+func (m *mockCheckout) SetVersion(version int)                               { m.version = version }
+func (m *mockCheckout) GetState() domain.SessionState                        { return domain.StateOpen }
+func (m *mockCheckout) Complete() (domain.Completion, error)                 { return domain.Completion{}, nil }
+func (m *mockCheckout) Cancel() (err error)                                  { return nil }
+func (m *mockCheckout) GetCompletion() *domain.Completion                    { return nil }
+func (m *mockCheckout) GetScannedItems() map[string]int                      { return nil }
+func (m *mockCheckout) GetCurrency() string                                  { return domain.DefaultCurrency }
+func (m *mockCheckout) GetStore() string                                     { return m.store }
 
-func (m *mockCheckout) Scan(SKU string) (err error)                { return nil }
-func (m *mockCheckout) GetTotalPrice() (totalPrice int, err error) { return 0, nil }
-func (m *mockCheckout) GetID() string                              { return m.id }
-func (m *mockCheckout) GetScannedItems() map[string]int            { return nil }
+func TestInMemoryRepository(t *testing.T) {
+	testSessionRepository(t, func(t *testing.T) SessionRepository {
+		return NewInMemoryRepository()
+	})
+}
 
 func TestGetNotFound(t *testing.T) {
 	repo := NewInMemoryRepository()
@@ -21,6 +53,9 @@ func TestGetNotFound(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected error for getting nonexistent session, but got nil")
 	}
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
 }
 
 func TestSaveAndGet(t *testing.T) {
@@ -42,3 +77,250 @@ func TestSaveAndGet(t *testing.T) {
 		t.Errorf("Expected retrieved ID to be %s, got %s", testID, retrieved.GetID())
 	}
 }
+
+func TestUpdate(t *testing.T) {
+	repo := NewInMemoryRepository()
+	co := &mockCheckout{id: uuid.New().String()}
+	if err := repo.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+
+	t.Run("applies the update to the stored session", func(t *testing.T) {
+		var updated domain.ICheckout
+		err := repo.Update(co.id, func(stored domain.ICheckout) error {
+			updated = stored
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		if updated == nil || updated.GetID() != co.id {
+			t.Errorf("Expected update to receive session %s, got %v", co.id, updated)
+		}
+	})
+
+	t.Run("returns the error from the update", func(t *testing.T) {
+		updateErr := errors.New("update failed")
+		err := repo.Update(co.id, func(domain.ICheckout) error { return updateErr })
+		if !errors.Is(err, updateErr) {
+			t.Errorf("Expected update error, got %v", err)
+		}
+	})
+
+	t.Run("returns ErrSessionNotFound for a nonexistent session", func(t *testing.T) {
+		called := false
+		err := repo.Update("nonexistent-id", func(domain.ICheckout) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound, got %v", err)
+		}
+		if called {
+			t.Error("Expected update not to be called for a nonexistent session")
+		}
+	})
+}
+
+func TestSaveVersions(t *testing.T) {
+	repo := NewInMemoryRepository()
+	testID := uuid.New().String()
+	co := &mockCheckout{id: testID}
+
+	if err := repo.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+	if co.GetVersion() != 1 {
+		t.Errorf("Expected a new session to be saved at version 1, got %d", co.GetVersion())
+	}
+
+	if err := repo.Update(testID, func(domain.ICheckout) error { return nil }); err != nil {
+		t.Fatalf("Update() returned an unexpected error: %v", err)
+	}
+	if co.GetVersion() != 2 {
+		t.Errorf("Expected an update to bump the version to 2, got %d", co.GetVersion())
+	}
+
+	if err := repo.Update(testID, func(domain.ICheckout) error { return errors.New("update failed") }); err == nil {
+		t.Fatal("Expected an error from a failed update, but got nil")
+	}
+	if co.GetVersion() != 2 {
+		t.Errorf("Expected a failed update to keep version 2, got %d", co.GetVersion())
+	}
+
+	stale := &mockCheckout{id: testID, version: 1}
+	err := repo.Save(stale)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a VersionConflictError saving a stale session, got %v", err)
+	}
+	if conflict.Version != 1 || conflict.StoredVersion != 2 {
+		t.Errorf("Expected conflict between version 1 and stored version 2, got %+v", conflict)
+	}
+
+	current := &mockCheckout{id: testID, version: 2}
+	if err := repo.Save(current); err != nil {
+		t.Fatalf("Save() returned an unexpected error for a current session: %v", err)
+	}
+	if current.GetVersion() != 3 {
+		t.Errorf("Expected save to bump the version to 3, got %d", current.GetVersion())
+	}
+}
+
+// fakeClock is a manually advanced clock for testing session expiry.
+type fakeClock struct {
+	now time.Time
+	sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	expectErr := func(t *testing.T, repo *InMemoryRepository, id string, expected error) {
+		t.Helper()
+		_, err := repo.Get(id)
+		if expected == nil && err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if expected != nil && !errors.Is(err, expected) {
+			t.Fatalf("Expected %v, got %v", expected, err)
+		}
+	}
+
+	t.Run("idle sessions expire unless they are used", func(t *testing.T) {
+		clock := newFakeClock()
+		repo := NewInMemoryRepository(WithIdleTTL(30*time.Minute), WithClock(clock.Now))
+		defer repo.Close()
+		co := &mockCheckout{id: uuid.New().String()}
+		if err := repo.Save(co); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+
+		clock.Advance(20 * time.Minute)
+		expectErr(t, repo, co.id, nil)
+		clock.Advance(20 * time.Minute)
+		if err := repo.Update(co.id, func(domain.ICheckout) error { return nil }); err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		clock.Advance(30 * time.Minute)
+		expectErr(t, repo, co.id, ErrSessionExpired)
+		if err := repo.Update(co.id, func(domain.ICheckout) error { return nil }); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("Expected ErrSessionExpired from Update(), got %v", err)
+		}
+		expectErr(t, repo, "nonexistent-id", ErrSessionNotFound)
+	})
+
+	t.Run("sessions expire at their max age however recently they were used", func(t *testing.T) {
+		clock := newFakeClock()
+		repo := NewInMemoryRepository(WithIdleTTL(30*time.Minute), WithMaxAge(time.Hour), WithClock(clock.Now))
+		defer repo.Close()
+		co := &mockCheckout{id: uuid.New().String()}
+		if err := repo.Save(co); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+
+		for i := 0; i < 5; i++ {
+			clock.Advance(10 * time.Minute)
+			expectErr(t, repo, co.id, nil)
+		}
+		clock.Advance(10 * time.Minute)
+		expectErr(t, repo, co.id, ErrSessionExpired)
+	})
+
+	t.Run("the least recently used session is evicted beyond the cap", func(t *testing.T) {
+		clock := newFakeClock()
+		repo := NewInMemoryRepository(WithMaxSessions(2), WithClock(clock.Now))
+		defer repo.Close()
+		first := &mockCheckout{id: "first"}
+		second := &mockCheckout{id: "second"}
+		third := &mockCheckout{id: "third"}
+
+		for _, co := range []*mockCheckout{first, second} {
+			if err := repo.Save(co); err != nil {
+				t.Fatalf("Save() returned an unexpected error: %v", err)
+			}
+		}
+		expectErr(t, repo, first.id, nil)
+		if err := repo.Save(third); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+
+		expectErr(t, repo, first.id, nil)
+		expectErr(t, repo, second.id, ErrSessionExpired)
+		expectErr(t, repo, third.id, nil)
+	})
+
+	t.Run("sweeping evicts expired sessions and later forgets them", func(t *testing.T) {
+		clock := newFakeClock()
+		repo := NewInMemoryRepository(WithIdleTTL(30*time.Minute), WithClock(clock.Now))
+		defer repo.Close()
+		idle := &mockCheckout{id: "idle"}
+		active := &mockCheckout{id: "active"}
+		for _, co := range []*mockCheckout{idle, active} {
+			if err := repo.Save(co); err != nil {
+				t.Fatalf("Save() returned an unexpected error: %v", err)
+			}
+		}
+
+		clock.Advance(20 * time.Minute)
+		expectErr(t, repo, active.id, nil)
+		clock.Advance(15 * time.Minute)
+		repo.sweep()
+
+		repo.RLock()
+		remaining := len(repo.sessions)
+		repo.RUnlock()
+		if remaining != 1 {
+			t.Errorf("Expected 1 session to remain after sweeping, got %d", remaining)
+		}
+		expectErr(t, repo, idle.id, ErrSessionExpired)
+
+		clock.Advance(2 * time.Hour)
+		repo.sweep()
+		expectErr(t, repo, idle.id, ErrSessionNotFound)
+	})
+
+	t.Run("the janitor evicts in the background until closed", func(t *testing.T) {
+		clock := newFakeClock()
+		repo := NewInMemoryRepository(WithIdleTTL(time.Minute), WithJanitorInterval(time.Millisecond), WithClock(clock.Now))
+		if err := repo.Save(&mockCheckout{id: "idle"}); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+		clock.Advance(2 * time.Minute)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			repo.RLock()
+			remaining := len(repo.sessions)
+			repo.RUnlock()
+			if remaining == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the janitor to evict the idle session")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() returned an unexpected error: %v", err)
+		}
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Close() returned an unexpected error when called twice: %v", err)
+		}
+	})
+}
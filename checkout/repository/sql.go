@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/checkout"
+	"github.com/TheFodfather/checkoutapi/domain"
+
+	_ "modernc.org/sqlite" // Registers the pure Go "sqlite" database/sql driver.
+)
+
+// sqlMigrations are applied in order to bring a database up to the current
+// schema; the number of applied migrations is recorded in schema_migrations.
+// Append new migrations, never edit applied ones.
+var sqlMigrations = []string{
+	`CREATE TABLE sessions (
+		id          TEXT PRIMARY KEY,
+		version     INTEGER NOT NULL,
+		state       TEXT NOT NULL,
+		total_price INTEGER NOT NULL,
+		data        TEXT NOT NULL,
+		created_at  TEXT NOT NULL,
+		updated_at  TEXT NOT NULL
+	);
+	CREATE INDEX sessions_state_updated_at ON sessions (state, updated_at);
+	CREATE TABLE line_items (
+		session_id      TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+		sku             TEXT NOT NULL,
+		quantity        INTEGER NOT NULL,
+		unit_price      INTEGER NOT NULL,
+		offers_applied  INTEGER NOT NULL,
+		line_total      INTEGER NOT NULL,
+		savings         INTEGER NOT NULL,
+		pricing_version INTEGER NOT NULL,
+		PRIMARY KEY (session_id, sku)
+	);
+	CREATE INDEX line_items_sku ON line_items (sku);`,
+	// Sessions written before prices carried a currency were priced in GBP.
+	`ALTER TABLE sessions ADD COLUMN currency TEXT NOT NULL DEFAULT 'GBP';`,
+	// Sessions written before stores were introduced are not scoped to one.
+	`ALTER TABLE sessions ADD COLUMN store TEXT NOT NULL DEFAULT '';
+	CREATE INDEX sessions_store_state ON sessions (store, state);`,
+	// Lines of SKUs sold by measure hold their measure, in thousandths of
+	// their unit, and the packs scanned at a marked price apart from the
+	// quantity, which counts units of SKUs sold each. Lines written before
+	// held the measure as their quantity; the unit and packs are read back
+	// from the serialised session, finding each SKU by key rather than in a
+	// JSON path so that SKUs holding quotes or dots are matched too.
+	`ALTER TABLE line_items ADD COLUMN unit TEXT NOT NULL DEFAULT 'each';
+	ALTER TABLE line_items ADD COLUMN measure INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE line_items ADD COLUMN packs INTEGER NOT NULL DEFAULT 0;
+	UPDATE line_items SET
+		unit = COALESCE((SELECT json_extract(locked.value, '$.rule.unit')
+			FROM sessions, json_each(sessions.data, '$.lockedRules') AS locked
+			WHERE sessions.id = line_items.session_id AND locked.key = line_items.sku), 'each'),
+		packs = COALESCE((SELECT json_array_length(marked.value)
+			FROM sessions, json_each(sessions.data, '$.markedPrices') AS marked
+			WHERE sessions.id = line_items.session_id AND marked.key = line_items.sku), 0);
+	UPDATE line_items SET measure = quantity, quantity = 0 WHERE unit <> 'each';`,
+}
+
+// SQLRepository stores sessions in an embedded SQLite database. The full
+// serialised session in sessions.data is what sessions are restored from;
+// the other columns and the line_items table are written alongside it in
+// the same transaction so that sessions can be queried for reporting.
+//
+// Each Save and Update runs in an immediate transaction, which takes
+// SQLite's write lock up front, so updates are atomic even across processes
+// sharing the database file.
+type SQLRepository struct {
+	db     *sql.DB
+	pricer checkout.PricingService
+	now    func() time.Time
+}
+
+// NewSQLRepository opens or creates the SQLite database at path, migrating it
+// to the current schema. Restored sessions are priced with pricer.
+func NewSQLRepository(path string, pricer checkout.PricingService) (*SQLRepository, error) {
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(10000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Set("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database: %w", err)
+	}
+
+	r := &SQLRepository{db: db, pricer: pricer, now: time.Now}
+	if err := r.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the database.
+func (r *SQLRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLRepository) migrate() error {
+	if _, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration: %w", err)
+		}
+		var applied int
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to read schema version: %w", err)
+		}
+		if applied >= len(sqlMigrations) {
+			return tx.Rollback()
+		}
+		if _, err := tx.Exec(sqlMigrations[applied]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", applied+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, applied+1, formatTime(r.now())); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", applied+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", applied+1, err)
+		}
+	}
+}
+
+func (r *SQLRepository) Get(id string) (domain.ICheckout, error) {
+	var data string
+	err := r.db.QueryRow(`SELECT data FROM sessions WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session '%s': %w", id, err)
+	}
+	return checkout.Restore(r.pricer, []byte(data))
+}
+
+func (r *SQLRepository) Save(co domain.ICheckout) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		var stored int
+		err := tx.QueryRow(`SELECT version FROM sessions WHERE id = ?`, co.GetID()).Scan(&stored)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return fmt.Errorf("failed to load session '%s': %w", co.GetID(), err)
+		case stored != co.GetVersion():
+			return &VersionConflictError{ID: co.GetID(), Version: co.GetVersion(), StoredVersion: stored}
+		}
+		return r.write(tx, co)
+	})
+}
+
+func (r *SQLRepository) Update(id string, fn func(co domain.ICheckout) error) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		var data string
+		err := tx.QueryRow(`SELECT data FROM sessions WHERE id = ?`, id).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load session '%s': %w", id, err)
+		}
+		co, err := checkout.Restore(r.pricer, []byte(data))
+		if err != nil {
+			return err
+		}
+		if err := fn(co); err != nil {
+			return err
+		}
+		return r.write(tx, co)
+	})
+}
+
+// inTx runs fn in a transaction, committing only if it returns nil.
+func (r *SQLRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// write bumps the session's version and upserts it with its line items. The
+// version is restored if the write fails.
+func (r *SQLRepository) write(tx *sql.Tx, co domain.ICheckout) (err error) {
+	previous := co.GetVersion()
+	co.SetVersion(previous + 1)
+	defer func() {
+		if err != nil {
+			co.SetVersion(previous)
+		}
+	}()
+
+	data, err := json.Marshal(co)
+	if err != nil {
+		return fmt.Errorf("failed to serialise session '%s': %w", co.GetID(), err)
+	}
+	breakdown, err := co.GetBreakdown()
+	if err != nil {
+		return fmt.Errorf("failed to price session '%s': %w", co.GetID(), err)
+	}
+
+	now := formatTime(r.now())
+	if _, err := tx.Exec(`INSERT INTO sessions (id, version, state, total_price, currency, store, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			version = excluded.version,
+			state = excluded.state,
+			total_price = excluded.total_price,
+			currency = excluded.currency,
+			data = excluded.data,
+			updated_at = excluded.updated_at`,
+		co.GetID(), co.GetVersion(), string(co.GetState()), breakdown.TotalPrice.Amount, breakdown.TotalPrice.Currency, co.GetStore(), string(data), now, now); err != nil {
+		return fmt.Errorf("failed to write session '%s': %w", co.GetID(), err)
+	}
+	if _, err := tx.Exec(`DELETE FROM line_items WHERE session_id = ?`, co.GetID()); err != nil {
+		return fmt.Errorf("failed to clear line items for session '%s': %w", co.GetID(), err)
+	}
+	for _, line := range breakdown.Lines {
+		quantity, unit := line.Quantity, domain.UnitEach
+		if line.Unit.Measured() {
+			quantity, unit = 0, line.Unit
+		}
+		if _, err := tx.Exec(`INSERT INTO line_items
+			(session_id, sku, quantity, unit, measure, packs, unit_price, offers_applied, line_total, savings, pricing_version)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			co.GetID(), line.SKU, quantity, string(unit), int64(line.Measure), line.Packs, line.UnitPrice.Amount, line.OffersApplied, line.LineTotal.Amount, line.Savings.Amount, line.PricingVersion); err != nil {
+			return fmt.Errorf("failed to write line item '%s' for session '%s': %w", line.SKU, co.GetID(), err)
+		}
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestSQLRepository(t *testing.T) {
+	testSessionRepository(t, func(t *testing.T) SessionRepository {
+		return openSQLRepository(t, filepath.Join(t.TempDir(), "sessions.db"))
+	})
+}
+
+func TestSQLRepositoryReporting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	repo := openSQLRepository(t, path)
+
+	co := newSession(t, &mockPricingService{})
+	if err := repo.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+	err := repo.Update(co.GetID(), func(stored domain.ICheckout) error {
+		for _, sku := range []string{"A", "A", "A", "A", "B"} {
+			if err := stored.Scan(sku); err != nil {
+				return err
+			}
+		}
+		_, err := stored.Complete()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Update() returned an unexpected error: %v", err)
+	}
+
+	var state, currency string
+	var version, totalPrice int
+	row := repo.db.QueryRow(`SELECT state, version, total_price, currency FROM sessions WHERE id = ?`, co.GetID())
+	if err := row.Scan(&state, &version, &totalPrice, &currency); err != nil {
+		t.Fatalf("Could not query sessions: %v", err)
+	}
+	if state != string(domain.StateCompleted) || version != 2 || totalPrice != 210 || currency != "GBP" {
+		t.Errorf("Expected completed session at version 2 with total 210 GBP, got %s at version %d with total %d %s", state, version, totalPrice, currency)
+	}
+
+	rows, err := repo.db.Query(`SELECT sku, quantity, offers_applied, line_total, savings FROM line_items WHERE session_id = ? ORDER BY sku`, co.GetID())
+	if err != nil {
+		t.Fatalf("Could not query line items: %v", err)
+	}
+	defer rows.Close()
+	var lines []domain.LineItem
+	for rows.Next() {
+		var line domain.LineItem
+		if err := rows.Scan(&line.SKU, &line.Quantity, &line.OffersApplied, &line.LineTotal.Amount, &line.Savings.Amount); err != nil {
+			t.Fatalf("Could not scan line item: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	expectedLines := []domain.LineItem{
+		{SKU: "A", Quantity: 4, OffersApplied: 1, LineTotal: domain.Money{Amount: 180}, Savings: domain.Money{Amount: 20}},
+		{SKU: "B", Quantity: 1, LineTotal: domain.Money{Amount: 30}},
+	}
+	if len(lines) != len(expectedLines) {
+		t.Fatalf("Expected %d line items, got %d", len(expectedLines), len(lines))
+	}
+	for i, expected := range expectedLines {
+		if lines[i] != expected {
+			t.Errorf("Line item %d: expected %+v, got %+v", i, expected, lines[i])
+		}
+	}
+}
+
+func TestSQLRepositoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	repo := openSQLRepository(t, path)
+	co := newSession(t, &mockPricingService{})
+	if err := co.Scan("A"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	if err := repo.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() returned an unexpected error: %v", err)
+	}
+
+	reopened := openSQLRepository(t, path)
+	var migrations int
+	if err := reopened.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&migrations); err != nil {
+		t.Fatalf("Could not query schema_migrations: %v", err)
+	}
+	if migrations != len(sqlMigrations) {
+		t.Errorf("Expected %d applied migrations after reopening, got %d", len(sqlMigrations), migrations)
+	}
+	restored, err := reopened.Get(co.GetID())
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	if total, _ := restored.GetTotalPrice(); total.Amount != 50 {
+		t.Errorf("Expected restored total 50, got %d", total.Amount)
+	}
+}
+
+func TestSQLRepositoryWeighedLines(t *testing.T) {
+	repo := openSQLRepository(t, filepath.Join(t.TempDir(), "sessions.db"))
+	co := newSession(t, &mockPricingService{})
+	if err := co.ScanMeasure("W", 456); err != nil {
+		t.Fatalf("ScanMeasure() returned an unexpected error: %v", err)
+	}
+	if err := co.ScanBarcode("2012345002500"); err != nil {
+		t.Fatalf("ScanBarcode() returned an unexpected error: %v", err)
+	}
+	if err := co.Scan("B"); err != nil {
+		t.Fatalf("Got unexpected error during scan: %v", err)
+	}
+	if err := repo.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+
+	expected := []weighedLine{
+		{sku: "B", quantity: 1, unit: "each", lineTotal: 30},
+		{sku: "W", unit: "kg", measure: 456, packs: 1, lineTotal: 305},
+	}
+	if lines := queryWeighedLines(t, repo, co.GetID()); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected line items %+v, got %+v", expected, lines)
+	}
+
+	restored, err := repo.Get(co.GetID())
+	if err != nil {
+		t.Fatalf("Get() returned an unexpected error: %v", err)
+	}
+	original, _ := co.GetBreakdown()
+	if breakdown, _ := restored.GetBreakdown(); !reflect.DeepEqual(breakdown.Lines, original.Lines) {
+		t.Errorf("Expected restored lines %+v, got %+v", original.Lines, breakdown.Lines)
+	}
+}
+
+func TestSQLRepositoryMigratesWeighedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	co := newSession(t, &mockPricingService{})
+	if err := co.ScanMeasure("W", 456); err != nil {
+		t.Fatalf("ScanMeasure() returned an unexpected error: %v", err)
+	}
+	if err := co.ScanBarcode("2012345002500"); err != nil {
+		t.Fatalf("ScanBarcode() returned an unexpected error: %v", err)
+	}
+	data, err := json.Marshal(co)
+	if err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+	// Weigh the same again under an SKU that cannot be named in a JSON path
+	// without quoting.
+	const awkward = `W"1.kg`
+	var session map[string]json.RawMessage
+	if err := json.Unmarshal(data, &session); err != nil {
+		t.Fatalf("Could not parse session: %v", err)
+	}
+	for _, field := range []string{"items", "markedPrices", "lockedRules"} {
+		var bySKU map[string]json.RawMessage
+		if err := json.Unmarshal(session[field], &bySKU); err != nil {
+			t.Fatalf("Could not parse %s: %v", field, err)
+		}
+		bySKU[awkward] = bySKU["W"]
+		if session[field], err = json.Marshal(bySKU); err != nil {
+			t.Fatalf("Could not serialise %s: %v", field, err)
+		}
+	}
+	if data, err = json.Marshal(session); err != nil {
+		t.Fatalf("Could not serialise session: %v", err)
+	}
+
+	// Write the session as it was before line items had a unit, with the
+	// measure as the quantity.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Could not open database: %v", err)
+	}
+	statements := append([]string{`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`}, sqlMigrations[:3]...)
+	statements = append(statements,
+		`INSERT INTO schema_migrations (version, applied_at) VALUES (1, ''), (2, ''), (3, '')`,
+		`INSERT INTO sessions (id, version, state, total_price, data, created_at, updated_at) VALUES ('`+co.GetID()+`', 1, 'open', 305, '`+string(data)+`', '', '')`,
+		`INSERT INTO line_items VALUES ('`+co.GetID()+`', 'W', 456, 120, 0, 305, 0, 1)`,
+		`INSERT INTO line_items VALUES ('`+co.GetID()+`', '`+awkward+`', 456, 120, 0, 305, 0, 1)`,
+	)
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Could not prepare database: %v", err)
+		}
+	}
+	db.Close()
+
+	repo := openSQLRepository(t, path)
+	expected := []weighedLine{
+		{sku: "W", unit: "kg", measure: 456, packs: 1, lineTotal: 305},
+		{sku: awkward, unit: "kg", measure: 456, packs: 1, lineTotal: 305},
+	}
+	if lines := queryWeighedLines(t, repo, co.GetID()); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected migrated line items %+v, got %+v", expected, lines)
+	}
+}
+
+// weighedLine is a row of line_items with the columns describing its quantity.
+type weighedLine struct {
+	sku       string
+	quantity  int
+	unit      string
+	measure   int
+	packs     int
+	lineTotal int
+}
+
+func queryWeighedLines(t *testing.T, repo *SQLRepository, id string) []weighedLine {
+	t.Helper()
+	rows, err := repo.db.Query(`SELECT sku, quantity, unit, measure, packs, line_total FROM line_items WHERE session_id = ? ORDER BY sku`, id)
+	if err != nil {
+		t.Fatalf("Could not query line items: %v", err)
+	}
+	defer rows.Close()
+	var lines []weighedLine
+	for rows.Next() {
+		var line weighedLine
+		if err := rows.Scan(&line.sku, &line.quantity, &line.unit, &line.measure, &line.packs, &line.lineTotal); err != nil {
+			t.Fatalf("Could not scan line item: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func openSQLRepository(t *testing.T, path string) *SQLRepository {
+	t.Helper()
+	repo, err := NewSQLRepository(path, &mockPricingService{})
+	if err != nil {
+		t.Fatalf("NewSQLRepository() returned an unexpected error: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// storeRepository is a view of a SessionRepository holding only the sessions
+// of one store.
+type storeRepository struct {
+	repo  SessionRepository
+	store string
+}
+
+// ForStore returns a view of repo in which only the sessions of store exist.
+// Sessions of other stores, expired or not, are reported as
+// ErrSessionNotFound, so that one store can neither read nor change
+// another's checkouts nor learn their ids. The empty store holds the
+// sessions that are not scoped to a store.
+func ForStore(repo SessionRepository, store string) SessionRepository {
+	return &storeRepository{repo: repo, store: store}
+}
+
+func (r *storeRepository) Get(id string) (domain.ICheckout, error) {
+	co, err := r.repo.Get(id)
+	if err != nil {
+		return nil, r.hide(id, err)
+	}
+	if co.GetStore() != r.store {
+		return nil, fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+	}
+	return co, nil
+}
+
+// Save stores a session of the store, refusing to store a session of any other.
+func (r *storeRepository) Save(co domain.ICheckout) error {
+	if co.GetStore() != r.store {
+		return fmt.Errorf("session with id '%s' belongs to store '%s', not '%s'", co.GetID(), co.GetStore(), r.store)
+	}
+	return r.repo.Save(co)
+}
+
+func (r *storeRepository) Update(id string, fn func(co domain.ICheckout) error) error {
+	err := r.repo.Update(id, func(co domain.ICheckout) error {
+		if co.GetStore() != r.store {
+			return fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+		}
+		return fn(co)
+	})
+	return r.hide(id, err)
+}
+
+// hide reports an expired session as not found unless it is known to have
+// belonged to the store.
+func (r *storeRepository) hide(id string, err error) error {
+	var expired *ExpiredError
+	if errors.Is(err, ErrSessionExpired) && !(errors.As(err, &expired) && expired.Store == r.store) {
+		return fmt.Errorf("session with id '%s' %w", id, ErrSessionNotFound)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/checkout"
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestForStore(t *testing.T) {
+	repo := NewInMemoryRepository()
+	manchester := ForStore(repo, "manchester")
+	leeds := ForStore(repo, "leeds")
+	unscoped := ForStore(repo, "")
+
+	co := &mockCheckout{id: "manchester-basket", store: "manchester"}
+	if err := manchester.Save(co); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+	if err := unscoped.Save(&mockCheckout{id: "unscoped-basket"}); err != nil {
+		t.Fatalf("Save() returned an unexpected error: %v", err)
+	}
+
+	if retrieved, err := manchester.Get(co.id); err != nil || retrieved.GetID() != co.id {
+		t.Errorf("Expected the store to read its own session, got %v (err %v)", retrieved, err)
+	}
+	if err := manchester.Update(co.id, func(domain.ICheckout) error { return nil }); err != nil {
+		t.Errorf("Expected the store to update its own session, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		repo SessionRepository
+		id   string
+	}{
+		"another store's session":              {repo: leeds, id: co.id},
+		"a store's session without a store":    {repo: unscoped, id: co.id},
+		"an unscoped session from a store":     {repo: manchester, id: "unscoped-basket"},
+		"a session that does not exist at all": {repo: manchester, id: "nonexistent-id"},
+	} {
+		if _, err := tc.repo.Get(tc.id); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Get() of %s: expected ErrSessionNotFound, got %v", name, err)
+		}
+		called := false
+		err := tc.repo.Update(tc.id, func(domain.ICheckout) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrSessionNotFound) || called {
+			t.Errorf("Update() of %s: expected ErrSessionNotFound without updating, got %v", name, err)
+		}
+	}
+	if co.GetVersion() != 2 {
+		t.Errorf("Expected only the store's own update to bump the version to 2, got %d", co.GetVersion())
+	}
+
+	if err := leeds.Save(&mockCheckout{id: "misplaced", store: "manchester"}); err == nil {
+		t.Error("Expected an error saving another store's session, but got nil")
+	}
+	if _, err := repo.Get("misplaced"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the refused session not to be stored, got %v", err)
+	}
+}
+
+func TestForStoreExpired(t *testing.T) {
+	// Sessions are stamped by the system clock, which the file repository
+	// ages recovered sessions from, so the clock starts from it too.
+	clock := &fakeClock{now: time.Now()}
+	memory := NewInMemoryRepository(WithIdleTTL(30*time.Minute), WithClock(clock.Now))
+	t.Cleanup(func() { memory.Close() })
+	repos := map[string]SessionRepository{
+		"in memory": memory,
+		"file":      openFileRepository(t, t.TempDir(), WithExpiry(30*time.Minute, 0, 0), WithFileClock(clock.Now)),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			co, err := checkout.New(&mockPricingService{}, checkout.WithStore("manchester"))
+			if err != nil {
+				t.Fatalf("New() returned an unexpected error: %v", err)
+			}
+			if err := repo.Save(co); err != nil {
+				t.Fatalf("Save() returned an unexpected error: %v", err)
+			}
+			clock.Advance(time.Hour)
+
+			for store, expected := range map[string]error{
+				"manchester": ErrSessionExpired,
+				"leeds":      ErrSessionNotFound,
+				"":           ErrSessionNotFound,
+			} {
+				view := ForStore(repo, store)
+				if _, err := view.Get(co.GetID()); !errors.Is(err, expected) {
+					t.Errorf("Get() from store %q: expected %v, got %v", store, expected, err)
+				}
+				if err := view.Update(co.GetID(), func(domain.ICheckout) error { return nil }); !errors.Is(err, expected) {
+					t.Errorf("Update() from store %q: expected %v, got %v", store, expected, err)
+				}
+			}
+		})
+	}
+}
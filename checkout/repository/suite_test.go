@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/checkout"
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+type mockPricingService struct{}
+
+func (m *mockPricingService) GetPriceList() domain.PriceList {
+	return domain.PriceList{
+		Version: 1,
+		Rules: map[string]domain.PricingRule{
+			"A": {UnitPrice: domain.Money{Amount: 50, Currency: "GBP"}, SpecialPrice: &domain.SpecialPrice{Quantity: 3, Price: domain.Money{Amount: 130, Currency: "GBP"}}},
+			"B": {UnitPrice: domain.Money{Amount: 30, Currency: "GBP"}},
+			"W": {UnitPrice: domain.Money{Amount: 120, Currency: "GBP"}, Unit: domain.UnitKilogram, ItemReference: "12345"},
+		},
+	}
+}
+
+// newSession creates a checkout session, failing the test if it cannot be created.
+func newSession(t *testing.T, pricer checkout.PricingService) domain.ICheckout {
+	t.Helper()
+	co, err := checkout.New(pricer)
+	if err != nil {
+		t.Fatalf("checkout.New() returned an unexpected error: %v", err)
+	}
+	return co
+}
+
+// testSessionRepository runs the behaviour every SessionRepository must share
+// against repositories made by newRepo, using real checkout sessions.
+func testSessionRepository(t *testing.T, newRepo func(t *testing.T) SessionRepository) {
+	pricer := &mockPricingService{}
+
+	newSavedSession := func(t *testing.T, repo SessionRepository, skus ...string) domain.ICheckout {
+		t.Helper()
+		co := newSession(t, pricer)
+		for _, sku := range skus {
+			if err := co.Scan(sku); err != nil {
+				t.Fatalf("Got unexpected error during scan: %v", err)
+			}
+		}
+		if err := repo.Save(co); err != nil {
+			t.Fatalf("Save() returned an unexpected error: %v", err)
+		}
+		return co
+	}
+
+	expectTotal := func(t *testing.T, repo SessionRepository, id string, expected int) {
+		t.Helper()
+		co, err := repo.Get(id)
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		total, err := co.GetTotalPrice()
+		if err != nil {
+			t.Fatalf("GetTotalPrice() returned an unexpected error: %v", err)
+		}
+		if total.Amount != int64(expected) {
+			t.Errorf("Expected total price to be %d, but got %d", expected, total.Amount)
+		}
+	}
+
+	t.Run("save and get", func(t *testing.T) {
+		repo := newRepo(t)
+		co := newSavedSession(t, repo, "A", "B")
+
+		retrieved, err := repo.Get(co.GetID())
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if retrieved.GetID() != co.GetID() {
+			t.Errorf("Expected retrieved ID to be %s, got %s", co.GetID(), retrieved.GetID())
+		}
+		if retrieved.GetVersion() != 1 {
+			t.Errorf("Expected a new session to be saved at version 1, got %d", retrieved.GetVersion())
+		}
+		expectTotal(t, repo, co.GetID(), 80)
+	})
+
+	t.Run("get a nonexistent session", func(t *testing.T) {
+		repo := newRepo(t)
+		if _, err := repo.Get("nonexistent-id"); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound, got %v", err)
+		}
+		err := repo.Update("nonexistent-id", func(domain.ICheckout) error { return nil })
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound from Update(), got %v", err)
+		}
+	})
+
+	t.Run("update keeps successful changes and bumps the version", func(t *testing.T) {
+		repo := newRepo(t)
+		co := newSavedSession(t, repo, "A")
+
+		if err := repo.Update(co.GetID(), func(stored domain.ICheckout) error { return stored.SetQuantity("A", 3) }); err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+		updateErr := errors.New("update failed")
+		if err := repo.Update(co.GetID(), func(domain.ICheckout) error { return updateErr }); !errors.Is(err, updateErr) {
+			t.Errorf("Expected the update error, got %v", err)
+		}
+
+		retrieved, err := repo.Get(co.GetID())
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		if retrieved.GetVersion() != 2 {
+			t.Errorf("Expected version 2 after one successful update, got %d", retrieved.GetVersion())
+		}
+		expectTotal(t, repo, co.GetID(), 130)
+	})
+
+	t.Run("saving a stale session is a conflict", func(t *testing.T) {
+		repo := newRepo(t)
+		co := newSavedSession(t, repo, "A")
+		data, err := json.Marshal(co)
+		if err != nil {
+			t.Fatalf("Could not serialise session: %v", err)
+		}
+		if err := repo.Update(co.GetID(), func(stored domain.ICheckout) error { return stored.Scan("B") }); err != nil {
+			t.Fatalf("Update() returned an unexpected error: %v", err)
+		}
+
+		stale, err := checkout.Restore(pricer, data)
+		if err != nil {
+			t.Fatalf("Restore() returned an unexpected error: %v", err)
+		}
+		var conflict *VersionConflictError
+		if err := repo.Save(stale); !errors.As(err, &conflict) {
+			t.Fatalf("Expected a VersionConflictError, got %v", err)
+		}
+		expectTotal(t, repo, co.GetID(), 80)
+	})
+
+	// Run with -race to check that updates and reads of one session are serialised.
+	t.Run("concurrent updates to one session", func(t *testing.T) {
+		repo := newRepo(t)
+		co := newSavedSession(t, repo)
+
+		const workers, scansPerWorker = 8, 25
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < scansPerWorker; j++ {
+					err := repo.Update(co.GetID(), func(stored domain.ICheckout) error {
+						if err := stored.Scan("A"); err != nil {
+							return err
+						}
+						if err := stored.Scan("B"); err != nil {
+							return err
+						}
+						return stored.Remove("B")
+					})
+					if err != nil {
+						t.Errorf("Update() returned an unexpected error: %v", err)
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < scansPerWorker; j++ {
+					stored, err := repo.Get(co.GetID())
+					if err != nil {
+						t.Errorf("Get() returned an unexpected error: %v", err)
+						continue
+					}
+					if _, err := stored.GetTotalPrice(); err != nil {
+						t.Errorf("GetTotalPrice() returned an unexpected error: %v", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		stored, err := repo.Get(co.GetID())
+		if err != nil {
+			t.Fatalf("Get() returned an unexpected error: %v", err)
+		}
+		breakdown, err := stored.GetBreakdown()
+		if err != nil {
+			t.Fatalf("GetBreakdown() returned an unexpected error: %v", err)
+		}
+		if len(breakdown.Lines) != 1 || breakdown.Lines[0].Quantity != workers*scansPerWorker {
+			t.Errorf("Expected a single line of %d units of A, got %+v", workers*scansPerWorker, breakdown.Lines)
+		}
+		if stored.GetVersion() != 1+workers*scansPerWorker {
+			t.Errorf("Expected version %d, got %d", 1+workers*scansPerWorker, stored.GetVersion())
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package checkout
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/coupon"
+)
+
+// SessionFormatVersion is the version of SessionData written by this package.
+// It is increased whenever the serialised form changes incompatibly, and
+// Restore migrates data written in earlier versions.
+const SessionFormatVersion = 4
+
+// SessionData is the serialised form of a checkout session, as produced by
+// json.Marshal on a session and read back by Restore. It holds everything
+// needed to rebuild the session, so that storage backends can persist it
+// without knowing how sessions are implemented.
+//
+// Data written before the format was versioned has no formatVersion and no
+// timestamps; it is read as version 1 with zero timestamps. Version 1 has no
+// currency and holds prices as bare integers; they are read as amounts in
+// domain.DefaultCurrency. Version 3 adds the store, the quantities of SKUs
+// sold by measure, in thousandths of their unit, and packs scanned at a
+// marked price; none of these can appear in data of earlier versions, which
+// is read unchanged. Version 4 writes offer and tier prices and the amounts
+// of basket discounts and coupons as Money; earlier versions hold them as
+// bare integers, which are read as amounts in the session's currency.
+type SessionData struct {
+	// FormatVersion is the SessionFormatVersion the data was written with.
+	FormatVersion int `json:"formatVersion"`
+	// ID is the unique id of the session.
+	ID string `json:"id"`
+	// Version is the version last stamped on the session by its repository.
+	Version int `json:"version"`
+	// State is the lifecycle state of the session.
+	State domain.SessionState `json:"state"`
+	// Currency is the ISO 4217 code of the currency the session charges in.
+	Currency string `json:"currency,omitempty"`
+	// Store is the id of the store the session is priced for, if it is
+	// scoped to one.
+	Store string `json:"store,omitempty"`
+	// CreatedAt is when the session was created, in UTC.
+	CreatedAt time.Time `json:"createdAt"`
+	// UpdatedAt is when the basket or state of the session last changed, in UTC.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Items maps each scanned SKU to its quantity. Every quantity is
+	// positive. The quantity of an SKU whose locked rule has a measured unit
+	// is in thousandths of the unit.
+	Items map[string]int `json:"items"`
+	// MarkedPrices maps each SKU sold by measure that has packs in the
+	// session, scanned at the price marked on them, to those prices in minor
+	// units of the session's currency. Every price is positive.
+	MarkedPrices map[string][]int64 `json:"markedPrices,omitempty"`
+	// LockedRules maps each SKU the session has locked a price for to the
+	// rule it is charged at. Every SKU in Items or MarkedPrices has a locked rule.
+	LockedRules map[string]LockedRule `json:"lockedRules"`
+	// LockedPromotions are the price list promotions, basket discounts and tax
+	// rate table the session is charged with, captured with its first locked rule.
+	LockedPromotions *LockedPromotions `json:"lockedPromotions,omitempty"`
+	// Coupons are the coupons applied to the session, in the order they were applied.
+	Coupons []domain.Coupon `json:"coupons,omitempty"`
+	// PriceLock is the name of the session's PriceLock mode, "scan" or "start".
+	PriceLock string `json:"priceLock"`
+	// StartPrices is the price list captured when the session was created, if
+	// it locks its prices on start.
+	StartPrices *domain.PriceList `json:"startPrices,omitempty"`
+	// Completion is the completion record of a completed session.
+	Completion *domain.Completion `json:"completion,omitempty"`
+}
+
+// LockedRule is the pricing rule a session charges an SKU at, with the
+// version of the price list it was captured from.
+type LockedRule struct {
+	Rule           domain.PricingRule `json:"rule"`
+	PricingVersion int                `json:"pricingVersion"`
+}
+
+// LockedPromotions are the price list promotions, basket discounts and tax
+// rate table a session is charged with, with the version of the price list
+// they were captured from.
+type LockedPromotions struct {
+	Promotions      []domain.PromotionSpec  `json:"promotions"`
+	BasketDiscounts []domain.BasketDiscount `json:"basketDiscounts,omitempty"`
+	Tax             *domain.TaxConfig       `json:"tax,omitempty"`
+	PricingVersion  int                     `json:"pricingVersion"`
+}
+
+// MarshalJSON serialises the session as SessionData.
+func (s *session) MarshalJSON() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	data := SessionData{
+		FormatVersion: SessionFormatVersion,
+		ID:            s.id,
+		Version:       s.version,
+		State:         s.state,
+		Currency:      s.currency,
+		Store:         s.store,
+		CreatedAt:     s.createdAt,
+		UpdatedAt:     s.updatedAt,
+		Items:         s.scannedItems,
+		MarkedPrices:  s.markedPrices,
+		LockedRules:   make(map[string]LockedRule, len(s.lockedRules)),
+		PriceLock:     s.priceLock.String(),
+		StartPrices:   s.startPrices,
+		Coupons:       s.coupons,
+		Completion:    s.completion,
+	}
+	for sku, locked := range s.lockedRules {
+		data.LockedRules[sku] = LockedRule{Rule: locked.rule, PricingVersion: locked.version}
+	}
+	if s.promotions != nil {
+		data.LockedPromotions = &LockedPromotions{
+			Promotions:      s.promotions.specs,
+			BasketDiscounts: s.promotions.basketDiscounts,
+			Tax:             s.promotions.tax,
+			PricingVersion:  s.promotions.version,
+		}
+	}
+	return json.Marshal(data)
+}
+
+// Restore rebuilds a session from its JSON serialisation. See RestoreData.
+func Restore(pricer PricingService, data []byte, opts ...Option) (domain.ICheckout, error) {
+	var stored SessionData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse session: %w", err)
+	}
+	return RestoreData(pricer, stored, opts...)
+}
+
+// RestoreData rebuilds a session from its serialised form, pricing any SKUs
+// scanned from now on with pricer. The price lock mode, currency and store are
+// taken from data, overriding any WithPriceLock, WithCurrency or WithStore
+// option.
+func RestoreData(pricer PricingService, data SessionData, opts ...Option) (domain.ICheckout, error) {
+	if data.FormatVersion > SessionFormatVersion {
+		return nil, fmt.Errorf("failed to restore session '%s': %w: version %d is newer than %d", data.ID, ErrUnsupportedFormat, data.FormatVersion, SessionFormatVersion)
+	}
+	if data.ID == "" {
+		return nil, fmt.Errorf("failed to restore session: missing id")
+	}
+	switch data.State {
+	case domain.StateOpen, domain.StateCompleted, domain.StateCancelled:
+	default:
+		return nil, fmt.Errorf("failed to restore session '%s': unknown state '%s'", data.ID, data.State)
+	}
+	priceLock, err := ParsePriceLock(data.PriceLock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore session '%s': %w", data.ID, err)
+	}
+	currency := data.Currency
+	if currency == "" {
+		currency = domain.DefaultCurrency
+	}
+	if err := domain.ValidateCurrency(currency); err != nil {
+		return nil, fmt.Errorf("failed to restore session '%s': %w", data.ID, err)
+	}
+
+	s := &session{
+		id:           data.ID,
+		version:      data.Version,
+		state:        data.State,
+		completion:   data.Completion,
+		createdAt:    data.CreatedAt,
+		updatedAt:    data.UpdatedAt,
+		scannedItems: make(map[string]int, len(data.Items)),
+		markedPrices: make(map[string][]int64, len(data.MarkedPrices)),
+		lockedRules:  make(map[string]lockedRule, len(data.LockedRules)),
+		startPrices:  data.StartPrices,
+		pricer:       pricer,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.priceLock = priceLock
+	s.currency = currency
+	s.store = data.Store
+	for sku, locked := range data.LockedRules {
+		rule, err := locked.Rule.In(currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore session '%s': sku '%s' %w, session is in %s", data.ID, sku, err, currency)
+		}
+		s.lockedRules[sku] = lockedRule{rule: rule, version: locked.PricingVersion}
+	}
+	if s.completion != nil {
+		if err := completionIn(s.completion, currency); err != nil {
+			return nil, fmt.Errorf("failed to restore session '%s': %w, session is in %s", data.ID, err, currency)
+		}
+	}
+	if data.LockedPromotions != nil {
+		locked := data.LockedPromotions
+		s.promotions, err = newLockedPromotions(locked.Promotions, locked.BasketDiscounts, locked.Tax, locked.PricingVersion, currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore session '%s': %w", data.ID, err)
+		}
+	}
+	if err := coupon.Validate(data.Coupons); err != nil {
+		return nil, fmt.Errorf("failed to restore session '%s': %w", data.ID, err)
+	}
+	for i, applied := range data.Coupons {
+		if data.Coupons[i], err = applied.In(currency); err != nil {
+			return nil, fmt.Errorf("failed to restore session '%s': coupon '%s' %w, session is in %s", data.ID, applied.Code, err, currency)
+		}
+	}
+	s.coupons = data.Coupons
+	for sku, count := range data.Items {
+		if count <= 0 {
+			return nil, fmt.Errorf("failed to restore session '%s': sku '%s' has quantity %d", data.ID, sku, count)
+		}
+		if _, locked := s.lockedRules[sku]; !locked {
+			return nil, fmt.Errorf("failed to restore session '%s': sku '%s' has no locked pricing rule", data.ID, sku)
+		}
+		s.scannedItems[sku] = count
+	}
+	for sku, prices := range data.MarkedPrices {
+		if _, locked := s.lockedRules[sku]; !locked {
+			return nil, fmt.Errorf("failed to restore session '%s': sku '%s' has no locked pricing rule", data.ID, sku)
+		}
+		for _, price := range prices {
+			if price <= 0 {
+				return nil, fmt.Errorf("failed to restore session '%s': sku '%s' has a pack marked %d", data.ID, sku, price)
+			}
+		}
+		if len(prices) > 0 {
+			s.markedPrices[sku] = prices
+		}
+	}
+	return s, nil
+}
+
+// completionIn gives every amount in a completion with no currency the
+// currency of its session, returning domain.ErrCurrencyMismatch if any is
+// in another.
+func completionIn(completion *domain.Completion, currency string) (err error) {
+	if completion.FinalTotal, err = completion.FinalTotal.In(currency); err != nil {
+		return err
+	}
+	for sku, rule := range completion.Pricing {
+		if completion.Pricing[sku], err = rule.In(currency); err != nil {
+			return fmt.Errorf("sku '%s' %w", sku, err)
+		}
+	}
+	for i, discount := range completion.BasketDiscounts {
+		if completion.BasketDiscounts[i], err = discount.In(currency); err != nil {
+			return fmt.Errorf("basket discount '%s' %w", discount.ID, err)
+		}
+	}
+	for i, applied := range completion.Coupons {
+		if completion.Coupons[i], err = applied.In(currency); err != nil {
+			return fmt.Errorf("coupon '%s' %w", applied.Code, err)
+		}
+	}
+	return nil
+}
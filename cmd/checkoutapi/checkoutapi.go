@@ -1,30 +1,121 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/TheFodfather/checkoutapi/pricing/coupon"
 	pricingSvc "github.com/TheFodfather/checkoutapi/pricing/service"
 
+	"github.com/TheFodfather/checkoutapi/checkout"
 	"github.com/TheFodfather/checkoutapi/checkout/handler"
 	"github.com/TheFodfather/checkoutapi/checkout/repository"
 )
 
 func main() {
+	priceLockName := flag.String("price-lock", "scan", "when sessions lock their prices: 'scan' (each SKU when first scanned) or 'start' (all prices when the session is created)")
+	idleTTL := flag.Duration("session-idle-ttl", 30*time.Minute, "evict sessions that have not been used for this long (0 disables)")
+	maxAge := flag.Duration("session-max-age", 12*time.Hour, "evict sessions created more than this long ago (0 disables)")
+	maxSessions := flag.Int("max-sessions", 100000, "maximum number of sessions held, evicting the least recently used (0 disables)")
+	store := flag.String("store", "memory", "where sessions are stored: 'memory', 'file' or 'sqlite'")
+	dataDir := flag.String("data-dir", "./data", "directory holding the session log and snapshot when -store=file")
+	sqlitePath := flag.String("sqlite-path", "./data/sessions.db", "SQLite database holding sessions when -store=sqlite")
+	fsyncName := flag.String("fsync", "always", "when the session log is fsynced when -store=file: 'always', 'interval' or 'never'")
+	fsyncInterval := flag.Duration("fsync-interval", time.Second, "how often the session log is fsynced when -fsync=interval")
+	flag.Parse()
+
+	priceLock, err := checkout.ParsePriceLock(*priceLockName)
+	if err != nil {
+		log.Fatalf("❌ Invalid configuration - err=%q", err)
+	}
+
 	pricer, err := pricingSvc.New("./cmd/configs/pricing.json")
 	if err != nil {
 		log.Fatalf("❌ Could not start pricing service - err=%q", err)
 	}
 
-	repo := repository.NewInMemoryRepository()
-	httpHandler := handler.New(repo, pricer)
+	coupons, err := coupon.Load("./cmd/configs/coupons.json")
+	if err != nil {
+		log.Fatalf("❌ Could not load coupons - err=%q", err)
+	}
+
+	var repo interface {
+		repository.SessionRepository
+		Close() error
+	}
+	switch *store {
+	case "memory":
+		repo = repository.NewInMemoryRepository(
+			repository.WithIdleTTL(*idleTTL),
+			repository.WithMaxAge(*maxAge),
+			repository.WithMaxSessions(*maxSessions),
+		)
+	case "file":
+		fsync, err := repository.ParseSyncPolicy(*fsyncName)
+		if err != nil {
+			log.Fatalf("❌ Invalid configuration - err=%q", err)
+		}
+		repo, err = repository.NewFileRepository(*dataDir, pricer,
+			repository.WithSyncPolicy(fsync, *fsyncInterval),
+			repository.WithExpiry(*idleTTL, *maxAge, *maxSessions),
+		)
+		if err != nil {
+			log.Fatalf("❌ Could not open session store - err=%q", err)
+		}
+	case "sqlite":
+		repo, err = repository.NewSQLRepository(*sqlitePath, pricer)
+		if err != nil {
+			log.Fatalf("❌ Could not open session store - err=%q", err)
+		}
+	default:
+		log.Fatalf("❌ Invalid configuration - err=%q", "unknown store '"+*store+"', expected 'memory', 'file' or 'sqlite'")
+	}
+	httpHandler := handler.New(repo, pricer, coupons, checkout.WithPriceLock(priceLock))
 
 	mux := http.NewServeMux()
 	httpHandler.RegisterRoutes(mux)
 
+	// The store is closed before exiting, as log.Fatalf would skip deferred calls.
+	err = serve(mux)
+	if closeErr := repo.Close(); closeErr != nil {
+		log.Printf("⚠️ Session store did not close cleanly - err=%q", closeErr)
+	}
+	if err != nil {
+		log.Fatalf("❌ Could not start server - err=%q", err)
+	}
+}
+
+// serve listens on port 8080 until the process is interrupted or terminated,
+// then shuts the server down gracefully.
+func serve(mux http.Handler) error {
 	port := "8080"
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("🛑 Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("⚠️ Server did not shut down cleanly - err=%q", err)
+		}
+	}()
+
 	log.Printf("🚀 Starting server on http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatalf("❌ Could not start server - err=%q", err)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	<-shutdownDone
+	return nil
 }
@@ -1,20 +1,212 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnknownStore is returned for a store there is no pricing configured for.
+var ErrUnknownStore = errors.New("is not configured")
+
 // ICheckout is the core interface defining the contract for a checkout
 type ICheckout interface {
 	Scan(SKU string) (err error)
-	GetTotalPrice() (totalPrice int, err error)
+	ScanMeasure(SKU string, measure Measure) (err error)
+	ScanBarcode(code string) (err error)
+	Remove(SKU string) (err error)
+	Void(SKU string) (err error)
+	SetQuantity(SKU string, quantity int) (err error)
+	ApplyCoupon(coupon Coupon) (err error)
+	RemoveCoupon(code string) (err error)
+	GetCoupons() []Coupon
+	GetTotalPrice() (totalPrice Money, err error)
+	GetBreakdown() (breakdown Breakdown, err error)
 	GetID() string
+	GetVersion() int
+	SetVersion(version int)
+	GetState() SessionState
+	Complete() (completion Completion, err error)
+	Cancel() (err error)
+	GetCompletion() *Completion
+	GetCurrency() string
+	GetStore() string
+}
+
+// SessionState is the lifecycle state of a checkout session. Sessions start
+// open and move once to either completed or cancelled.
+type SessionState string
+
+const (
+	StateOpen      SessionState = "open"
+	StateCompleted SessionState = "completed"
+	StateCancelled SessionState = "cancelled"
+)
+
+// Completion records the final total of a completed checkout and the pricing
+// rules, for each SKU in the basket, promotions, basket discounts, coupons
+// and tax rate table that it was priced with.
+type Completion struct {
+	CompletedAt     time.Time              `json:"completedAt"`
+	FinalTotal      Money                  `json:"finalTotal"`
+	Pricing         map[string]PricingRule `json:"pricing"`
+	Promotions      []PromotionSpec        `json:"promotions,omitempty"`
+	BasketDiscounts []BasketDiscount       `json:"basketDiscounts,omitempty"`
+	Coupons         []Coupon               `json:"coupons,omitempty"`
+	Tax             *TaxConfig             `json:"tax,omitempty"`
 }
 
-// PricingRule defines the pricing structure for a single SKU.
+// PricingRule defines the pricing structure for a single SKU. Its offers are
+// priced in the currency of its unit price. When it has a
+// Schedule, its offers only apply while the schedule is active; the unit
+// price always applies. TaxCategory names the rate the SKU is taxed at in
+// the price list's tax table, if it has one. An SKU sold in a measured Unit
+// is priced at its unit price per kilogram or litre, with the price of each
+// measured quantity rounded as Rounding says; it has no offers. Barcodes
+// lists the EAN-8, UPC-A and EAN-13 barcodes that scan as the SKU, and an
+// SKU sold by measure may have the ItemReference that in-store
+// variable-measure barcodes identify it by.
 type PricingRule struct {
-	UnitPrice    int           `json:"unitPrice"`
-	SpecialPrice *SpecialPrice `json:"specialPrice"`
+	UnitPrice     Money           `json:"unitPrice"`
+	SpecialPrice  *SpecialPrice   `json:"specialPrice"`
+	BuyGet        *BuyGet         `json:"buyGet,omitempty"`
+	Tiers         *TieredPrice    `json:"tiers,omitempty"`
+	Schedule      *Schedule       `json:"schedule,omitempty"`
+	TaxCategory   string          `json:"taxCategory,omitempty"`
+	Unit          Unit            `json:"unit,omitempty"`
+	Rounding      MeasureRounding `json:"rounding,omitempty"`
+	Barcodes      []string        `json:"barcodes,omitempty"`
+	ItemReference string          `json:"itemReference,omitempty"`
+}
+
+// In returns the rule with every amount in currency, giving the currency to
+// amounts that have none. It returns ErrCurrencyMismatch if any amount is in
+// another currency.
+func (r PricingRule) In(currency string) (rule PricingRule, err error) {
+	if r.UnitPrice, err = r.UnitPrice.In(currency); err != nil {
+		return PricingRule{}, err
+	}
+	if r.SpecialPrice != nil {
+		special := *r.SpecialPrice
+		if special.Price, err = special.Price.In(currency); err != nil {
+			return PricingRule{}, err
+		}
+		r.SpecialPrice = &special
+	}
+	if r.Tiers != nil {
+		tiers := TieredPrice{Mode: r.Tiers.Mode, Tiers: append([]PriceTier(nil), r.Tiers.Tiers...)}
+		for i := range tiers.Tiers {
+			if tiers.Tiers[i].UnitPrice, err = tiers.Tiers[i].UnitPrice.In(currency); err != nil {
+				return PricingRule{}, err
+			}
+		}
+		r.Tiers = &tiers
+	}
+	return r, nil
+}
+
+// PriceList is a versioned snapshot of the pricing rules for every SKU, the
+// promotions and basket discounts on offer and the tax rate table, if tax is
+// charged. Every price in it is in Currency. The version increases each time
+// the rules are reloaded.
+type PriceList struct {
+	Version         int                    `json:"version"`
+	Currency        string                 `json:"currency"`
+	Rules           map[string]PricingRule `json:"rules"`
+	Promotions      []PromotionSpec        `json:"promotions,omitempty"`
+	BasketDiscounts []BasketDiscount       `json:"basketDiscounts,omitempty"`
+	Tax             *TaxConfig             `json:"tax,omitempty"`
+}
+
+// PriceChange is a change to the price list planned ahead, taking effect at
+// EffectiveFrom. Its Rules are set over those of the price list, a null rule
+// withdrawing its SKU, and its Promotions, BasketDiscounts and Tax replace
+// those of the price list when they are given. Currency names the price list
+// the change is made to, when there are price lists in several currencies.
+type PriceChange struct {
+	EffectiveFrom   time.Time               `json:"effectiveFrom"`
+	Currency        string                  `json:"currency,omitempty"`
+	Rules           map[string]*PricingRule `json:"rules,omitempty"`
+	Promotions      *[]PromotionSpec        `json:"promotions,omitempty"`
+	BasketDiscounts *[]BasketDiscount       `json:"basketDiscounts,omitempty"`
+	Tax             *TaxConfig              `json:"tax,omitempty"`
 }
 
-// SpecialPrice defines a multi-buy promotion.
+// SpecialPrice defines a multi-buy promotion on a single SKU.
 type SpecialPrice struct {
-	Quantity int `json:"quantity"`
-	Price    int `json:"price"`
+	Quantity int   `json:"quantity"`
+	Price    Money `json:"price"`
+}
+
+// BuyGet defines a buy-X-get-Y promotion on a single SKU: for every Buy units
+// of the SKU, up to Get units of GetSKU are discounted by PercentOff percent.
+// GetSKU defaults to the SKU itself, so {Buy: 1, Get: 1, PercentOff: 100} is
+// buy one get one free and {Buy: 1, Get: 1, PercentOff: 50} is second half price.
+type BuyGet struct {
+	Buy        int    `json:"buy"`
+	Get        int    `json:"get"`
+	GetSKU     string `json:"getSku,omitempty"`
+	PercentOff int    `json:"percentOff"`
+}
+
+// TierMode is how a tiered price charges the units of an SKU.
+type TierMode string
+
+const (
+	// TierAllUnits charges every unit at the price of the highest tier the
+	// quantity reaches.
+	TierAllUnits TierMode = "all-units"
+	// TierGraduated charges each unit at the price of the tier it falls in,
+	// so only the units past a tier's minimum quantity get its price.
+	TierGraduated TierMode = "graduated"
+)
+
+// TieredPrice lowers the unit price of an SKU as its quantity increases.
+// Quantities below the first tier are charged the rule's unit price.
+type TieredPrice struct {
+	Mode  TierMode    `json:"mode"`
+	Tiers []PriceTier `json:"tiers"`
+}
+
+// PriceTier is the unit price charged from MinQuantity units upwards.
+type PriceTier struct {
+	MinQuantity int   `json:"minQuantity"`
+	UnitPrice   Money `json:"unitPrice"`
+}
+
+// LineItem is the priced summary of every unit of a single SKU in a checkout.
+// OffersApplied counts the promotion applications that discounted units of
+// the SKU. PricingVersion is the version of the price list its rule was taken
+// from, and TaxCategory the category it is taxed in when tax is charged. For
+// an SKU sold by measure, Unit is its unit and Measure the quantity scanned,
+// which Quantity gives in thousandths of the unit, and Packs the number of
+// packs of it scanned at the price marked on them, which LineTotal includes.
+type LineItem struct {
+	SKU            string  `json:"sku"`
+	Quantity       int     `json:"quantity"`
+	UnitPrice      Money   `json:"unitPrice"`
+	OffersApplied  int     `json:"offersApplied"`
+	LineTotal      Money   `json:"lineTotal"`
+	Savings        Money   `json:"savings"`
+	PricingVersion int     `json:"pricingVersion"`
+	TaxCategory    string  `json:"taxCategory,omitempty"`
+	Unit           Unit    `json:"unit,omitempty"`
+	Measure        Measure `json:"measure,omitempty"`
+	Packs          int     `json:"packs,omitempty"`
+}
+
+// Breakdown is an itemised view of a checkout's total, with one line per SKU
+// and the promotions applied to it. Subtotal is the sum of the line totals,
+// from which the basket discount and coupons are taken to give TotalPrice.
+// TotalSavings includes both line savings and those discounts. When tax is
+// charged, Tax reports it and TotalPrice is its gross amount, which for
+// tax-exclusive prices includes the tax added to them. Every amount is in the
+// currency of TotalPrice.
+type Breakdown struct {
+	Lines        []LineItem         `json:"lines"`
+	Promotions   []AppliedPromotion `json:"promotions"`
+	Subtotal     Money              `json:"subtotal"`
+	Discounts    []DiscountLine     `json:"discounts"`
+	Tax          *TaxSummary        `json:"tax,omitempty"`
+	TotalSavings Money              `json:"totalSavings"`
+	TotalPrice   Money              `json:"totalPrice"`
 }
@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unit is the unit of measure an SKU is sold in.
+type Unit string
+
+const (
+	// UnitEach SKUs are counted, one unit per scan. Rules without a unit are
+	// sold each.
+	UnitEach Unit = "each"
+	// UnitKilogram SKUs are weighed and priced per kilogram.
+	UnitKilogram Unit = "kg"
+	// UnitLitre SKUs are measured by volume and priced per litre.
+	UnitLitre Unit = "litre"
+)
+
+// Measured reports whether SKUs sold in the unit are scanned with a measured
+// quantity rather than counted.
+func (u Unit) Measured() bool {
+	return u == UnitKilogram || u == UnitLitre
+}
+
+// MeasureRounding is how the price of a measured quantity is rounded to the
+// smallest currency unit.
+type MeasureRounding string
+
+const (
+	// RoundHalfUp rounds to the nearest unit, halves upwards. Rules without a
+	// rounding round half up.
+	RoundHalfUp MeasureRounding = "half-up"
+	// RoundDown rounds down, in the customer's favour.
+	RoundDown MeasureRounding = "down"
+	// RoundUp rounds up.
+	RoundUp MeasureRounding = "up"
+)
+
+// MeasureScale is the number of Measure increments in one unit of measure.
+const MeasureScale = 1000
+
+// Measure is a measured quantity in thousandths of its unit, such as grams of
+// an SKU sold by the kilogram. It is written in JSON as a decimal number of
+// the unit with at most three decimal places, so 0.25 is 250 grams.
+type Measure int64
+
+// ParseMeasure reads a decimal number of units with at most three decimal
+// places, such as "1.234", as a Measure.
+func ParseMeasure(text string) (Measure, error) {
+	whole, fraction, _ := strings.Cut(text, ".")
+	if whole == "" || strings.TrimLeft(whole, "0123456789") != "" || strings.TrimLeft(fraction, "0123456789") != "" ||
+		len(fraction) > 3 || (strings.Contains(text, ".") && fraction == "") {
+		return 0, fmt.Errorf("invalid measure %s: expected a decimal number with at most 3 decimal places", text)
+	}
+	thousandths, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", 3-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid measure %s: %w", text, ErrOverflow)
+	}
+	return Measure(thousandths), nil
+}
+
+// String formats the measure as a decimal number of units, as "0.25".
+func (m Measure) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(absAmount(int64(m)), 10)
+	if len(digits) <= 3 {
+		digits = strings.Repeat("0", 4-len(digits)) + digits
+	}
+	whole, fraction := digits[:len(digits)-3], strings.TrimRight(digits[len(digits)-3:], "0")
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// MarshalJSON writes the measure as a decimal number of units.
+func (m Measure) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads the measure from a decimal number of units.
+func (m *Measure) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseMeasure(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Price returns the price of the measure at a price per unit, rounded to the
+// smallest currency unit as rounding says, or ErrOverflow if it is too large
+// to represent. The measure and price must not be negative.
+func (m Measure) Price(perUnit Money, rounding MeasureRounding) (Money, error) {
+	exact, err := perUnit.Mul(int64(m))
+	if err != nil {
+		return Money{}, err
+	}
+	price, remainder := exact.Amount/MeasureScale, exact.Amount%MeasureScale
+	switch rounding {
+	case RoundDown:
+	case RoundUp:
+		if remainder > 0 {
+			price++
+		}
+	default:
+		if remainder*2 >= MeasureScale {
+			price++
+		}
+	}
+	return Money{Amount: price, Currency: perUnit.Currency}, nil
+}
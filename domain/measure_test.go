@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMeasure(t *testing.T) {
+	for _, tc := range []struct {
+		text     string
+		expected Measure
+		invalid  bool
+	}{
+		{text: "1.234", expected: 1234},
+		{text: "0.25", expected: 250},
+		{text: "2", expected: 2000},
+		{text: "0.005", expected: 5},
+		{text: "0", expected: 0},
+		{text: "1.2345", invalid: true},
+		{text: "-1", invalid: true},
+		{text: "1e3", invalid: true},
+		{text: ".5", invalid: true},
+		{text: "1.", invalid: true},
+		{text: "", invalid: true},
+		{text: "99999999999999999999", invalid: true},
+	} {
+		got, err := ParseMeasure(tc.text)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("ParseMeasure(%q): expected an error, got %d", tc.text, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("ParseMeasure(%q): expected %d, got %d (err %v)", tc.text, tc.expected, got, err)
+		}
+	}
+}
+
+func TestMeasureJSON(t *testing.T) {
+	for _, tc := range []struct {
+		measure  Measure
+		expected string
+	}{
+		{measure: 1234, expected: "1.234"},
+		{measure: 250, expected: "0.25"},
+		{measure: 5, expected: "0.005"},
+		{measure: 3000, expected: "3"},
+	} {
+		data, err := json.Marshal(tc.measure)
+		if err != nil || string(data) != tc.expected {
+			t.Errorf("Expected %d to be written as %s, got %s (err %v)", tc.measure, tc.expected, data, err)
+		}
+		var read Measure
+		if err := json.Unmarshal(data, &read); err != nil || read != tc.measure {
+			t.Errorf("Expected %s to be read as %d, got %d (err %v)", data, tc.measure, read, err)
+		}
+	}
+
+	var body struct {
+		Weight Measure `json:"weight"`
+	}
+	if err := json.Unmarshal([]byte(`{"weight": 0.0001}`), &body); err == nil {
+		t.Error("Expected an error reading a weight more precise than a thousandth, but got nil")
+	}
+}
+
+func TestMeasurePrice(t *testing.T) {
+	perKilo := Money{Amount: 120, Currency: "GBP"}
+
+	for _, tc := range []struct {
+		name     string
+		measure  Measure
+		rounding MeasureRounding
+		expected int64
+	}{
+		{name: "half up rounds a fraction over half up", measure: 456, rounding: RoundHalfUp, expected: 55},
+		{name: "half up leaves an exact price", measure: 125, rounding: RoundHalfUp, expected: 15},
+		{name: "half up rounds a fraction under half down", measure: 454, rounding: RoundHalfUp, expected: 54},
+		{name: "no rounding is half up", measure: 456, expected: 55},
+		{name: "down", measure: 456, rounding: RoundDown, expected: 54},
+		{name: "up", measure: 451, rounding: RoundUp, expected: 55},
+		{name: "up leaves an exact price", measure: 500, rounding: RoundUp, expected: 60},
+	} {
+		got, err := tc.measure.Price(perKilo, tc.rounding)
+		if err != nil {
+			t.Fatalf("%s: Price() returned an unexpected error: %v", tc.name, err)
+		}
+		if got != (Money{Amount: tc.expected, Currency: "GBP"}) {
+			t.Errorf("%s: expected %d GBP, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	if got, _ := Measure(5).Price(Money{Amount: 100, Currency: "GBP"}, RoundHalfUp); got.Amount != 1 {
+		t.Errorf("Expected half a penny to round up to 1, got %v", got)
+	}
+	if _, err := Measure(math.MaxInt64/100).Price(perKilo, RoundHalfUp); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Expected error %v, got %v", ErrOverflow, err)
+	}
+}
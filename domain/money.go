@@ -0,0 +1,187 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of price lists that do not name one.
+const DefaultCurrency = "GBP"
+
+var (
+	// ErrUnknownCurrency is returned for a code that is not an ISO 4217
+	// currency the checkout prices in.
+	ErrUnknownCurrency = errors.New("is not a known ISO 4217 currency")
+	// ErrCurrencyMismatch is returned when amounts in different currencies
+	// are combined.
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	// ErrOverflow is returned when an amount is too large to be represented.
+	ErrOverflow = errors.New("amount is too large")
+	// ErrCurrencyNotPriced is returned for a currency there is no price list in.
+	ErrCurrencyNotPriced = errors.New("has no price list")
+)
+
+// minorUnits is the number of decimal places of the minor unit of each
+// currency the checkout prices in, by ISO 4217 code.
+var minorUnits = map[string]int{
+	"AED": 2, "AUD": 2, "BGN": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0,
+	"CNY": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2,
+	"IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3,
+	"MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "RON": 2,
+	"SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TRY": 2, "TWD": 2, "UAH": 2, "USD": 2,
+	"VND": 0, "ZAR": 2,
+}
+
+// ValidateCurrency checks that code is an ISO 4217 currency code the
+// checkout prices in, given in upper case.
+func ValidateCurrency(code string) error {
+	if _, ok := minorUnits[code]; !ok {
+		return fmt.Errorf("currency '%s' %w", code, ErrUnknownCurrency)
+	}
+	return nil
+}
+
+// Money is an amount in the minor units of an ISO 4217 currency, such as
+// pence for GBP. Its arithmetic fails rather than mixing currencies or
+// overflowing. An amount with no currency takes that of the price list or
+// session it belongs to, and is written in JSON as a bare integer in older
+// pricing files and sessions.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// IsZero reports whether the amount is zero, in any currency, so that zero
+// amounts are left out of JSON where a field is tagged omitzero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns the sum of two amounts in the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, fmt.Errorf("%w: %s + %s", ErrOverflow, m, other)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns other taken from m, both in the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	if err := m.sameCurrency(other); err != nil {
+		return Money{}, err
+	}
+	if (other.Amount < 0 && m.Amount > math.MaxInt64+other.Amount) ||
+		(other.Amount > 0 && m.Amount < math.MinInt64+other.Amount) {
+		return Money{}, fmt.Errorf("%w: %s - %s", ErrOverflow, m, other)
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns the amount multiplied by n, as for n units at a unit price.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, fmt.Errorf("%w: %s x %d", ErrOverflow, m, n)
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// MulDiv returns the amount multiplied by n and divided by d, rounded toward
+// zero, with the remainder of the division, as for a percentage or a share of
+// the amount. The product is worked out in full, so only a result too large
+// to represent overflows. It panics if d is not positive.
+func (m Money) MulDiv(n, d int64) (Money, int64, error) {
+	if d <= 0 {
+		panic("domain: MulDiv by a divisor that is not positive")
+	}
+	hi, lo := bits.Mul64(absAmount(m.Amount), absAmount(n))
+	negative := (m.Amount < 0) != (n < 0)
+	limit := uint64(math.MaxInt64)
+	if negative {
+		limit++
+	}
+	if hi >= uint64(d) {
+		return Money{}, 0, fmt.Errorf("%w: %s x %d / %d", ErrOverflow, m, n, d)
+	}
+	quotient, remainder := bits.Div64(hi, lo, uint64(d))
+	if quotient > limit {
+		return Money{}, 0, fmt.Errorf("%w: %s x %d / %d", ErrOverflow, m, n, d)
+	}
+	if negative {
+		return Money{Amount: -int64(quotient), Currency: m.Currency}, -int64(remainder), nil
+	}
+	return Money{Amount: int64(quotient), Currency: m.Currency}, int64(remainder), nil
+}
+
+// In returns the amount in currency, giving it the currency if it has none,
+// as amounts in a price list or session take its currency. It returns
+// ErrCurrencyMismatch if the amount is in another currency.
+func (m Money) In(currency string) (Money, error) {
+	if m.Currency == "" {
+		m.Currency = currency
+	}
+	if m.Currency != currency {
+		return Money{}, fmt.Errorf("%w: priced in %s", ErrCurrencyMismatch, m.Currency)
+	}
+	return m, nil
+}
+
+func (m Money) sameCurrency(other Money) error {
+	if m.Currency != other.Currency {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return nil
+}
+
+// String formats the amount in major units followed by its currency, as
+// "1.95 GBP".
+func (m Money) String() string {
+	places := minorUnits[m.Currency]
+	digits := strconv.FormatUint(absAmount(m.Amount), 10)
+	if places > 0 {
+		if len(digits) <= places {
+			digits = strings.Repeat("0", places-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+	}
+	if m.Amount < 0 {
+		digits = "-" + digits
+	}
+	return strings.TrimSpace(digits + " " + m.Currency)
+}
+
+func absAmount(amount int64) uint64 {
+	if amount < 0 {
+		return uint64(-(amount + 1)) + 1
+	}
+	return uint64(amount)
+}
+
+// UnmarshalJSON reads an amount as an object with its currency or, as older
+// pricing files and sessions hold it, as a bare integer with no currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != 'n' {
+		amount, err := strconv.ParseInt(string(trimmed), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s: expected an integer number of minor units", trimmed)
+		}
+		*m = Money{Amount: amount}
+		return nil
+	}
+	type plain Money
+	return json.Unmarshal(data, (*plain)(m))
+}
@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	gbp := func(amount int64) Money { return Money{Amount: amount, Currency: "GBP"} }
+
+	testCases := []struct {
+		name        string
+		calculate   func() (Money, error)
+		expected    Money
+		expectedErr error
+	}{
+		{name: "Add", calculate: func() (Money, error) { return gbp(150).Add(gbp(45)) }, expected: gbp(195)},
+		{name: "Sub", calculate: func() (Money, error) { return gbp(150).Sub(gbp(200)) }, expected: gbp(-50)},
+		{name: "Mul", calculate: func() (Money, error) { return gbp(50).Mul(3) }, expected: gbp(150)},
+		{name: "Mul by zero", calculate: func() (Money, error) { return gbp(math.MaxInt64).Mul(0) }, expected: gbp(0)},
+		{name: "Add in another currency", calculate: func() (Money, error) {
+			return gbp(1).Add(Money{Amount: 1, Currency: "EUR"})
+		}, expectedErr: ErrCurrencyMismatch},
+		{name: "Add past the largest amount", calculate: func() (Money, error) { return gbp(math.MaxInt64).Add(gbp(1)) }, expectedErr: ErrOverflow},
+		{name: "Sub past the smallest amount", calculate: func() (Money, error) { return gbp(math.MinInt64).Sub(gbp(1)) }, expectedErr: ErrOverflow},
+		{name: "Sub a negative past the largest amount", calculate: func() (Money, error) { return gbp(math.MaxInt64).Sub(gbp(-1)) }, expectedErr: ErrOverflow},
+		{name: "Mul past the largest amount", calculate: func() (Money, error) { return gbp(50).Mul(math.MaxInt64 / 10) }, expectedErr: ErrOverflow},
+		{name: "Mul the smallest amount by -1", calculate: func() (Money, error) { return gbp(math.MinInt64).Mul(-1) }, expectedErr: ErrOverflow},
+		{name: "MulDiv", calculate: func() (Money, error) { return withoutRemainder(gbp(199).MulDiv(15, 100)) }, expected: gbp(29)},
+		{name: "MulDiv a negative amount", calculate: func() (Money, error) { return withoutRemainder(gbp(-199).MulDiv(15, 100)) }, expected: gbp(-29)},
+		{name: "MulDiv past the largest product", calculate: func() (Money, error) {
+			return withoutRemainder(gbp(math.MaxInt64/2).MulDiv(50, 100))
+		}, expected: gbp(math.MaxInt64 / 4)},
+		{name: "MulDiv to the smallest amount", calculate: func() (Money, error) { return withoutRemainder(gbp(math.MinInt64).MulDiv(3, 3)) }, expected: gbp(math.MinInt64)},
+		{name: "MulDiv past the largest amount", calculate: func() (Money, error) { return withoutRemainder(gbp(math.MaxInt64).MulDiv(3, 2)) }, expectedErr: ErrOverflow},
+		{name: "In the same currency", calculate: func() (Money, error) { return gbp(5).In("GBP") }, expected: gbp(5)},
+		{name: "In with no currency", calculate: func() (Money, error) { return Money{Amount: 5}.In("GBP") }, expected: gbp(5)},
+		{name: "In another currency", calculate: func() (Money, error) { return gbp(5).In("EUR") }, expectedErr: ErrCurrencyMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.calculate()
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedErr, err)
+			}
+			if got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func withoutRemainder(m Money, _ int64, err error) (Money, error) {
+	return m, err
+}
+
+func TestMoneyMulDivRemainder(t *testing.T) {
+	if _, remainder, _ := (Money{Amount: 199, Currency: "GBP"}).MulDiv(15, 100); remainder != 85 {
+		t.Errorf("Expected a remainder of 85, got %d", remainder)
+	}
+	if _, remainder, _ := (Money{Amount: -199, Currency: "GBP"}).MulDiv(15, 100); remainder != -85 {
+		t.Errorf("Expected a remainder of -85, got %d", remainder)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for _, tc := range []struct {
+		money    Money
+		expected string
+	}{
+		{money: Money{Amount: 195, Currency: "GBP"}, expected: "1.95 GBP"},
+		{money: Money{Amount: 5, Currency: "EUR"}, expected: "0.05 EUR"},
+		{money: Money{Amount: -1250, Currency: "USD"}, expected: "-12.50 USD"},
+		{money: Money{Amount: 500, Currency: "JPY"}, expected: "500 JPY"},
+		{money: Money{Amount: 1500, Currency: "KWD"}, expected: "1.500 KWD"},
+		{money: Money{Amount: 42}, expected: "42"},
+	} {
+		if got := tc.money.String(); got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var rule PricingRule
+	if err := json.Unmarshal([]byte(`{"unitPrice": {"amount": 50, "currency": "EUR"}}`), &rule); err != nil {
+		t.Fatalf("Unmarshal() returned an unexpected error: %v", err)
+	}
+	if rule.UnitPrice != (Money{Amount: 50, Currency: "EUR"}) {
+		t.Errorf("Expected a unit price of 50 EUR, got %+v", rule.UnitPrice)
+	}
+
+	// Older pricing files and sessions hold bare amounts.
+	if err := json.Unmarshal([]byte(`{"unitPrice": 30}`), &rule); err != nil {
+		t.Fatalf("Unmarshal() returned an unexpected error: %v", err)
+	}
+	if rule.UnitPrice != (Money{Amount: 30}) {
+		t.Errorf("Expected a unit price of 30 with no currency, got %+v", rule.UnitPrice)
+	}
+	if err := json.Unmarshal([]byte(`{"unitPrice": 30.5}`), &rule); err == nil {
+		t.Error("Expected an error reading a fractional amount, but got nil")
+	}
+
+	raw, err := json.Marshal(Money{Amount: 195, Currency: "GBP"})
+	if err != nil {
+		t.Fatalf("Marshal() returned an unexpected error: %v", err)
+	}
+	if string(raw) != `{"amount":195,"currency":"GBP"}` {
+		t.Errorf("Expected an amount with its currency, got %s", raw)
+	}
+}
+
+func TestValidateCurrency(t *testing.T) {
+	if err := ValidateCurrency("EUR"); err != nil {
+		t.Errorf("Expected EUR to be valid, got %v", err)
+	}
+	for _, code := range []string{"", "eur", "XYZ", "EURO"} {
+		if err := ValidateCurrency(code); !errors.Is(err, ErrUnknownCurrency) {
+			t.Errorf("Expected error %v for '%s', got %v", ErrUnknownCurrency, code, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Promotion is an offer that discounts groups of items in a basket. Each
+// time it is applied it takes a group of units out of the basket, so that no
+// unit is discounted by more than one application of any promotion.
+type Promotion interface {
+	// ID names the promotion uniquely within a price list.
+	ID() string
+	// Type is the name the promotion's type is registered under.
+	Type() string
+	// Deals returns every way the promotion can be applied once to basket.
+	// Every deal only takes units that are in the basket, and the deals for
+	// a smaller basket are those of a larger one that still fit in it, so
+	// that deals can be worked out once for a basket and reused as it empties.
+	Deals(basket Basket) []Deal
+}
+
+// Basket is the set of units a promotion can be applied to, with the unit
+// price each SKU is charged at before discounts, in minor units of Currency.
+type Basket struct {
+	Items      map[string]int
+	UnitPrices map[string]int64
+	Currency   string
+}
+
+// Deal is a single application of a promotion: the units it takes from the
+// basket and the discount it gives on each SKU among them, in minor units of
+// the basket's currency.
+type Deal struct {
+	Items     map[string]int
+	Discounts map[string]int64
+}
+
+// Discount returns the total discount the deal gives, or ErrOverflow if it
+// is too large to represent.
+func (d Deal) Discount() (int64, error) {
+	total := Money{}
+	for _, discount := range d.Discounts {
+		var err error
+		if total, err = total.Add(Money{Amount: discount}); err != nil {
+			return 0, err
+		}
+	}
+	return total.Amount, nil
+}
+
+// PromotionSpec is the configuration of a promotion in a price list. Params
+// holds the settings of its type, as registered with a promotion registry.
+// Promotions with a higher Priority take units before those with a lower
+// one, and an Exclusive promotion is never combined with another. A
+// promotion with a Schedule only applies while the schedule is active.
+type PromotionSpec struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Params    json.RawMessage `json:"params"`
+	Priority  int             `json:"priority,omitempty"`
+	Exclusive bool            `json:"exclusive,omitempty"`
+	Schedule  *Schedule       `json:"schedule,omitempty"`
+}
+
+// Schedule limits when an offer applies: from From until Until, where they
+// are set, and when Windows are given only during one of them. Windows are
+// read in the IANA TimeZone, or UTC when it is empty.
+type Schedule struct {
+	From     *time.Time        `json:"from,omitempty"`
+	Until    *time.Time        `json:"until,omitempty"`
+	TimeZone string            `json:"timeZone,omitempty"`
+	Windows  []RecurringWindow `json:"windows,omitempty"`
+}
+
+// RecurringWindow is a time of day, from Start until End as "15:04", on the
+// days of the week named in Days, or every day when Days is empty. Start
+// defaults to the start of the day and End to the end of it, and a window
+// whose End is before its Start runs past midnight into the next day.
+type RecurringWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start,omitempty"`
+	End   string   `json:"end,omitempty"`
+}
+
+// AppliedPromotion reports a promotion that was applied to a checkout: how
+// many times it was applied and how much it saved in total.
+type AppliedPromotion struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Applications int    `json:"applications"`
+	Savings      Money  `json:"savings"`
+}
+
+// BasketDiscountType is how a basket discount is worked out.
+type BasketDiscountType string
+
+const (
+	// DiscountPercent takes Percent percent off the subtotal.
+	DiscountPercent BasketDiscountType = "percent"
+	// DiscountFixed takes Amount off the subtotal.
+	DiscountFixed BasketDiscountType = "fixed"
+)
+
+// BasketDiscount is a discount on the whole basket, taken from the subtotal
+// of its lines once that is more than SpendOver.
+type BasketDiscount struct {
+	ID        string             `json:"id"`
+	Type      BasketDiscountType `json:"type"`
+	SpendOver Money              `json:"spendOver"`
+	Percent   int                `json:"percent,omitempty"`
+	Amount    Money              `json:"amount,omitzero"`
+}
+
+// In returns the discount with its amounts in currency, giving the currency
+// to amounts that have none. It returns ErrCurrencyMismatch if either is in
+// another currency.
+func (d BasketDiscount) In(currency string) (discount BasketDiscount, err error) {
+	if d.SpendOver, err = d.SpendOver.In(currency); err != nil {
+		return BasketDiscount{}, err
+	}
+	if d.Amount, err = d.Amount.In(currency); err != nil {
+		return BasketDiscount{}, err
+	}
+	return d, nil
+}
+
+// DiscountLine reports a discount taken from a checkout's subtotal.
+type DiscountLine struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Amount Money  `json:"amount"`
+}
+
+// Coupon is a code a customer presents at the till for a discount off their
+// basket, worked out like a BasketDiscount of the same Type once the subtotal
+// is more than SpendOver. It can only be applied between ValidFrom and
+// ValidUntil, when they are set, and redeemed at most MaxUses times in total,
+// or any number of times when MaxUses is zero. A Stackable coupon can be
+// combined with other stackable coupons; any other must be used alone. A
+// coupon is issued in Currency, and can only be applied to sessions charging
+// in it.
+type Coupon struct {
+	Code       string             `json:"code"`
+	Type       BasketDiscountType `json:"type"`
+	Currency   string             `json:"currency,omitempty"`
+	SpendOver  Money              `json:"spendOver,omitzero"`
+	Percent    int                `json:"percent,omitempty"`
+	Amount     Money              `json:"amount,omitzero"`
+	ValidFrom  *time.Time         `json:"validFrom,omitempty"`
+	ValidUntil *time.Time         `json:"validUntil,omitempty"`
+	MaxUses    int                `json:"maxUses,omitempty"`
+	Stackable  bool               `json:"stackable,omitempty"`
+}
+
+// In returns the coupon in currency, giving the currency to the coupon and
+// its amounts if they have none. It returns ErrCurrencyMismatch if the coupon
+// was issued in another currency or either amount is in one.
+func (c Coupon) In(currency string) (coupon Coupon, err error) {
+	if c.Currency == "" {
+		c.Currency = currency
+	}
+	if c.Currency != currency {
+		return Coupon{}, fmt.Errorf("%w: issued in %s", ErrCurrencyMismatch, c.Currency)
+	}
+	if c.SpendOver, err = c.SpendOver.In(currency); err != nil {
+		return Coupon{}, err
+	}
+	if c.Amount, err = c.Amount.In(currency); err != nil {
+		return Coupon{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,50 @@
+package domain
+
+// TaxMode is whether the prices of a price list include tax.
+type TaxMode string
+
+const (
+	// TaxInclusive prices include tax, which is worked out from them.
+	TaxInclusive TaxMode = "inclusive"
+	// TaxExclusive prices are net of tax, which is added to them.
+	TaxExclusive TaxMode = "exclusive"
+)
+
+// TaxRounding is where tax is rounded to the smallest currency unit.
+type TaxRounding string
+
+const (
+	// RoundPerLine rounds the tax on each line, then adds it up per rate.
+	RoundPerLine TaxRounding = "line"
+	// RoundPerInvoice adds up the lines at each rate, then rounds their tax.
+	RoundPerInvoice TaxRounding = "invoice"
+)
+
+// TaxConfig is the tax rate table of a price list. Rates maps each tax
+// category to its rate in basis points, so 2000 is 20%. SKUs whose rule has
+// no tax category are taxed in DefaultCategory.
+type TaxConfig struct {
+	Mode            TaxMode        `json:"mode"`
+	Rounding        TaxRounding    `json:"rounding"`
+	Rates           map[string]int `json:"rates"`
+	DefaultCategory string         `json:"defaultCategory"`
+}
+
+// TaxSummary reports the tax in a checkout's total: its net amount, the tax
+// on it and the gross amount the customer pays, in total and at each rate.
+type TaxSummary struct {
+	Mode  TaxMode   `json:"mode"`
+	Rates []TaxLine `json:"rates"`
+	Net   Money     `json:"net"`
+	Tax   Money     `json:"tax"`
+	Gross Money     `json:"gross"`
+}
+
+// TaxLine reports the amounts charged in one tax category.
+type TaxLine struct {
+	Category string `json:"category"`
+	Rate     int    `json:"rate"`
+	Net      Money  `json:"net"`
+	Tax      Money  `json:"tax"`
+	Gross    Money  `json:"gross"`
+}
@@ -0,0 +1,84 @@
+// Package barcode reads the EAN-8, UPC-A and EAN-13 barcodes sent by
+// scanners and finds the SKUs they are for in a price list.
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidBarcode is returned for a barcode that is not 8, 12 or 13 digits long.
+	ErrInvalidBarcode = errors.New("is not an EAN-8, UPC-A or EAN-13 barcode")
+	// ErrCheckDigit is returned for a barcode whose check digit does not match its other digits.
+	ErrCheckDigit = errors.New("has an invalid check digit")
+	// ErrUnknownBarcode is returned for a barcode no SKU in the price list has.
+	ErrUnknownBarcode = errors.New("is not in the catalogue")
+)
+
+// Embedded is what the value carried in a variable-measure barcode is.
+type Embedded string
+
+const (
+	// EmbeddedPrice values are the price marked on the item, in minor units
+	// of the currency it is sold in.
+	EmbeddedPrice Embedded = "price"
+	// EmbeddedMeasure values are the measured quantity of the item in
+	// thousandths of the unit its SKU is sold in, such as grams.
+	EmbeddedMeasure Embedded = "measure"
+)
+
+// GTIN checks the length and check digit of a barcode and returns it as a
+// 13 digit GTIN, with EAN-8 and UPC-A barcodes padded with leading zeros.
+func GTIN(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	switch len(code) {
+	case 8, 12, 13:
+	default:
+		return "", fmt.Errorf("barcode '%s' %w", code, ErrInvalidBarcode)
+	}
+	if strings.TrimLeft(code, "0123456789") != "" {
+		return "", fmt.Errorf("barcode '%s' %w", code, ErrInvalidBarcode)
+	}
+	last := len(code) - 1
+	if CheckDigit(code[:last]) != code[last] {
+		return "", fmt.Errorf("barcode '%s' %w, expected %c", code, ErrCheckDigit, CheckDigit(code[:last]))
+	}
+	return strings.Repeat("0", 13-len(code)) + code, nil
+}
+
+// CheckDigit returns the GS1 check digit of a barcode's other digits. Digits
+// are weighted 3 and 1 alternately from the right, and the check digit
+// brings their weighted sum up to a multiple of 10.
+func CheckDigit(digits string) byte {
+	sum := 0
+	for i := range len(digits) {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// variableMeasure reads a GTIN as an in-store variable-measure barcode. These
+// have the prefix 02, as UPC-A barcodes with number system 2 do once padded,
+// or 20 to 29, followed by a five digit item reference, a five digit value
+// and the check digit. The value of prefixes 02 and 20 to 24 is a price, and
+// that of prefixes 25 to 29 a measure. It reports false for any other GTIN.
+func variableMeasure(gtin string) (reference string, embedded Embedded, value int64, ok bool) {
+	prefix := gtin[:2]
+	switch {
+	case prefix == "02", prefix >= "20" && prefix <= "24":
+		embedded = EmbeddedPrice
+	case prefix >= "25" && prefix <= "29":
+		embedded = EmbeddedMeasure
+	default:
+		return "", "", 0, false
+	}
+	value, _ = strconv.ParseInt(gtin[7:12], 10, 64)
+	return gtin[2:7], embedded, value, true
+}
@@ -0,0 +1,77 @@
+package barcode
+
+import (
+	"errors"
+	"testing"
+)
+
+// withCheckDigit completes a barcode with its check digit.
+func withCheckDigit(digits string) string {
+	return digits + string(CheckDigit(digits))
+}
+
+func TestGTIN(t *testing.T) {
+	testCases := []struct {
+		name     string
+		code     string
+		gtin     string
+		expected error
+	}{
+		{name: "EAN-13", code: "4006381333931", gtin: "4006381333931"},
+		{name: "UPC-A", code: "036000291452", gtin: "0036000291452"},
+		{name: "EAN-8", code: "73513537", gtin: "0000073513537"},
+		{name: "Surrounding spaces", code: " 4006381333931 ", gtin: "4006381333931"},
+		{name: "Wrong check digit", code: "4006381333932", expected: ErrCheckDigit},
+		{name: "Transposed digits", code: "4006383133931", expected: ErrCheckDigit},
+		{name: "GTIN-14", code: "14006381333938", expected: ErrInvalidBarcode},
+		{name: "Too short", code: "1234567", expected: ErrInvalidBarcode},
+		{name: "Letters", code: "40063813339A1", expected: ErrInvalidBarcode},
+		{name: "Empty", code: "", expected: ErrInvalidBarcode},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gtin, err := GTIN(tc.code)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+			if gtin != tc.gtin {
+				t.Errorf("Expected GTIN %q, got %q", tc.gtin, gtin)
+			}
+		})
+	}
+}
+
+func TestVariableMeasure(t *testing.T) {
+	testCases := []struct {
+		name      string
+		code      string
+		reference string
+		embedded  Embedded
+		value     int64
+		ok        bool
+	}{
+		{name: "Price in an EAN-13 with prefix 20", code: withCheckDigit("201234500250"), reference: "12345", embedded: EmbeddedPrice, value: 250, ok: true},
+		{name: "Price in an EAN-13 with prefix 24", code: withCheckDigit("241234501999"), reference: "12345", embedded: EmbeddedPrice, value: 1999, ok: true},
+		{name: "Price in a UPC-A with number system 2", code: withCheckDigit("21234500199"), reference: "12345", embedded: EmbeddedPrice, value: 199, ok: true},
+		{name: "Measure in an EAN-13 with prefix 25", code: withCheckDigit("251234500456"), reference: "12345", embedded: EmbeddedMeasure, value: 456, ok: true},
+		{name: "Measure in an EAN-13 with prefix 29", code: withCheckDigit("290000112000"), reference: "00001", embedded: EmbeddedMeasure, value: 12000, ok: true},
+		{name: "Prefix 19", code: withCheckDigit("191234500250")},
+		{name: "Prefix 30", code: withCheckDigit("301234500250")},
+		{name: "UPC-A with number system 0", code: "036000291452"},
+		{name: "EAN-8 beginning with 2", code: withCheckDigit("2123456")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gtin, err := GTIN(tc.code)
+			if err != nil {
+				t.Fatalf("GTIN() returned an unexpected error: %v", err)
+			}
+			reference, embedded, value, ok := variableMeasure(gtin)
+			if ok != tc.ok || reference != tc.reference || embedded != tc.embedded || value != tc.value {
+				t.Errorf("Expected %q %s %d (%t), got %q %s %d (%t)", tc.reference, tc.embedded, tc.value, tc.ok, reference, embedded, value, ok)
+			}
+		})
+	}
+}
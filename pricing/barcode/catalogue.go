@@ -0,0 +1,91 @@
+package barcode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// Catalogue finds the SKUs of a price list by the barcodes of their rules:
+// the GTINs a rule lists, and the item reference of a rule in in-store
+// variable-measure barcodes.
+type Catalogue struct {
+	gtins      map[string]string // 13 digit GTIN to SKU
+	references map[string]string // Variable-measure item reference to SKU
+}
+
+// Item is the SKU a barcode is for, with the value embedded in it if it is a
+// variable-measure barcode.
+type Item struct {
+	SKU      string
+	Embedded Embedded // Empty unless the barcode carries a value
+	Value    int64
+}
+
+// NewCatalogue indexes the barcodes of rules. It returns an error if a
+// barcode is invalid or in the range kept for variable-measure barcodes, if
+// an item reference is not five digits or is on an SKU sold each, or if two
+// SKUs share a barcode or item reference.
+func NewCatalogue(rules map[string]domain.PricingRule) (*Catalogue, error) {
+	c := &Catalogue{gtins: make(map[string]string), references: make(map[string]string)}
+
+	skus := make([]string, 0, len(rules))
+	for sku := range rules {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	for _, sku := range skus {
+		rule := rules[sku]
+		for _, code := range rule.Barcodes {
+			gtin, err := GTIN(code)
+			if err != nil {
+				return nil, fmt.Errorf("sku '%s': %w", sku, err)
+			}
+			if _, _, _, ok := variableMeasure(gtin); ok {
+				return nil, fmt.Errorf("sku '%s': barcode '%s' is a variable-measure barcode; give the SKU an itemReference instead", sku, code)
+			}
+			if other, taken := c.gtins[gtin]; taken {
+				return nil, fmt.Errorf("sku '%s': barcode '%s' is already used by sku '%s'", sku, code, other)
+			}
+			c.gtins[gtin] = sku
+		}
+		if rule.ItemReference == "" {
+			continue
+		}
+		if len(rule.ItemReference) != 5 || strings.TrimLeft(rule.ItemReference, "0123456789") != "" {
+			return nil, fmt.Errorf("sku '%s': item reference '%s' must be five digits", sku, rule.ItemReference)
+		}
+		if !rule.Unit.Measured() {
+			return nil, fmt.Errorf("sku '%s': sold each, so it cannot have an item reference", sku)
+		}
+		if other, taken := c.references[rule.ItemReference]; taken {
+			return nil, fmt.Errorf("sku '%s': item reference '%s' is already used by sku '%s'", sku, rule.ItemReference, other)
+		}
+		c.references[rule.ItemReference] = sku
+	}
+	return c, nil
+}
+
+// Lookup returns the item a barcode is for. It returns ErrInvalidBarcode or
+// ErrCheckDigit if the barcode cannot be read, and ErrUnknownBarcode if no
+// SKU has it.
+func (c *Catalogue) Lookup(code string) (Item, error) {
+	gtin, err := GTIN(code)
+	if err != nil {
+		return Item{}, err
+	}
+	if reference, embedded, value, ok := variableMeasure(gtin); ok {
+		sku, exists := c.references[reference]
+		if !exists {
+			return Item{}, fmt.Errorf("barcode '%s' %w: no sku has item reference '%s'", code, ErrUnknownBarcode, reference)
+		}
+		return Item{SKU: sku, Embedded: embedded, Value: value}, nil
+	}
+	sku, exists := c.gtins[gtin]
+	if !exists {
+		return Item{}, fmt.Errorf("barcode '%s' %w", code, ErrUnknownBarcode)
+	}
+	return Item{SKU: sku}, nil
+}
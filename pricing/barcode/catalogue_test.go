@@ -0,0 +1,75 @@
+package barcode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestCatalogueLookup(t *testing.T) {
+	catalogue, err := NewCatalogue(map[string]domain.PricingRule{
+		"A":      {UnitPrice: domain.Money{Amount: 50}, Barcodes: []string{"4006381333931", "73513537"}},
+		"B":      {UnitPrice: domain.Money{Amount: 30}, Barcodes: []string{"036000291452"}},
+		"BANANA": {UnitPrice: domain.Money{Amount: 120}, Unit: domain.UnitKilogram, ItemReference: "12345"},
+		"C":      {UnitPrice: domain.Money{Amount: 20}},
+	})
+	if err != nil {
+		t.Fatalf("NewCatalogue() returned an unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		code     string
+		item     Item
+		expected error
+	}{
+		{name: "EAN-13", code: "4006381333931", item: Item{SKU: "A"}},
+		{name: "Second barcode of an SKU", code: "73513537", item: Item{SKU: "A"}},
+		{name: "UPC-A", code: "036000291452", item: Item{SKU: "B"}},
+		{name: "UPC-A read as an EAN-13", code: "0036000291452", item: Item{SKU: "B"}},
+		{name: "Embedded price", code: withCheckDigit("201234500250"), item: Item{SKU: "BANANA", Embedded: EmbeddedPrice, Value: 250}},
+		{name: "Embedded measure", code: withCheckDigit("251234500456"), item: Item{SKU: "BANANA", Embedded: EmbeddedMeasure, Value: 456}},
+		{name: "Unknown GTIN", code: "5901234123457", expected: ErrUnknownBarcode},
+		{name: "Unknown item reference", code: withCheckDigit("205432100250"), expected: ErrUnknownBarcode},
+		{name: "Wrong check digit", code: "4006381333930", expected: ErrCheckDigit},
+		{name: "SKU code", code: "A", expected: ErrInvalidBarcode},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			item, err := catalogue.Lookup(tc.code)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+			if item != tc.item {
+				t.Errorf("Expected %+v, got %+v", tc.item, item)
+			}
+		})
+	}
+}
+
+func TestNewCatalogueErrors(t *testing.T) {
+	each := domain.PricingRule{UnitPrice: domain.Money{Amount: 50}}
+	weighed := domain.PricingRule{UnitPrice: domain.Money{Amount: 120}, Unit: domain.UnitKilogram}
+	with := func(rule domain.PricingRule, barcodes []string, reference string) domain.PricingRule {
+		rule.Barcodes, rule.ItemReference = barcodes, reference
+		return rule
+	}
+
+	for name, rules := range map[string]map[string]domain.PricingRule{
+		"barcode with a wrong check digit":   {"A": with(each, []string{"4006381333932"}, "")},
+		"barcode that is not a GTIN":         {"A": with(each, []string{"A-1"}, "")},
+		"variable-measure barcode":           {"A": with(weighed, []string{withCheckDigit("201234500000")}, "")},
+		"barcode shared by two SKUs":         {"A": with(each, []string{"4006381333931"}, ""), "B": with(each, []string{"4006381333931"}, "")},
+		"barcode shared as UPC-A and EAN-13": {"A": with(each, []string{"036000291452"}, ""), "B": with(each, []string{"0036000291452"}, "")},
+		"item reference of four digits":      {"A": with(weighed, nil, "1234")},
+		"item reference with letters":        {"A": with(weighed, nil, "1234X")},
+		"item reference on an SKU sold each": {"A": with(each, nil, "12345")},
+		"item reference shared by two SKUs":  {"A": with(weighed, nil, "12345"), "B": with(weighed, nil, "12345")},
+	} {
+		if _, err := NewCatalogue(rules); err == nil {
+			t.Errorf("Expected an error for a %s, but got nil", name)
+		}
+	}
+}
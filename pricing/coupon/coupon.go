@@ -0,0 +1,119 @@
+// Package coupon holds the coupon codes customers can apply at the till and
+// counts how many times each has been redeemed.
+package coupon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// DiscountType is the DiscountLine type of the discounts coupons give.
+const DiscountType = "coupon"
+
+var (
+	// ErrUnknownCoupon is returned for a code that is not in the registry.
+	ErrUnknownCoupon = errors.New("is not a valid coupon code")
+	// ErrCouponNotYetValid is returned for a coupon used before its ValidFrom.
+	ErrCouponNotYetValid = errors.New("is not valid yet")
+	// ErrCouponExpired is returned for a coupon used after its ValidUntil.
+	ErrCouponExpired = errors.New("has expired")
+	// ErrCouponExhausted is returned for a coupon redeemed MaxUses times already.
+	ErrCouponExhausted = errors.New("has no uses left")
+)
+
+// Normalize returns the form codes are matched in: customers may type them in
+// any case and with stray spaces.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks that every coupon is complete and that their codes are
+// unique, ignoring case. A coupon's currency, when it has one, must be a
+// known currency that its amounts are in.
+func Validate(coupons []domain.Coupon) error {
+	seen := make(map[string]bool, len(coupons))
+	for _, coupon := range coupons {
+		code := Normalize(coupon.Code)
+		if code == "" {
+			return errors.New("coupon has no code")
+		}
+		if seen[code] {
+			return fmt.Errorf("coupon '%s' is configured more than once", coupon.Code)
+		}
+		seen[code] = true
+		if coupon.Currency != "" {
+			if err := domain.ValidateCurrency(coupon.Currency); err != nil {
+				return fmt.Errorf("coupon '%s': %w", coupon.Code, err)
+			}
+			if _, err := coupon.In(coupon.Currency); err != nil {
+				return fmt.Errorf("coupon '%s': %w", coupon.Code, err)
+			}
+		}
+		if coupon.SpendOver.Amount < 0 {
+			return fmt.Errorf("coupon '%s': spendOver must not be negative", coupon.Code)
+		}
+		if coupon.MaxUses < 0 {
+			return fmt.Errorf("coupon '%s': maxUses must not be negative", coupon.Code)
+		}
+		if coupon.ValidFrom != nil && coupon.ValidUntil != nil && !coupon.ValidFrom.Before(*coupon.ValidUntil) {
+			return fmt.Errorf("coupon '%s': validFrom must be before validUntil", coupon.Code)
+		}
+		switch coupon.Type {
+		case domain.DiscountPercent:
+			if coupon.Percent <= 0 || coupon.Percent > 100 {
+				return fmt.Errorf("coupon '%s': percent must be between 1 and 100", coupon.Code)
+			}
+		case domain.DiscountFixed:
+			if coupon.Amount.Amount <= 0 {
+				return fmt.Errorf("coupon '%s': amount must be positive", coupon.Code)
+			}
+		default:
+			return fmt.Errorf("coupon '%s': unknown type '%s', expected '%s' or '%s'",
+				coupon.Code, coupon.Type, domain.DiscountPercent, domain.DiscountFixed)
+		}
+	}
+	return nil
+}
+
+// Apply returns the discounts coupons take from a basket whose lines come to
+// subtotal and which costs total after its basket discount. Coupons are
+// taken in the order given, each from what is left after those before it,
+// and only once subtotal is more than their SpendOver.
+//
+// Percentages are rounded down to the smallest currency unit, and coupons
+// never take the total below zero. Coupons that save nothing are left out.
+// Amounts with no currency are in that of total; Apply returns
+// domain.ErrCurrencyMismatch if a coupon is in another, and
+// domain.ErrOverflow if one is too large to represent.
+func Apply(subtotal, total domain.Money, coupons []domain.Coupon) ([]domain.DiscountLine, error) {
+	lines := []domain.DiscountLine{}
+	for _, applied := range coupons {
+		coupon, err := applied.In(total.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("coupon '%s' %w", applied.Code, err)
+		}
+		if subtotal.Amount <= coupon.SpendOver.Amount {
+			continue
+		}
+		amount := coupon.Amount
+		if coupon.Type == domain.DiscountPercent {
+			if amount, _, err = total.MulDiv(int64(coupon.Percent), 100); err != nil {
+				return nil, fmt.Errorf("coupon '%s' %w", coupon.Code, err)
+			}
+		}
+		if amount.Amount > total.Amount {
+			amount = total
+		}
+		if amount.Amount <= 0 {
+			continue
+		}
+		lines = append(lines, domain.DiscountLine{ID: coupon.Code, Type: DiscountType, Amount: amount})
+		if total, err = total.Sub(amount); err != nil {
+			return nil, fmt.Errorf("coupon '%s' %w", coupon.Code, err)
+		}
+	}
+	return lines, nil
+}
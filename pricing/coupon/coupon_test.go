@@ -0,0 +1,84 @@
+package coupon
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestApply(t *testing.T) {
+	tenPercent := domain.Coupon{Code: "TEN", Type: domain.DiscountPercent, Percent: 10}
+	fiftyOff := domain.Coupon{Code: "FIFTY", Type: domain.DiscountFixed, Amount: gbp(50), SpendOver: gbp(500)}
+
+	testCases := []struct {
+		name     string
+		subtotal int64
+		total    int64
+		coupons  []domain.Coupon
+		expected []domain.DiscountLine
+	}{
+		{name: "No coupons", subtotal: 400, total: 400, expected: []domain.DiscountLine{}},
+		{name: "Threshold must be exceeded", subtotal: 500, total: 500, coupons: []domain.Coupon{fiftyOff}, expected: []domain.DiscountLine{}},
+		{name: "Fixed amount off", subtotal: 501, total: 501, coupons: []domain.Coupon{fiftyOff}, expected: []domain.DiscountLine{{ID: "FIFTY", Type: "coupon", Amount: gbp(50)}}},
+		{name: "Percentage of the total after the basket discount", subtotal: 1000, total: 905, coupons: []domain.Coupon{tenPercent}, expected: []domain.DiscountLine{{ID: "TEN", Type: "coupon", Amount: gbp(90)}}},
+		{
+			name: "Coupons are taken in order", subtotal: 1000, total: 1000, coupons: []domain.Coupon{fiftyOff, tenPercent},
+			expected: []domain.DiscountLine{{ID: "FIFTY", Type: "coupon", Amount: gbp(50)}, {ID: "TEN", Type: "coupon", Amount: gbp(95)}},
+		},
+		{name: "Percentage of a large total", subtotal: 2e17, total: 2e17, coupons: []domain.Coupon{tenPercent}, expected: []domain.DiscountLine{{ID: "TEN", Type: "coupon", Amount: gbp(2e16)}}},
+		{name: "Capped at the total", subtotal: 600, total: 30, coupons: []domain.Coupon{fiftyOff}, expected: []domain.DiscountLine{{ID: "FIFTY", Type: "coupon", Amount: gbp(30)}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Apply(gbp(tc.subtotal), gbp(tc.total), tc.coupons)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+
+	euros := domain.Coupon{Code: "EUROS", Type: domain.DiscountFixed, Amount: domain.Money{Amount: 50, Currency: "EUR"}}
+	if _, err := Apply(gbp(600), gbp(600), []domain.Coupon{euros}); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v applying a coupon in another currency, got %v", domain.ErrCurrencyMismatch, err)
+	}
+	eurosPercent := domain.Coupon{Code: "EUROS", Type: domain.DiscountPercent, Currency: "EUR", Percent: 10}
+	if _, err := Apply(gbp(600), gbp(600), []domain.Coupon{eurosPercent}); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v applying a percentage coupon issued in another currency, got %v", domain.ErrCurrencyMismatch, err)
+	}
+}
+
+func gbp(amount int64) domain.Money {
+	return domain.Money{Amount: amount, Currency: "GBP"}
+}
+
+func TestValidate(t *testing.T) {
+	from, until := day(2), day(1)
+	for name, coupon := range map[string]domain.Coupon{
+		"missing code":               {Type: domain.DiscountFixed, Amount: gbp(50)},
+		"unknown type":               {Code: "X", Type: "bogof", Amount: gbp(50)},
+		"zero percent":               {Code: "X", Type: domain.DiscountPercent},
+		"over 100 percent":           {Code: "X", Type: domain.DiscountPercent, Percent: 101},
+		"zero amount":                {Code: "X", Type: domain.DiscountFixed},
+		"negative threshold":         {Code: "X", Type: domain.DiscountFixed, Amount: gbp(50), SpendOver: gbp(-1)},
+		"negative max uses":          {Code: "X", Type: domain.DiscountFixed, Amount: gbp(50), MaxUses: -1},
+		"empty window":               {Code: "X", Type: domain.DiscountFixed, Amount: gbp(50), ValidFrom: &from, ValidUntil: &until},
+		"unknown currency":           {Code: "X", Type: domain.DiscountPercent, Currency: "XYZ", Percent: 10},
+		"amount in another currency": {Code: "X", Type: domain.DiscountFixed, Currency: "EUR", Amount: gbp(50)},
+	} {
+		if err := Validate([]domain.Coupon{coupon}); err == nil {
+			t.Errorf("Expected an error validating a coupon with %s, but got nil", name)
+		}
+	}
+
+	lower := domain.Coupon{Code: "save", Type: domain.DiscountFixed, Amount: gbp(50)}
+	upper := domain.Coupon{Code: "SAVE", Type: domain.DiscountFixed, Amount: gbp(50)}
+	if err := Validate([]domain.Coupon{lower, upper}); err == nil {
+		t.Error("Expected an error validating coupons whose codes differ only in case, but got nil")
+	}
+}
@@ -0,0 +1,146 @@
+package coupon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// Registry holds the coupons that can be applied at the till and counts the
+// redemptions of each. Counts are kept in memory, so they start again from
+// zero when the registry is recreated, as on a restart, however sessions are
+// stored, and are not shared with other processes holding a registry of the
+// same coupons. MaxUses only limits the uses counted by one registry.
+type Registry struct {
+	coupons map[string]domain.Coupon // Keyed by normalised code
+	uses    map[string]int
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithClock sets the clock validity windows are checked against. Registries
+// use the system clock by default.
+func WithClock(now func() time.Time) Option {
+	return func(r *Registry) {
+		r.now = now
+	}
+}
+
+// NewRegistry creates a registry of coupons, none of which has been redeemed.
+// Coupons with no currency are issued in domain.DefaultCurrency.
+func NewRegistry(coupons []domain.Coupon, opts ...Option) (*Registry, error) {
+	issued := make([]domain.Coupon, len(coupons))
+	for i, coupon := range coupons {
+		if coupon.Currency == "" {
+			coupon.Currency = domain.DefaultCurrency
+		}
+		issued[i] = coupon
+	}
+	if err := Validate(issued); err != nil {
+		return nil, err
+	}
+	r := &Registry{
+		coupons: make(map[string]domain.Coupon, len(coupons)),
+		uses:    make(map[string]int, len(coupons)),
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	for _, coupon := range issued {
+		r.coupons[Normalize(coupon.Code)], _ = coupon.In(coupon.Currency)
+	}
+	return r, nil
+}
+
+// couponsFile is the layout of coupons.json.
+type couponsFile struct {
+	Coupons []domain.Coupon `json:"coupons"`
+}
+
+// Load creates a registry of the coupons in a coupons.json file.
+func Load(path string, opts ...Option) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coupons: %w", err)
+	}
+	var parsed couponsFile
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse coupons: %w", err)
+	}
+	return NewRegistry(parsed.Coupons, opts...)
+}
+
+// Lookup returns the coupon with code, ignoring case, if it can be applied
+// now: it must be within its validity window and have uses left.
+func (r *Registry) Lookup(code string) (domain.Coupon, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.available(code, r.now())
+}
+
+// Redeem records one use of each code. Either every code is redeemed or, if
+// any is unknown, outside its validity window now or has no uses left, none
+// is, so that a coupon which expires between being applied and the session
+// completing is not redeemed.
+func (r *Registry) Redeem(codes ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for _, code := range codes {
+		if _, err := r.available(code, now); err != nil {
+			return err
+		}
+	}
+	for _, code := range codes {
+		r.uses[Normalize(code)]++
+	}
+	return nil
+}
+
+// Release gives back one use of each code, undoing a Redeem of them.
+func (r *Registry) Release(codes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, code := range codes {
+		if r.uses[Normalize(code)] > 0 {
+			r.uses[Normalize(code)]--
+		}
+	}
+}
+
+// Uses returns the number of times code has been redeemed.
+func (r *Registry) Uses(code string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uses[Normalize(code)]
+}
+
+// available returns the coupon with code if it is within its validity window
+// at now and has uses left.
+func (r *Registry) available(code string, now time.Time) (domain.Coupon, error) {
+	coupon, exists := r.coupons[Normalize(code)]
+	if !exists {
+		return domain.Coupon{}, fmt.Errorf("coupon '%s' %w", code, ErrUnknownCoupon)
+	}
+	if coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom) {
+		return domain.Coupon{}, fmt.Errorf("coupon '%s' %w", code, ErrCouponNotYetValid)
+	}
+	if coupon.ValidUntil != nil && !now.Before(*coupon.ValidUntil) {
+		return domain.Coupon{}, fmt.Errorf("coupon '%s' %w", code, ErrCouponExpired)
+	}
+	if coupon.MaxUses > 0 && r.uses[Normalize(code)] >= coupon.MaxUses {
+		return domain.Coupon{}, fmt.Errorf("coupon '%s' %w", code, ErrCouponExhausted)
+	}
+	return coupon, nil
+}
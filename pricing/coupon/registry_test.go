@@ -0,0 +1,161 @@
+package coupon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// day returns midnight UTC on the given day of January 2025.
+func day(n int) time.Time {
+	return time.Date(2025, 1, n, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRegistryLookup(t *testing.T) {
+	from, until := day(10), day(20)
+	now := day(15)
+	registry, err := NewRegistry([]domain.Coupon{
+		{Code: "Spring", Type: domain.DiscountPercent, Percent: 10, ValidFrom: &from, ValidUntil: &until},
+		{Code: "ONCE", Type: domain.DiscountFixed, Amount: gbp(50), MaxUses: 1},
+	}, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		code     string
+		at       time.Time
+		expected error
+	}{
+		{name: "Within its window", code: "Spring", at: day(15)},
+		{name: "Code in another case", code: " spring ", at: day(15)},
+		{name: "Unknown code", code: "AUTUMN", at: day(15), expected: ErrUnknownCoupon},
+		{name: "Before its window", code: "Spring", at: day(9), expected: ErrCouponNotYetValid},
+		{name: "At the end of its window", code: "Spring", at: day(20), expected: ErrCouponExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = tc.at
+			coupon, err := registry.Lookup(tc.code)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+			if err == nil && coupon.Code != "Spring" {
+				t.Errorf("Expected coupon 'Spring', got '%s'", coupon.Code)
+			}
+		})
+	}
+}
+
+func TestRegistryRedeem(t *testing.T) {
+	registry, err := NewRegistry([]domain.Coupon{
+		{Code: "ONCE", Type: domain.DiscountFixed, Amount: gbp(50), MaxUses: 1},
+		{Code: "ALWAYS", Type: domain.DiscountFixed, Amount: gbp(10), Stackable: true},
+	})
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+
+	if err := registry.Redeem("ONCE", "ALWAYS"); err != nil {
+		t.Fatalf("Redeem() returned an unexpected error: %v", err)
+	}
+	if _, err := registry.Lookup("once"); !errors.Is(err, ErrCouponExhausted) {
+		t.Errorf("Expected a single-use coupon to be exhausted once redeemed, got %v", err)
+	}
+	if err := registry.Redeem("ALWAYS", "ONCE"); !errors.Is(err, ErrCouponExhausted) {
+		t.Errorf("Expected redeeming an exhausted coupon to fail, got %v", err)
+	}
+	if uses := registry.Uses("ALWAYS"); uses != 1 {
+		t.Errorf("Expected a failed redemption to redeem nothing, but 'ALWAYS' has %d uses", uses)
+	}
+
+	registry.Release("ONCE")
+	if _, err := registry.Lookup("ONCE"); err != nil {
+		t.Errorf("Expected a released coupon to be available again, got %v", err)
+	}
+}
+
+func TestRegistryRedeemChecksValidity(t *testing.T) {
+	until := day(20)
+	now := day(15)
+	registry, err := NewRegistry([]domain.Coupon{
+		{Code: "SPRING", Type: domain.DiscountFixed, Amount: gbp(50), ValidUntil: &until},
+		{Code: "ALWAYS", Type: domain.DiscountFixed, Amount: gbp(10), Stackable: true},
+	}, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+	if _, err := registry.Lookup("SPRING"); err != nil {
+		t.Fatalf("Lookup() returned an unexpected error: %v", err)
+	}
+
+	// The coupon expires between being applied and the session completing.
+	now = day(21)
+	if err := registry.Redeem("ALWAYS", "SPRING"); !errors.Is(err, ErrCouponExpired) {
+		t.Errorf("Expected error %v redeeming an expired coupon, got %v", ErrCouponExpired, err)
+	}
+	if uses := registry.Uses("ALWAYS"); uses != 0 {
+		t.Errorf("Expected a failed redemption to redeem nothing, but 'ALWAYS' has %d uses", uses)
+	}
+}
+
+func TestRegistryUsesAreNotPersisted(t *testing.T) {
+	coupons := []domain.Coupon{{Code: "ONCE", Type: domain.DiscountFixed, Amount: gbp(50), MaxUses: 1}}
+	registry, err := NewRegistry(coupons)
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+	if err := registry.Redeem("ONCE"); err != nil {
+		t.Fatalf("Redeem() returned an unexpected error: %v", err)
+	}
+
+	// A registry recreated from the same coupons, as on a restart, has
+	// counted no uses.
+	restarted, err := NewRegistry(coupons)
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+	if uses := restarted.Uses("ONCE"); uses != 0 {
+		t.Errorf("Expected a recreated registry to start with no uses, got %d", uses)
+	}
+	if err := restarted.Redeem("ONCE"); err != nil {
+		t.Errorf("Expected a recreated registry to redeem a coupon used before, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coupons.json")
+	data := `{"coupons":[{"code":"WELCOME","type":"percent","percent":10,"validUntil":"2025-02-01T00:00:00Z","maxUses":100},
+		{"code":"EUROS","type":"fixed","currency":"EUR","amount":5}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("Could not write coupons: %v", err)
+	}
+
+	registry, err := Load(path, WithClock(func() time.Time { return day(1) }))
+	if err != nil {
+		t.Fatalf("Load() returned an unexpected error: %v", err)
+	}
+	coupon, err := registry.Lookup("welcome")
+	if err != nil {
+		t.Fatalf("Lookup() returned an unexpected error: %v", err)
+	}
+	if coupon.Percent != 10 || coupon.MaxUses != 100 || !coupon.ValidUntil.Equal(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the coupon as configured, got %+v", coupon)
+	}
+	if coupon.Currency != domain.DefaultCurrency {
+		t.Errorf("Expected a coupon with no currency to be issued in %s, got '%s'", domain.DefaultCurrency, coupon.Currency)
+	}
+	euros, err := registry.Lookup("EUROS")
+	if err != nil {
+		t.Fatalf("Lookup() returned an unexpected error: %v", err)
+	}
+	if expected := (domain.Money{Amount: 5, Currency: "EUR"}); euros.Currency != "EUR" || euros.Amount != expected {
+		t.Errorf("Expected a coupon of %v issued in EUR, got %v in '%s'", expected, euros.Amount, euros.Currency)
+	}
+}
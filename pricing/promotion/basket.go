@@ -0,0 +1,78 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// ValidateBasketDiscounts checks that every basket discount is complete and
+// that their ids are unique.
+func ValidateBasketDiscounts(discounts []domain.BasketDiscount) error {
+	seen := make(map[string]bool, len(discounts))
+	for _, discount := range discounts {
+		if discount.ID == "" {
+			return errors.New("basket discount has no id")
+		}
+		if seen[discount.ID] {
+			return fmt.Errorf("basket discount '%s' is configured more than once", discount.ID)
+		}
+		seen[discount.ID] = true
+		if discount.SpendOver.Amount < 0 {
+			return fmt.Errorf("basket discount '%s': spendOver must not be negative", discount.ID)
+		}
+		switch discount.Type {
+		case domain.DiscountPercent:
+			if discount.Percent <= 0 || discount.Percent > 100 {
+				return fmt.Errorf("basket discount '%s': percent must be between 1 and 100", discount.ID)
+			}
+		case domain.DiscountFixed:
+			if discount.Amount.Amount <= 0 {
+				return fmt.Errorf("basket discount '%s': amount must be positive", discount.ID)
+			}
+		default:
+			return fmt.Errorf("basket discount '%s': unknown type '%s', expected '%s' or '%s'",
+				discount.ID, discount.Type, domain.DiscountPercent, domain.DiscountFixed)
+		}
+	}
+	return nil
+}
+
+// ApplyBasketDiscounts returns the basket discount taken from subtotal, if any
+// applies. Basket discounts do not stack: of those whose threshold subtotal
+// is over, the one saving the most is taken, ties going to the first given.
+//
+// Percentages are rounded down to the smallest currency unit, and no
+// discount takes more than the subtotal. Amounts with no currency are in
+// that of subtotal; ApplyBasketDiscounts returns domain.ErrCurrencyMismatch
+// if a discount is in another, and domain.ErrOverflow if one is too large to
+// represent.
+func ApplyBasketDiscounts(subtotal domain.Money, discounts []domain.BasketDiscount) ([]domain.DiscountLine, error) {
+	var best *domain.DiscountLine
+	for _, configured := range discounts {
+		discount, err := configured.In(subtotal.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("basket discount '%s' %w", configured.ID, err)
+		}
+		if subtotal.Amount <= discount.SpendOver.Amount {
+			continue
+		}
+		amount := discount.Amount
+		if discount.Type == domain.DiscountPercent {
+			if amount, _, err = subtotal.MulDiv(int64(discount.Percent), 100); err != nil {
+				return nil, fmt.Errorf("basket discount '%s' %w", discount.ID, err)
+			}
+		}
+		if amount.Amount > subtotal.Amount {
+			amount = subtotal
+		}
+		if amount.Amount > 0 && (best == nil || amount.Amount > best.Amount.Amount) {
+			best = &domain.DiscountLine{ID: discount.ID, Type: string(discount.Type), Amount: amount}
+		}
+	}
+	if best == nil {
+		return []domain.DiscountLine{}, nil
+	}
+	return []domain.DiscountLine{*best}, nil
+}
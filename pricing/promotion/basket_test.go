@@ -0,0 +1,84 @@
+package promotion
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func gbp(amount int64) domain.Money {
+	return domain.Money{Amount: amount, Currency: "GBP"}
+}
+
+func TestApplyBasketDiscounts(t *testing.T) {
+	tenPercent := domain.BasketDiscount{ID: "ten-percent", Type: domain.DiscountPercent, SpendOver: gbp(1000), Percent: 10}
+	fiftyOff := domain.BasketDiscount{ID: "fifty-off", Type: domain.DiscountFixed, SpendOver: gbp(500), Amount: gbp(50)}
+	discounts := []domain.BasketDiscount{tenPercent, fiftyOff}
+
+	testCases := []struct {
+		name     string
+		subtotal int64
+		expected []domain.DiscountLine
+	}{
+		{name: "Below every threshold", subtotal: 400, expected: []domain.DiscountLine{}},
+		{name: "Threshold must be exceeded", subtotal: 500, expected: []domain.DiscountLine{}},
+		{name: "Fixed amount off", subtotal: 501, expected: []domain.DiscountLine{{ID: "fifty-off", Type: "fixed", Amount: gbp(50)}}},
+		{name: "Best discount wins", subtotal: 1001, expected: []domain.DiscountLine{{ID: "ten-percent", Type: "percent", Amount: gbp(100)}}},
+		{name: "Percentage rounds down", subtotal: 1509, expected: []domain.DiscountLine{{ID: "ten-percent", Type: "percent", Amount: gbp(150)}}},
+		{name: "Percentage of a large subtotal", subtotal: 2e17, expected: []domain.DiscountLine{{ID: "ten-percent", Type: "percent", Amount: gbp(2e16)}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ApplyBasketDiscounts(gbp(tc.subtotal), discounts)
+			if err != nil {
+				t.Fatalf("ApplyBasketDiscounts() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+
+	tied := []domain.BasketDiscount{fiftyOff, {ID: "also-fifty", Type: domain.DiscountFixed, SpendOver: gbp(500), Amount: gbp(50)}}
+	if got, _ := ApplyBasketDiscounts(gbp(600), tied); got[0].ID != "fifty-off" {
+		t.Errorf("Expected a tie to go to the first discount given, got %s", got[0].ID)
+	}
+
+	capped := []domain.BasketDiscount{{ID: "big", Type: domain.DiscountFixed, Amount: gbp(100)}}
+	if got, _ := ApplyBasketDiscounts(gbp(60), capped); got[0].Amount != gbp(60) {
+		t.Errorf("Expected a fixed discount to be capped at the subtotal of 60, got %d", got[0].Amount.Amount)
+	}
+
+	unpriced := []domain.BasketDiscount{{ID: "plain", Type: domain.DiscountFixed, SpendOver: domain.Money{Amount: 500}, Amount: domain.Money{Amount: 50}}}
+	if got, _ := ApplyBasketDiscounts(gbp(600), unpriced); got[0].Amount != gbp(50) {
+		t.Errorf("Expected a discount with no currency to take 50 GBP off, got %v", got[0].Amount)
+	}
+
+	euros := []domain.BasketDiscount{{ID: "euros", Type: domain.DiscountFixed, Amount: domain.Money{Amount: 50, Currency: "EUR"}}}
+	if _, err := ApplyBasketDiscounts(gbp(600), euros); !errors.Is(err, domain.ErrCurrencyMismatch) {
+		t.Errorf("Expected error %v taking a discount in another currency, got %v", domain.ErrCurrencyMismatch, err)
+	}
+}
+
+func TestValidateBasketDiscounts(t *testing.T) {
+	for name, discount := range map[string]domain.BasketDiscount{
+		"missing id":         {Type: domain.DiscountFixed, Amount: gbp(50)},
+		"unknown type":       {ID: "x", Type: "bogof", Amount: gbp(50)},
+		"zero percent":       {ID: "x", Type: domain.DiscountPercent},
+		"over 100 percent":   {ID: "x", Type: domain.DiscountPercent, Percent: 101},
+		"zero amount":        {ID: "x", Type: domain.DiscountFixed},
+		"negative threshold": {ID: "x", Type: domain.DiscountFixed, Amount: gbp(50), SpendOver: gbp(-1)},
+	} {
+		if err := ValidateBasketDiscounts([]domain.BasketDiscount{discount}); err == nil {
+			t.Errorf("Expected an error validating a basket discount with %s, but got nil", name)
+		}
+	}
+
+	duplicate := domain.BasketDiscount{ID: "x", Type: domain.DiscountFixed, Amount: gbp(50)}
+	if err := ValidateBasketDiscounts([]domain.BasketDiscount{duplicate, duplicate}); err == nil {
+		t.Error("Expected an error validating basket discounts with duplicate ids, but got nil")
+	}
+}
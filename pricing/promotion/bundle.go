@@ -0,0 +1,214 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// TypeBundle is the type name of Bundle promotions.
+const TypeBundle = "bundle"
+
+func init() {
+	Register(TypeBundle, func(spec domain.PromotionSpec) (domain.Promotion, error) {
+		var params struct {
+			Groups []BundleGroup `json:"groups"`
+			Price  int64         `json:"price"`
+		}
+		if err := decodeParams(spec, &params); err != nil {
+			return nil, err
+		}
+		return NewBundle(spec.ID, params.Groups, params.Price)
+	})
+}
+
+// BundleGroup is one part of a bundle: Quantity units taken from any of SKUs,
+// such as one of any sandwich. Name only documents the group.
+type BundleGroup struct {
+	Name     string   `json:"name,omitempty"`
+	SKUs     []string `json:"skus"`
+	Quantity int      `json:"quantity"`
+}
+
+// Bundle charges a fixed price for a set of units spanning several SKUs, such
+// as any sandwich, any drink and any snack for 300. It is configured with the
+// params {"groups", "price"}, where each group is {"name", "skus", "quantity"}
+// and quantity defaults to 1.
+//
+// The bundle's discount is shared between the SKUs in it in proportion to
+// their value, so each line reports its part of the saving.
+type Bundle struct {
+	id     string
+	groups []BundleGroup
+	price  int64
+}
+
+// NewBundle creates a bundle promotion charging price for quantity units of
+// every group.
+func NewBundle(id string, groups []BundleGroup, price int64) (*Bundle, error) {
+	if len(groups) == 0 {
+		return nil, errors.New("bundle needs at least one group")
+	}
+	if price < 0 {
+		return nil, errors.New("bundle price must not be negative")
+	}
+	normalised := make([]BundleGroup, len(groups))
+	for i, group := range groups {
+		if len(group.SKUs) == 0 {
+			return nil, fmt.Errorf("bundle group %d has no skus", i)
+		}
+		if group.Quantity < 0 {
+			return nil, fmt.Errorf("bundle group %d quantity must not be negative", i)
+		}
+		if group.Quantity == 0 {
+			group.Quantity = 1
+		}
+		group.SKUs = append([]string(nil), group.SKUs...)
+		sort.Strings(group.SKUs)
+		normalised[i] = group
+	}
+	return &Bundle{id: id, groups: normalised, price: price}, nil
+}
+
+func (b *Bundle) ID() string   { return b.id }
+func (b *Bundle) Type() string { return TypeBundle }
+
+// Deals returns every distinct way of filling the bundle's groups from basket,
+// most valuable first, so that ties go to the bundle saving the customer most
+// on dearer items.
+func (b *Bundle) Deals(basket domain.Basket) []domain.Deal {
+	type valued struct {
+		deal  domain.Deal
+		value int64
+	}
+	var found []valued
+	seen := make(map[string]bool)
+	taken := make(map[string]int)
+
+	var fill func(group, from, left int)
+	fill = func(group, from, left int) {
+		if left == 0 {
+			if group+1 < len(b.groups) {
+				fill(group+1, 0, b.groups[group+1].Quantity)
+				return
+			}
+			key := dealKey(taken)
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+			if deal, total, ok := b.deal(taken, basket.UnitPrices); ok {
+				found = append(found, valued{deal: deal, value: total})
+			}
+			return
+		}
+		skus := b.groups[group].SKUs
+		for i := from; i < len(skus); i++ {
+			sku := skus[i]
+			if basket.Items[sku]-taken[sku] <= 0 {
+				continue
+			}
+			taken[sku]++
+			fill(group, i, left-1)
+			taken[sku]--
+			if taken[sku] == 0 {
+				delete(taken, sku)
+			}
+		}
+	}
+	fill(0, 0, b.groups[0].Quantity)
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].value > found[j].value })
+	deals := make([]domain.Deal, len(found))
+	for i := range found {
+		deals[i] = found[i].deal
+	}
+	return deals
+}
+
+// deal prices one filling of the bundle, sharing its discount between the
+// SKUs in it in proportion to their value, and returns its undiscounted
+// value. Any remainder goes to the most valuable SKUs, one unit each. A
+// filling worth too much to represent is not offered.
+func (b *Bundle) deal(taken map[string]int, unitPrices map[string]int64) (domain.Deal, int64, bool) {
+	total, err := value(taken, unitPrices)
+	if err != nil || total <= b.price {
+		return domain.Deal{}, 0, false
+	}
+	discount := total - b.price
+
+	// Every SKU's part of the total is no more than the total, so working
+	// it out cannot overflow.
+	worth := func(sku string) int64 { return int64(taken[sku]) * unitPrices[sku] }
+
+	items := make(map[string]int, len(taken))
+	skus := make([]string, 0, len(taken))
+	for sku, count := range taken {
+		items[sku] = count
+		skus = append(skus, sku)
+	}
+	sort.Slice(skus, func(i, j int) bool {
+		vi, vj := worth(skus[i]), worth(skus[j])
+		if vi != vj {
+			return vi > vj
+		}
+		return skus[i] < skus[j]
+	})
+
+	discounts := make(map[string]int64, len(skus))
+	var shared int64
+	for _, sku := range skus {
+		discounts[sku] = mulDiv(discount, worth(sku), total)
+		shared += discounts[sku]
+	}
+	for i := 0; shared < discount; i++ {
+		discounts[skus[i%len(skus)]]++
+		shared++
+	}
+	return domain.Deal{Items: items, Discounts: discounts}, total, true
+}
+
+// value returns the undiscounted price of items, or domain.ErrOverflow if it
+// is too large to represent.
+func value(items map[string]int, unitPrices map[string]int64) (int64, error) {
+	total := domain.Money{}
+	for sku, count := range items {
+		worth, err := domain.Money{Amount: unitPrices[sku]}.Mul(int64(count))
+		if err == nil {
+			total, err = total.Add(worth)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total.Amount, nil
+}
+
+// mulDiv returns x*y/d rounded down, for non-negative x and y and positive d.
+// The product is worked out in full, so it cannot overflow when y is no
+// more than d, as when taking a share or percentage of x.
+func mulDiv(x, y, d int64) int64 {
+	result, _, _ := domain.Money{Amount: x}.MulDiv(y, d)
+	return result.Amount
+}
+
+// dealKey identifies a set of items independently of map ordering.
+func dealKey(items map[string]int) string {
+	skus := make([]string, 0, len(items))
+	for sku := range items {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	var key strings.Builder
+	for _, sku := range skus {
+		key.WriteString(sku)
+		key.WriteByte('=')
+		key.WriteString(strconv.Itoa(items[sku]))
+		key.WriteByte(';')
+	}
+	return key.String()
+}
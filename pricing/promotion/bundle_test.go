@@ -0,0 +1,128 @@
+package promotion
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+const mealDeal = `{
+	"groups": [
+		{"name": "sandwich", "skus": ["BLT", "EGG"]},
+		{"name": "drink", "skus": ["COLA", "WATER"]},
+		{"name": "snack", "skus": ["CRISPS"]}
+	],
+	"price": 300
+}`
+
+func TestBundle(t *testing.T) {
+	unitPrices := map[string]int64{"BLT": 250, "EGG": 200, "COLA": 120, "WATER": 80, "CRISPS": 90}
+
+	testCases := []struct {
+		name              string
+		items             map[string]int
+		expectedApplied   []domain.AppliedPromotion
+		expectedDiscounts map[string]int64
+	}{
+		{
+			name:              "Incomplete bundle is not applied",
+			items:             map[string]int{"BLT": 1, "COLA": 1},
+			expectedApplied:   []domain.AppliedPromotion{},
+			expectedDiscounts: map[string]int64{},
+		},
+		{
+			name:            "One bundle takes the dearest units",
+			items:           map[string]int{"BLT": 1, "EGG": 1, "COLA": 1, "WATER": 1, "CRISPS": 1},
+			expectedApplied: []domain.AppliedPromotion{{ID: "meal", Type: TypeBundle, Applications: 1, Savings: gbp(160)}},
+			// 160 shared in proportion to BLT 250, COLA 120 and CRISPS 90 out of 460,
+			// with the 2 left over from rounding down going to BLT and COLA.
+			expectedDiscounts: map[string]int64{"BLT": 87, "COLA": 42, "CRISPS": 31},
+		},
+		{
+			name:            "As many bundles as the basket fills",
+			items:           map[string]int{"BLT": 1, "EGG": 2, "COLA": 1, "WATER": 2, "CRISPS": 2},
+			expectedApplied: []domain.AppliedPromotion{{ID: "meal", Type: TypeBundle, Applications: 2, Savings: gbp(160 + 70)}},
+			expectedDiscounts: map[string]int64{
+				"BLT": 87, "COLA": 42, "CRISPS": 31 + 17,
+				"EGG": 38, "WATER": 15,
+			},
+		},
+		{
+			name:              "Bundle dearer than its items is not applied",
+			items:             map[string]int{"EGG": 1, "WATER": 1},
+			expectedApplied:   []domain.AppliedPromotion{},
+			expectedDiscounts: map[string]int64{},
+		},
+	}
+
+	bundle, err := Build(spec("meal", TypeBundle, mealDeal))
+	if err != nil {
+		t.Fatalf("Build() returned an unexpected error: %v", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Apply(domain.Basket{Items: tc.items, UnitPrices: unitPrices, Currency: "GBP"}, []domain.Promotion{bundle})
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Applied, tc.expectedApplied) {
+				t.Errorf("Expected applied %+v, got %+v", tc.expectedApplied, result.Applied)
+			}
+			if !reflect.DeepEqual(result.Discounts, tc.expectedDiscounts) {
+				t.Errorf("Expected discounts %+v, got %+v", tc.expectedDiscounts, result.Discounts)
+			}
+		})
+	}
+}
+
+func TestBundleOfDearItems(t *testing.T) {
+	bundle, err := Build(spec("pair", TypeBundle, `{"groups":[{"skus":["TV"]},{"skus":["SOUNDBAR"]}],"price":50000000000}`))
+	if err != nil {
+		t.Fatalf("Build() returned an unexpected error: %v", err)
+	}
+	basket := domain.Basket{Items: map[string]int{"TV": 1, "SOUNDBAR": 1}, UnitPrices: map[string]int64{"TV": 40000000000, "SOUNDBAR": 20000000000}}
+
+	// Sharing the discount of 1e10 multiplies it by each item's value, which
+	// is too large to represent on its own.
+	result, err := Apply(basket, []domain.Promotion{bundle})
+	if err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	expected := map[string]int64{"TV": 6666666667, "SOUNDBAR": 3333333333}
+	if !reflect.DeepEqual(result.Discounts, expected) {
+		t.Errorf("Expected discounts %+v, got %+v", expected, result.Discounts)
+	}
+}
+
+func TestBundleGroupQuantity(t *testing.T) {
+	bundle, err := Build(spec("two-drinks", TypeBundle, `{"groups":[{"skus":["COLA","WATER"],"quantity":2}],"price":150}`))
+	if err != nil {
+		t.Fatalf("Build() returned an unexpected error: %v", err)
+	}
+	basket := domain.Basket{Items: map[string]int{"COLA": 1, "WATER": 2}, UnitPrices: map[string]int64{"COLA": 120, "WATER": 80}}
+
+	deals := bundle.Deals(basket)
+	expected := []map[string]int{{"COLA": 1, "WATER": 1}, {"WATER": 2}}
+	if len(deals) != len(expected) {
+		t.Fatalf("Expected %d deals, got %d: %+v", len(expected), len(deals), deals)
+	}
+	for i, deal := range deals {
+		if !reflect.DeepEqual(deal.Items, expected[i]) {
+			t.Errorf("Deal %d: expected items %v, got %v", i, expected[i], deal.Items)
+		}
+	}
+}
+
+func TestNewBundleValidation(t *testing.T) {
+	for name, params := range map[string]string{
+		"no groups":         `{"groups":[],"price":100}`,
+		"group without sku": `{"groups":[{"skus":[]}],"price":100}`,
+		"negative quantity": `{"groups":[{"skus":["A"],"quantity":-1}],"price":100}`,
+		"negative price":    `{"groups":[{"skus":["A"]}],"price":-1}`,
+	} {
+		if _, err := Build(spec("x", TypeBundle, params)); err == nil {
+			t.Errorf("Expected an error building a bundle with %s, but got nil", name)
+		}
+	}
+}
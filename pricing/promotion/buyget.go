@@ -0,0 +1,95 @@
+package promotion
+
+import (
+	"errors"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// TypeBuyGet is the type name of BuyGet promotions.
+const TypeBuyGet = "buyget"
+
+func init() {
+	Register(TypeBuyGet, func(spec domain.PromotionSpec) (domain.Promotion, error) {
+		var params struct {
+			SKU        string `json:"sku"`
+			Buy        int    `json:"buy"`
+			GetSKU     string `json:"getSku"`
+			Get        int    `json:"get"`
+			PercentOff int    `json:"percentOff"`
+		}
+		if err := decodeParams(spec, &params); err != nil {
+			return nil, err
+		}
+		return NewBuyGet(spec.ID, params.SKU, params.Buy, params.GetSKU, params.Get, params.PercentOff)
+	})
+}
+
+// BuyGet discounts up to Get units of one SKU by a percentage for every Buy
+// units of another, or of the same SKU, such as buy one get one free or buy A
+// get C half price. It is configured with the params {"sku", "buy", "getSku",
+// "get", "percentOff"}, where getSku defaults to sku.
+//
+// Discounts are rounded down to the smallest currency unit.
+type BuyGet struct {
+	id         string
+	sku        string
+	buy        int
+	getSKU     string
+	get        int
+	percentOff int
+}
+
+// NewBuyGet creates a promotion discounting up to get units of getSKU by
+// percentOff percent for every buy units of sku. An empty getSKU is sku.
+func NewBuyGet(id, sku string, buy int, getSKU string, get, percentOff int) (*BuyGet, error) {
+	if sku == "" {
+		return nil, errors.New("buyget needs an sku")
+	}
+	if getSKU == "" {
+		getSKU = sku
+	}
+	if buy <= 0 || get <= 0 {
+		return nil, errors.New("buyget buy and get quantities must be positive")
+	}
+	if percentOff <= 0 || percentOff > 100 {
+		return nil, errors.New("buyget percentOff must be between 1 and 100")
+	}
+	return &BuyGet{id: id, sku: sku, buy: buy, getSKU: getSKU, get: get, percentOff: percentOff}, nil
+}
+
+// FromBuyGet re-expresses the buy-get offer of a pricing rule as a BuyGet
+// promotion on sku.
+func FromBuyGet(sku string, buyGet domain.BuyGet) (*BuyGet, error) {
+	return NewBuyGet(TypeBuyGet+"-"+sku, sku, buyGet.Buy, buyGet.GetSKU, buyGet.Get, buyGet.PercentOff)
+}
+
+func (b *BuyGet) ID() string   { return b.id }
+func (b *BuyGet) Type() string { return TypeBuyGet }
+
+// Deals takes Buy units of the SKU and up to Get discounted units, most
+// first. The discounted units do not have to be complete to apply.
+func (b *BuyGet) Deals(basket domain.Basket) []domain.Deal {
+	if basket.Items[b.sku] < b.buy {
+		return nil
+	}
+	available := basket.Items[b.getSKU]
+	if b.getSKU == b.sku {
+		available -= b.buy
+	}
+
+	var deals []domain.Deal
+	for discounted := min(b.get, available); discounted > 0; discounted-- {
+		items := map[string]int{b.sku: b.buy}
+		items[b.getSKU] += discounted
+		worth, err := domain.Money{Amount: basket.UnitPrices[b.getSKU]}.Mul(int64(discounted))
+		if err != nil {
+			continue
+		}
+		deals = append(deals, domain.Deal{
+			Items:     items,
+			Discounts: map[string]int64{b.getSKU: mulDiv(worth.Amount, int64(b.percentOff), 100)},
+		})
+	}
+	return deals
+}
@@ -0,0 +1,108 @@
+package promotion
+
+import (
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestBuyGet(t *testing.T) {
+	unitPrices := map[string]int64{"A": 50, "C": 20, "D": 15}
+
+	testCases := []struct {
+		name             string
+		spec             domain.PromotionSpec
+		items            map[string]int
+		expectedSavings  int
+		expectedApplies  int
+		expectedDiscount map[string]int64
+	}{
+		{
+			name:             "Buy one get one free",
+			spec:             spec("bogo", TypeBuyGet, `{"sku":"A","buy":1,"get":1,"percentOff":100}`),
+			items:            map[string]int{"A": 5},
+			expectedSavings:  100,
+			expectedApplies:  2,
+			expectedDiscount: map[string]int64{"A": 100},
+		},
+		{
+			name:             "Buy two get one free needs the third unit",
+			spec:             spec("b2g1", TypeBuyGet, `{"sku":"A","buy":2,"get":1,"percentOff":100}`),
+			items:            map[string]int{"A": 5},
+			expectedSavings:  50,
+			expectedApplies:  1,
+			expectedDiscount: map[string]int64{"A": 50},
+		},
+		{
+			name:             "Second half price rounds the discount down",
+			spec:             spec("half", TypeBuyGet, `{"sku":"D","buy":1,"get":1,"percentOff":50}`),
+			items:            map[string]int{"D": 2},
+			expectedSavings:  7,
+			expectedApplies:  1,
+			expectedDiscount: map[string]int64{"D": 7},
+		},
+		{
+			name:             "Buy A get C free",
+			spec:             spec("a-c", TypeBuyGet, `{"sku":"A","buy":1,"getSku":"C","get":1,"percentOff":100}`),
+			items:            map[string]int{"A": 2, "C": 3},
+			expectedSavings:  40,
+			expectedApplies:  2,
+			expectedDiscount: map[string]int64{"C": 40},
+		},
+		{
+			name:             "Cross-SKU offer applies to fewer discounted units than it allows",
+			spec:             spec("a-cc", TypeBuyGet, `{"sku":"A","buy":1,"getSku":"C","get":2,"percentOff":100}`),
+			items:            map[string]int{"A": 1, "C": 1},
+			expectedSavings:  20,
+			expectedApplies:  1,
+			expectedDiscount: map[string]int64{"C": 20},
+		},
+		{
+			name:             "Cross-SKU offer without the discounted SKU",
+			spec:             spec("a-c", TypeBuyGet, `{"sku":"A","buy":1,"getSku":"C","get":1,"percentOff":100}`),
+			items:            map[string]int{"A": 2},
+			expectedDiscount: map[string]int64{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			promotion, err := Build(tc.spec)
+			if err != nil {
+				t.Fatalf("Build() returned an unexpected error: %v", err)
+			}
+			result, err := Apply(domain.Basket{Items: tc.items, UnitPrices: unitPrices}, []domain.Promotion{promotion})
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+
+			savings, applies := 0, 0
+			for _, applied := range result.Applied {
+				savings += int(applied.Savings.Amount)
+				applies += applied.Applications
+			}
+			if savings != tc.expectedSavings || applies != tc.expectedApplies {
+				t.Errorf("Expected %d applications saving %d, got %d saving %d", tc.expectedApplies, tc.expectedSavings, applies, savings)
+			}
+			for sku, discount := range tc.expectedDiscount {
+				if result.Discounts[sku] != discount {
+					t.Errorf("Expected discount %d on %s, got %d", discount, sku, result.Discounts[sku])
+				}
+			}
+		})
+	}
+}
+
+func TestNewBuyGetValidation(t *testing.T) {
+	for name, params := range map[string]string{
+		"missing sku":      `{"buy":1,"get":1,"percentOff":100}`,
+		"zero buy":         `{"sku":"A","buy":0,"get":1,"percentOff":100}`,
+		"zero get":         `{"sku":"A","buy":1,"get":0,"percentOff":100}`,
+		"zero percent":     `{"sku":"A","buy":1,"get":1,"percentOff":0}`,
+		"over 100 percent": `{"sku":"A","buy":1,"get":1,"percentOff":150}`,
+	} {
+		if _, err := Build(spec("x", TypeBuyGet, params)); err == nil {
+			t.Errorf("Expected an error building a buyget with %s, but got nil", name)
+		}
+	}
+}
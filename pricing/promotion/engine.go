@@ -0,0 +1,449 @@
+package promotion
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// Result is the outcome of applying promotions to a basket.
+type Result struct {
+	// Discounts is the total discount given on each SKU.
+	Discounts map[string]int64
+	// Applications counts the deals that discounted units of each SKU.
+	Applications map[string]int
+	// Applied reports each promotion that was applied, in the order the
+	// promotions were given.
+	Applied []domain.AppliedPromotion
+}
+
+// searchLimit caps the basket states explored when resolving one group of
+// overlapping promotions. A group that needs more falls back to applying its
+// promotions greedily, so that a pathological configuration cannot stall pricing.
+const searchLimit = 200000
+
+// Apply finds the deals that save the customer the most on basket. A unit
+// can only be taken by one deal, so when promotions overlap the resolver
+// searches for the combination of deals with the largest total discount
+// rather than taking the best deal first.
+//
+// Promotions with a higher priority are resolved first, and those of a lower
+// priority only see the units left over. An exclusive promotion is never
+// combined with another: it is applied on its own if that saves more than
+// every other promotion together. Ties are broken in favour of the
+// promotions given first, so the same basket is always priced the same way.
+//
+// Apply returns domain.ErrOverflow if the basket is worth too much to
+// represent, every unit at its unit price, so that promotions can work out
+// the value of any of its units without overflowing. Deals that discount an
+// SKU by less than nothing or by more than the units they take of it are
+// worth are ignored, so no total of discounts can overflow either.
+func Apply(basket domain.Basket, promotions []domain.Promotion) (Result, error) {
+	if err := checkValue(basket); err != nil {
+		return Result{}, err
+	}
+	var shared []int
+	var exclusive []int
+	for i, promotion := range promotions {
+		if IsExclusive(promotion) {
+			exclusive = append(exclusive, i)
+		} else {
+			shared = append(shared, i)
+		}
+	}
+
+	best := resolve(basket, promotions, shared)
+	for _, i := range exclusive {
+		if alone := resolve(basket, promotions, []int{i}); alone.savings() > best.savings() {
+			best = alone
+		}
+	}
+	return best.result(promotions, basket.Currency), nil
+}
+
+// checkValue returns domain.ErrOverflow if the units in basket are worth too
+// much to represent at their unit prices.
+func checkValue(basket domain.Basket) error {
+	total := domain.Money{Currency: basket.Currency}
+	for sku, count := range basket.Items {
+		unitPrice := domain.Money{Amount: basket.UnitPrices[sku], Currency: basket.Currency}
+		value, err := unitPrice.Mul(int64(count))
+		if err == nil {
+			total, err = total.Add(value)
+		}
+		if err != nil {
+			return fmt.Errorf("sku '%s' %w", sku, err)
+		}
+	}
+	return nil
+}
+
+// PriorityOf returns the priority of a promotion, or zero if it has none.
+func PriorityOf(promotion domain.Promotion) int {
+	if p, ok := promotion.(interface{ Priority() int }); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// IsExclusive reports whether a promotion must not be combined with others.
+func IsExclusive(promotion domain.Promotion) bool {
+	if e, ok := promotion.(interface{ Exclusive() bool }); ok {
+		return e.Exclusive()
+	}
+	return false
+}
+
+// application is one deal of the promotion at an index of the promotions
+// given to Apply, with the total discount it gives.
+type application struct {
+	promotion int
+	deal      domain.Deal
+	discount  int64
+}
+
+// plan is the set of deals chosen for a basket. Its deals all fit in the
+// basket and take none of the same units, so its savings are no more than
+// the basket is worth and cannot overflow.
+type plan []application
+
+func (p plan) savings() int64 {
+	var total int64
+	for _, applied := range p {
+		total += applied.discount
+	}
+	return total
+}
+
+// result summarises the plan per SKU and per promotion, with savings in
+// currency.
+func (p plan) result(promotions []domain.Promotion, currency string) Result {
+	result := Result{
+		Discounts:    make(map[string]int64),
+		Applications: make(map[string]int),
+		Applied:      []domain.AppliedPromotion{},
+	}
+	applied := make([]domain.AppliedPromotion, len(promotions))
+	for _, application := range p {
+		for sku := range application.deal.Items {
+			result.Applications[sku]++
+		}
+		for sku, discount := range application.deal.Discounts {
+			result.Discounts[sku] += discount
+		}
+		applied[application.promotion].Applications++
+		applied[application.promotion].Savings.Amount += application.discount
+	}
+	for i, promotion := range promotions {
+		if applied[i].Applications > 0 {
+			applied[i].Savings.Currency = currency
+			applied[i].ID = promotion.ID()
+			applied[i].Type = promotion.Type()
+			result.Applied = append(result.Applied, applied[i])
+		}
+	}
+	return result
+}
+
+// resolve plans the promotions at indexes for basket, a priority at a time.
+func resolve(basket domain.Basket, promotions []domain.Promotion, indexes []int) (chosen plan) {
+	remaining := domain.Basket{Items: make(map[string]int, len(basket.Items)), UnitPrices: basket.UnitPrices}
+	for sku, count := range basket.Items {
+		remaining.Items[sku] = count
+	}
+	ordered := append([]int(nil), indexes...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return PriorityOf(promotions[ordered[i]]) > PriorityOf(promotions[ordered[j]])
+	})
+
+	for start := 0; start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && PriorityOf(promotions[ordered[end]]) == PriorityOf(promotions[ordered[start]]) {
+			end++
+		}
+		for _, group := range overlapping(remaining, promotions, ordered[start:end]) {
+			tier := group.search(remaining, promotions)
+			for _, application := range tier {
+				for sku, count := range application.deal.Items {
+					remaining.Items[sku] -= count
+				}
+			}
+			chosen = append(chosen, tier...)
+		}
+		start = end
+	}
+	return chosen
+}
+
+// shape is a deal of one promotion, with the units it takes as counts at
+// positions in its group's SKUs.
+type shape struct {
+	application
+	units []int // Alternating positions and counts
+}
+
+// promotionGroup is a set of promotions that compete for the same SKUs, with
+// every SKU any of them can take and every deal they offer on the basket.
+type promotionGroup struct {
+	indexes []int
+	skus    []string
+	shapes  []shape
+}
+
+// overlapping splits promotions into groups that share no SKUs, so that each
+// group can be resolved on its own. A promotion's SKUs are those of all its
+// deals, so two promotions are grouped together if any of their deals share
+// an SKU. Promotions that cannot be applied to basket are left out.
+func overlapping(basket domain.Basket, promotions []domain.Promotion, indexes []int) []promotionGroup {
+	parent := make(map[string]string)
+	var find func(sku string) string
+	find = func(sku string) string {
+		if parent[sku] != sku {
+			parent[sku] = find(parent[sku])
+		}
+		return parent[sku]
+	}
+
+	deals := make(map[int][]application, len(indexes))
+	for _, i := range indexes {
+		var first string
+		for _, deal := range promotions[i].Deals(basket) {
+			discount, ok := usable(deal, basket)
+			if !ok {
+				continue
+			}
+			deals[i] = append(deals[i], application{promotion: i, deal: deal, discount: discount})
+			for sku := range deal.Items {
+				if _, ok := parent[sku]; !ok {
+					parent[sku] = sku
+				}
+				if first == "" {
+					first = sku
+				}
+				parent[find(sku)] = find(first)
+			}
+		}
+	}
+
+	var groups []promotionGroup
+	byRoot := make(map[string]int)
+	for _, i := range indexes {
+		if len(deals[i]) == 0 {
+			continue
+		}
+		var root string
+		for sku := range deals[i][0].deal.Items {
+			root = find(sku)
+			break
+		}
+		g, ok := byRoot[root]
+		if !ok {
+			g = len(groups)
+			byRoot[root] = g
+			groups = append(groups, promotionGroup{})
+		}
+		groups[g].indexes = append(groups[g].indexes, i)
+		for _, application := range deals[i] {
+			for sku := range application.deal.Items {
+				groups[g].skus = append(groups[g].skus, sku)
+			}
+		}
+	}
+
+	for g := range groups {
+		group := &groups[g]
+		sort.Strings(group.skus)
+		group.skus = slices.Compact(group.skus)
+		// Searching the SKUs fewest deals can take first keeps the number of
+		// basket states reached down.
+		taking := make(map[string]int, len(group.skus))
+		for _, i := range group.indexes {
+			for _, application := range deals[i] {
+				for sku := range application.deal.Items {
+					taking[sku]++
+				}
+			}
+		}
+		sort.SliceStable(group.skus, func(a, b int) bool { return taking[group.skus[a]] < taking[group.skus[b]] })
+		position := make(map[string]int, len(group.skus))
+		for p, sku := range group.skus {
+			position[sku] = p
+		}
+		for _, i := range group.indexes {
+			for _, application := range deals[i] {
+				shape := shape{application: application}
+				for sku, count := range application.deal.Items {
+					shape.units = append(shape.units, position[sku], count)
+				}
+				group.shapes = append(group.shapes, shape)
+			}
+		}
+	}
+	return groups
+}
+
+// searchState is the best way found to finish from one basket state.
+type searchState struct {
+	savings int64
+	shape   int // Index of the shape applied next, or -1 to leave units unused
+	next    string
+}
+
+// search finds the deals of the group that save the most on basket. It walks
+// the group's SKUs in turn and, for the first SKU with units left, either
+// applies a deal that takes it or leaves its units unused, remembering the
+// best outcome from every basket state it reaches.
+func (g promotionGroup) search(basket domain.Basket, promotions []domain.Promotion) plan {
+	taking := make([][]int, len(g.skus))
+	for i, shape := range g.shapes {
+		for u := 0; u < len(shape.units); u += 2 {
+			taking[shape.units[u]] = append(taking[shape.units[u]], i)
+		}
+	}
+
+	memo := make(map[string]*searchState)
+	exceeded := false
+	var best func(counts []int) string
+	best = func(counts []int) string {
+		key := stateKey(counts)
+		if _, done := memo[key]; done || exceeded {
+			return key
+		}
+		if len(memo) >= searchLimit {
+			exceeded = true
+			return key
+		}
+		state := &searchState{shape: -1}
+		memo[key] = state
+
+		first := 0
+		for first < len(counts) && counts[first] == 0 {
+			first++
+		}
+		if first == len(counts) {
+			return key
+		}
+
+		for _, i := range taking[first] {
+			units := g.shapes[i].units
+			next := append([]int(nil), counts...)
+			fits := true
+			for u := 0; u < len(units); u += 2 {
+				next[units[u]] -= units[u+1]
+				fits = fits && next[units[u]] >= 0
+			}
+			if !fits {
+				continue
+			}
+			nextKey := best(next)
+			if exceeded {
+				return key
+			}
+			if savings := g.shapes[i].discount + memo[nextKey].savings; savings > state.savings {
+				*state = searchState{savings: savings, shape: i, next: nextKey}
+			}
+		}
+
+		skipped := append([]int(nil), counts...)
+		skipped[first] = 0
+		skippedKey := best(skipped)
+		if exceeded {
+			return key
+		}
+		if memo[skippedKey].savings > state.savings || state.shape < 0 {
+			*state = searchState{savings: memo[skippedKey].savings, shape: -1, next: skippedKey}
+		}
+		return key
+	}
+
+	counts := make([]int, len(g.skus))
+	for i, sku := range g.skus {
+		counts[i] = basket.Items[sku]
+	}
+	key := best(counts)
+	if exceeded {
+		return greedy(basket, promotions, g.indexes)
+	}
+
+	var chosen plan
+	for state := memo[key]; state.next != ""; state = memo[state.next] {
+		if state.shape >= 0 {
+			chosen = append(chosen, g.shapes[state.shape].application)
+		}
+	}
+	return chosen
+}
+
+// greedy applies promotions in turn, each as many times as it can be, taking
+// the deal with the largest discount each time.
+func greedy(basket domain.Basket, promotions []domain.Promotion, indexes []int) (chosen plan) {
+	remaining := domain.Basket{Items: make(map[string]int, len(basket.Items)), UnitPrices: basket.UnitPrices}
+	for sku, count := range basket.Items {
+		remaining.Items[sku] = count
+	}
+	for _, i := range indexes {
+		for {
+			best, ok := bestDeal(i, promotions[i].Deals(remaining), remaining)
+			if !ok {
+				break
+			}
+			for sku, count := range best.deal.Items {
+				remaining.Items[sku] -= count
+			}
+			chosen = append(chosen, best)
+		}
+	}
+	return chosen
+}
+
+// bestDeal returns the first of the deals of the promotion at an index with
+// the largest positive discount that only takes units in basket.
+func bestDeal(promotion int, deals []domain.Deal, basket domain.Basket) (best application, ok bool) {
+	for _, deal := range deals {
+		discount, usable := usable(deal, basket)
+		if usable && (!ok || discount > best.discount) {
+			best, ok = application{promotion: promotion, deal: deal, discount: discount}, true
+		}
+	}
+	return best, ok
+}
+
+// usable returns the total discount of a deal that fits in basket, and
+// whether it fits and gives a positive discount.
+func usable(deal domain.Deal, basket domain.Basket) (int64, bool) {
+	if !fits(deal, basket) {
+		return 0, false
+	}
+	discount, err := deal.Discount()
+	return discount, err == nil && discount > 0
+}
+
+// fits reports whether every unit a deal takes is in basket, and whether it
+// discounts each SKU by no less than nothing and no more than the units it
+// takes of it are worth.
+func fits(deal domain.Deal, basket domain.Basket) bool {
+	for sku, count := range deal.Items {
+		if count <= 0 || basket.Items[sku] < count {
+			return false
+		}
+	}
+	for sku, discount := range deal.Discounts {
+		worth, err := domain.Money{Amount: basket.UnitPrices[sku]}.Mul(int64(deal.Items[sku]))
+		if deal.Items[sku] == 0 || discount < 0 || err != nil || discount > worth.Amount {
+			return false
+		}
+	}
+	return true
+}
+
+// stateKey encodes the unit counts of a basket state as a map key.
+func stateKey(counts []int) string {
+	key := make([]byte, 0, len(counts)*2)
+	for _, count := range counts {
+		key = binary.AppendUvarint(key, uint64(count))
+	}
+	return string(key)
+}
@@ -0,0 +1,379 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func spec(id, promotionType, params string) domain.PromotionSpec {
+	return domain.PromotionSpec{ID: id, Type: promotionType, Params: json.RawMessage(params)}
+}
+
+func TestBuild(t *testing.T) {
+	testCases := []struct {
+		name    string
+		spec    domain.PromotionSpec
+		wantErr bool
+	}{
+		{name: "valid multibuy", spec: spec("a3", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130}`)},
+		{name: "missing id", spec: spec("", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130}`), wantErr: true},
+		{name: "missing params", spec: spec("a3", TypeMultiBuy, ``), wantErr: true},
+		{name: "unknown param", spec: spec("a3", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130,"free":true}`), wantErr: true},
+		{name: "zero quantity", spec: spec("a3", TypeMultiBuy, `{"sku":"A","quantity":0,"price":130}`), wantErr: true},
+		{name: "negative price", spec: spec("a3", TypeMultiBuy, `{"sku":"A","quantity":3,"price":-1}`), wantErr: true},
+		{name: "missing sku", spec: spec("a3", TypeMultiBuy, `{"quantity":3,"price":130}`), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			promotion, err := Build(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() returned an unexpected error: %v", err)
+			}
+			if promotion.ID() != tc.spec.ID || promotion.Type() != tc.spec.Type {
+				t.Errorf("Expected promotion %s of type %s, got %s of type %s", tc.spec.ID, tc.spec.Type, promotion.ID(), promotion.Type())
+			}
+		})
+	}
+
+	if _, err := Build(spec("x", "mystery", `{}`)); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Expected error %v building an unregistered type, got %v", ErrUnknownType, err)
+	}
+}
+
+func TestBuildAllRejectsDuplicateIDs(t *testing.T) {
+	specs := []domain.PromotionSpec{
+		spec("dup", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130}`),
+		spec("dup", TypeMultiBuy, `{"sku":"B","quantity":2,"price":45}`),
+	}
+	if _, err := BuildAll(specs); err == nil {
+		t.Error("Expected an error building promotions with duplicate ids, but got nil")
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Register() to panic on a duplicate type name")
+		}
+	}()
+	Register(TypeMultiBuy, nil)
+}
+
+func TestApply(t *testing.T) {
+	a3, _ := NewMultiBuy("a3", "A", 3, 130)
+	a2, _ := NewMultiBuy("a2", "A", 2, 90)
+	b2, _ := NewMultiBuy("b2", "B", 2, 45)
+	dearer, _ := NewMultiBuy("dearer", "C", 2, 50)
+
+	testCases := []struct {
+		name       string
+		items      map[string]int
+		promotions []domain.Promotion
+		expected   Result
+	}{
+		{
+			name:       "No promotions",
+			items:      map[string]int{"A": 3},
+			promotions: nil,
+			expected:   Result{Discounts: map[string]int64{}, Applications: map[string]int{}, Applied: []domain.AppliedPromotion{}},
+		},
+		{
+			name:       "Multibuy applied as often as it fits",
+			items:      map[string]int{"A": 7, "B": 3},
+			promotions: []domain.Promotion{a3, b2},
+			expected: Result{
+				Discounts:    map[string]int64{"A": 40, "B": 15},
+				Applications: map[string]int{"A": 2, "B": 1},
+				Applied: []domain.AppliedPromotion{
+					{ID: "a3", Type: TypeMultiBuy, Applications: 2, Savings: gbp(40)},
+					{ID: "b2", Type: TypeMultiBuy, Applications: 1, Savings: gbp(15)},
+				},
+			},
+		},
+		{
+			name:       "Ties go to the promotions given first",
+			items:      map[string]int{"A": 4},
+			promotions: []domain.Promotion{a3, a2},
+			expected: Result{
+				Discounts:    map[string]int64{"A": 20},
+				Applications: map[string]int{"A": 1},
+				Applied:      []domain.AppliedPromotion{{ID: "a3", Type: TypeMultiBuy, Applications: 1, Savings: gbp(20)}},
+			},
+		},
+		{
+			name:       "Promotion dearer than unit price is never applied",
+			items:      map[string]int{"C": 2},
+			promotions: []domain.Promotion{dearer},
+			expected:   Result{Discounts: map[string]int64{}, Applications: map[string]int{}, Applied: []domain.AppliedPromotion{}},
+		},
+	}
+
+	unitPrices := map[string]int64{"A": 50, "B": 30, "C": 20}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			basket := domain.Basket{Items: tc.items, UnitPrices: unitPrices, Currency: "GBP"}
+			result, err := Apply(basket, tc.promotions)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestApplyLeavesBasketUnchanged(t *testing.T) {
+	a3, _ := NewMultiBuy("a3", "A", 3, 130)
+	items := map[string]int{"A": 3}
+	if _, err := Apply(domain.Basket{Items: items, UnitPrices: map[string]int64{"A": 50}}, []domain.Promotion{a3}); err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	if items["A"] != 3 {
+		t.Errorf("Expected basket to keep 3 units of A, got %d", items["A"])
+	}
+}
+
+func TestApplyResolvesOverlappingPromotions(t *testing.T) {
+	build := func(specs ...domain.PromotionSpec) []domain.Promotion {
+		t.Helper()
+		promotions, err := BuildAll(specs)
+		if err != nil {
+			t.Fatalf("BuildAll() returned an unexpected error: %v", err)
+		}
+		return promotions
+	}
+	unitPrices := map[string]int64{"A": 50, "B": 30, "X": 100, "Y": 50, "S1": 100, "S2": 100}
+	a3 := spec("a3", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130}`)
+	ab := spec("ab", TypeBundle, `{"groups":[{"skus":["A"]},{"skus":["B"]}],"price":60}`)
+
+	testCases := []struct {
+		name       string
+		items      map[string]int
+		promotions []domain.Promotion
+		expected   []domain.AppliedPromotion
+	}{
+		{
+			// Taking the multi-buy first would leave one A and three Bs, saving 20 + 20.
+			name:       "Cheapest combination beats the best deal first",
+			items:      map[string]int{"A": 4, "B": 4},
+			promotions: build(a3, ab),
+			expected:   []domain.AppliedPromotion{{ID: "ab", Type: TypeBundle, Applications: 4, Savings: gbp(80)}},
+		},
+		{
+			name:       "Deals combine where units allow",
+			items:      map[string]int{"A": 4, "B": 1},
+			promotions: build(a3, ab),
+			expected: []domain.AppliedPromotion{
+				{ID: "a3", Type: TypeMultiBuy, Applications: 1, Savings: gbp(20)},
+				{ID: "ab", Type: TypeBundle, Applications: 1, Savings: gbp(20)},
+			},
+		},
+		{
+			name:  "Higher priority takes units first",
+			items: map[string]int{"A": 4, "B": 4},
+			promotions: build(
+				domain.PromotionSpec{ID: a3.ID, Type: a3.Type, Params: a3.Params, Priority: 1},
+				ab,
+			),
+			expected: []domain.AppliedPromotion{
+				{ID: "a3", Type: TypeMultiBuy, Applications: 1, Savings: gbp(20)},
+				{ID: "ab", Type: TypeBundle, Applications: 1, Savings: gbp(20)},
+			},
+		},
+		{
+			name:  "Exclusive promotion applies alone when it saves more",
+			items: map[string]int{"A": 3, "B": 2},
+			promotions: build(
+				spec("b2", TypeMultiBuy, `{"sku":"B","quantity":2,"price":45}`),
+				domain.PromotionSpec{ID: "half", Type: TypeBuyGet, Params: json.RawMessage(`{"sku":"A","buy":1,"get":1,"percentOff":100}`), Exclusive: true},
+			),
+			expected: []domain.AppliedPromotion{{ID: "half", Type: TypeBuyGet, Applications: 1, Savings: gbp(50)}},
+		},
+		{
+			name:  "Exclusive promotion is dropped when the others save more",
+			items: map[string]int{"A": 3, "B": 8},
+			promotions: build(
+				spec("b2", TypeMultiBuy, `{"sku":"B","quantity":2,"price":45}`),
+				domain.PromotionSpec{ID: "half", Type: TypeBuyGet, Params: json.RawMessage(`{"sku":"A","buy":1,"get":1,"percentOff":100}`), Exclusive: true},
+			),
+			expected: []domain.AppliedPromotion{{ID: "b2", Type: TypeMultiBuy, Applications: 4, Savings: gbp(60)}},
+		},
+		{
+			// Filling the first group with the dearer X saves 110 but leaves no X
+			// for a second bundle.
+			name:       "Bundle groups sharing SKUs are filled to form the most bundles",
+			items:      map[string]int{"X": 2, "Y": 2},
+			promotions: build(spec("xy", TypeBundle, `{"groups":[{"skus":["X","Y"]},{"skus":["X"]}],"price":90}`)),
+			expected:   []domain.AppliedPromotion{{ID: "xy", Type: TypeBundle, Applications: 2, Savings: gbp(120)}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := Apply(domain.Basket{Items: tc.items, UnitPrices: unitPrices, Currency: "GBP"}, tc.promotions)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Applied, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, result.Applied)
+			}
+		})
+	}
+
+	// The bundle's deals cover S1 and S2 separately, but it competes with the
+	// multi-buy for S2 either way, so the two must be resolved together.
+	anyOf := spec("any", TypeBundle, `{"groups":[{"skus":["S1","S2"]}],"price":60}`)
+	s2 := spec("s2", TypeMultiBuy, `{"sku":"S2","quantity":2,"price":100}`)
+	basket := domain.Basket{Items: map[string]int{"S1": 1, "S2": 2}, UnitPrices: unitPrices}
+	for _, promotions := range [][]domain.Promotion{build(anyOf, s2), build(s2, anyOf)} {
+		result, err := Apply(basket, promotions)
+		if err != nil {
+			t.Fatalf("Apply() returned an unexpected error: %v", err)
+		}
+		savings := int64(0)
+		for _, applied := range result.Applied {
+			savings += applied.Savings.Amount
+		}
+		if savings != 140 {
+			t.Errorf("Expected the multi-buy on S2 and the bundle on S1 to save 140, got %+v", result.Applied)
+		}
+	}
+}
+
+func TestApplyOverflow(t *testing.T) {
+	a3, _ := NewMultiBuy("a3", "A", 3, 130)
+	basket := domain.Basket{Items: map[string]int{"A": 3}, UnitPrices: map[string]int64{"A": math.MaxInt64 / 2}, Currency: "GBP"}
+	if _, err := Apply(basket, []domain.Promotion{a3}); !errors.Is(err, domain.ErrOverflow) {
+		t.Errorf("Expected error %v applying promotions to a basket worth too much, got %v", domain.ErrOverflow, err)
+	}
+}
+
+func TestDealsDoNotOverflow(t *testing.T) {
+	basket := domain.Basket{Items: map[string]int{"A": 3, "B": 1}, UnitPrices: map[string]int64{"A": math.MaxInt64/2 + 1, "B": 50}}
+	a3, _ := NewMultiBuy("a3", "A", 3, 130)
+	tiered, _ := NewTiered("tiered", "A", domain.TieredPrice{Mode: domain.TierAllUnits, Tiers: []domain.PriceTier{{MinQuantity: 2, UnitPrice: gbp(0)}}})
+	bundle, _ := NewBundle("pair", []BundleGroup{{SKUs: []string{"A"}, Quantity: 3}, {SKUs: []string{"B"}}}, 100)
+	for _, promotion := range []domain.Promotion{a3, tiered, bundle} {
+		if deals := promotion.Deals(basket); len(deals) != 0 {
+			t.Errorf("Expected %s to offer no deal on units worth too much to represent, got %+v", promotion.ID(), deals)
+		}
+	}
+
+	// Only the deals of a buy-get that can be represented are offered.
+	bogo, _ := NewBuyGet("bogo", "B", 1, "A", 2, 100)
+	if deals := bogo.Deals(basket); len(deals) != 1 || deals[0].Discounts["A"] != math.MaxInt64/2+1 {
+		t.Errorf("Expected one deal discounting a single A, got %+v", deals)
+	}
+
+	deal := domain.Deal{Discounts: map[string]int64{"A": math.MaxInt64, "B": 1}}
+	if _, err := deal.Discount(); !errors.Is(err, domain.ErrOverflow) {
+		t.Errorf("Expected error %v totalling a deal's discounts, got %v", domain.ErrOverflow, err)
+	}
+}
+
+// fixedDiscount offers one deal taking the units of an SKU with a fixed
+// discount, however little they are worth.
+type fixedDiscount struct {
+	sku      string
+	discount int64
+}
+
+func (f fixedDiscount) ID() string   { return "fixed" }
+func (f fixedDiscount) Type() string { return "fixed" }
+func (f fixedDiscount) Deals(basket domain.Basket) []domain.Deal {
+	return []domain.Deal{{Items: map[string]int{f.sku: 1}, Discounts: map[string]int64{f.sku: f.discount}}}
+}
+
+func TestApplyIgnoresDealsWorthMoreThanTheirUnits(t *testing.T) {
+	basket := domain.Basket{Items: map[string]int{"A": 1}, UnitPrices: map[string]int64{"A": 50}, Currency: "GBP"}
+	result, err := Apply(basket, []domain.Promotion{fixedDiscount{sku: "A", discount: math.MaxInt64}})
+	if err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	if len(result.Applied) != 0 {
+		t.Errorf("Expected a deal discounting more than its units are worth to be ignored, got %+v", result.Applied)
+	}
+}
+
+func TestApplyIsDeterministic(t *testing.T) {
+	promotions, basket := benchmarkBasket(200)
+	first, _ := Apply(basket, promotions)
+	for range 20 {
+		if result, _ := Apply(basket, promotions); !reflect.DeepEqual(result, first) {
+			t.Fatalf("Expected every run to give %+v, got %+v", first, result)
+		}
+	}
+}
+
+// benchmarkBasket builds a basket of size units spread over twenty SKUs, with
+// multi-buys, buy-gets and a meal deal competing for most of them.
+func benchmarkBasket(size int) ([]domain.Promotion, domain.Basket) {
+	return benchmarkPromotions(), spreadBasket(size, 20)
+}
+
+// spreadBasket builds a basket of size units spread evenly over skus SKUs.
+func spreadBasket(size, skus int) domain.Basket {
+	basket := domain.Basket{Items: make(map[string]int), UnitPrices: make(map[string]int64)}
+	for i := range size {
+		sku := fmt.Sprintf("SKU%02d", i%skus)
+		basket.Items[sku]++
+		basket.UnitPrices[sku] = int64(40 + (i%skus)*15)
+	}
+	return basket
+}
+
+func benchmarkPromotions() []domain.Promotion {
+	specs := []domain.PromotionSpec{
+		spec("m0", TypeMultiBuy, `{"sku":"SKU00","quantity":3,"price":100}`),
+		spec("m1", TypeMultiBuy, `{"sku":"SKU01","quantity":2,"price":90}`),
+		spec("m5", TypeMultiBuy, `{"sku":"SKU05","quantity":4,"price":350}`),
+		spec("g2", TypeBuyGet, `{"sku":"SKU02","buy":2,"get":1,"percentOff":100}`),
+		spec("g3", TypeBuyGet, `{"sku":"SKU03","buy":1,"getSku":"SKU04","get":1,"percentOff":50}`),
+		spec("meal", TypeBundle, `{"groups":[
+			{"skus":["SKU00","SKU01","SKU06"]},
+			{"skus":["SKU07","SKU08"]},
+			{"skus":["SKU09","SKU02"]}
+		],"price":250}`),
+	}
+	promotions, err := BuildAll(specs)
+	if err != nil {
+		panic(err)
+	}
+	return promotions
+}
+
+// BenchmarkApply prices baskets where every SKU has ten units at 200 items,
+// so the meal deal and the offers it overlaps compete for many units each.
+func BenchmarkApply(b *testing.B) {
+	for _, size := range []int{20, 50, 200} {
+		promotions, basket := benchmarkBasket(size)
+		b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+			for b.Loop() {
+				Apply(basket, promotions)
+			}
+		})
+	}
+}
+
+// BenchmarkApplyWideBasket prices 200 items spread over eighty SKUs, closer
+// to a real weekly shop.
+func BenchmarkApplyWideBasket(b *testing.B) {
+	promotions, basket := benchmarkPromotions(), spreadBasket(200, 80)
+	for b.Loop() {
+		Apply(basket, promotions)
+	}
+}
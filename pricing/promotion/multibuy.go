@@ -0,0 +1,71 @@
+package promotion
+
+import (
+	"errors"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// TypeMultiBuy is the type name of MultiBuy promotions.
+const TypeMultiBuy = "multibuy"
+
+func init() {
+	Register(TypeMultiBuy, func(spec domain.PromotionSpec) (domain.Promotion, error) {
+		var params struct {
+			SKU      string `json:"sku"`
+			Quantity int    `json:"quantity"`
+			Price    int64  `json:"price"`
+		}
+		if err := decodeParams(spec, &params); err != nil {
+			return nil, err
+		}
+		return NewMultiBuy(spec.ID, params.SKU, params.Quantity, params.Price)
+	})
+}
+
+// MultiBuy charges a fixed price for every Quantity units of an SKU, such as
+// 3 for 130. It is configured with the params {"sku", "quantity", "price"}.
+type MultiBuy struct {
+	id       string
+	sku      string
+	quantity int
+	price    int64
+}
+
+// NewMultiBuy creates a multi-buy promotion charging price for every quantity
+// units of sku.
+func NewMultiBuy(id, sku string, quantity int, price int64) (*MultiBuy, error) {
+	if sku == "" {
+		return nil, errors.New("multibuy needs an sku")
+	}
+	if quantity <= 0 {
+		return nil, errors.New("multibuy quantity must be positive")
+	}
+	if price < 0 {
+		return nil, errors.New("multibuy price must not be negative")
+	}
+	return &MultiBuy{id: id, sku: sku, quantity: quantity, price: price}, nil
+}
+
+// FromSpecialPrice re-expresses the special price of a pricing rule as a
+// multi-buy promotion on sku.
+func FromSpecialPrice(sku string, special domain.SpecialPrice) (*MultiBuy, error) {
+	return NewMultiBuy(TypeMultiBuy+"-"+sku, sku, special.Quantity, special.Price.Amount)
+}
+
+func (m *MultiBuy) ID() string   { return m.id }
+func (m *MultiBuy) Type() string { return TypeMultiBuy }
+
+func (m *MultiBuy) Deals(basket domain.Basket) []domain.Deal {
+	if basket.Items[m.sku] < m.quantity {
+		return nil
+	}
+	worth, err := domain.Money{Amount: basket.UnitPrices[m.sku]}.Mul(int64(m.quantity))
+	if err != nil || worth.Amount <= m.price {
+		return nil
+	}
+	return []domain.Deal{{
+		Items:     map[string]int{m.sku: m.quantity},
+		Discounts: map[string]int64{m.sku: worth.Amount - m.price},
+	}}
+}
@@ -0,0 +1,126 @@
+// Package promotion holds the promotion types that can be configured in a
+// price list and the engine that applies them to a basket.
+package promotion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// ErrUnknownType is returned when building a promotion of a type that has not
+// been registered.
+var ErrUnknownType = errors.New("unknown promotion type")
+
+// Factory builds a promotion from its configuration.
+type Factory func(spec domain.PromotionSpec) (domain.Promotion, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a promotion type available under name. It panics if the name
+// is already registered, so types are expected to register in an init function.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("promotion type '%s' registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Types returns the names of the registered promotion types, sorted.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build creates the promotion configured by spec with the factory registered
+// for its type. The promotion reports the spec's priority, exclusivity and
+// schedule to PriorityOf, IsExclusive and IsActive.
+func Build(spec domain.PromotionSpec) (domain.Promotion, error) {
+	if spec.ID == "" {
+		return nil, errors.New("promotion has no id")
+	}
+	registryMu.RLock()
+	factory, exists := registry[spec.Type]
+	registryMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("promotion '%s': %w '%s'", spec.ID, ErrUnknownType, spec.Type)
+	}
+	promotion, err := factory(spec)
+	if err != nil {
+		return nil, fmt.Errorf("promotion '%s': %w", spec.ID, err)
+	}
+	var schedule *Schedule
+	if spec.Schedule != nil {
+		if schedule, err = NewSchedule(*spec.Schedule); err != nil {
+			return nil, fmt.Errorf("promotion '%s': %w", spec.ID, err)
+		}
+	}
+	if spec.Priority != 0 || spec.Exclusive || schedule != nil {
+		return configured{Promotion: promotion, priority: spec.Priority, exclusive: spec.Exclusive, schedule: schedule}, nil
+	}
+	return promotion, nil
+}
+
+// configured carries the priority, exclusivity and schedule of a promotion's
+// spec, which apply to promotions of every type.
+type configured struct {
+	domain.Promotion
+	priority  int
+	exclusive bool
+	schedule  *Schedule
+}
+
+func (c configured) Priority() int   { return c.priority }
+func (c configured) Exclusive() bool { return c.exclusive }
+
+func (c configured) ActiveAt(at time.Time) bool {
+	return c.schedule == nil || c.schedule.Active(at)
+}
+
+// BuildAll creates every promotion in specs, checking that their ids are unique.
+func BuildAll(specs []domain.PromotionSpec) ([]domain.Promotion, error) {
+	promotions := make([]domain.Promotion, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if seen[spec.ID] {
+			return nil, fmt.Errorf("promotion '%s' is configured more than once", spec.ID)
+		}
+		seen[spec.ID] = true
+		promotion, err := Build(spec)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, nil
+}
+
+// decodeParams strictly decodes the parameters of a promotion spec into params.
+func decodeParams(spec domain.PromotionSpec, params any) error {
+	if len(spec.Params) == 0 {
+		return errors.New("missing params")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(spec.Params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(params); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
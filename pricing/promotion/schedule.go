@@ -0,0 +1,174 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // Time zones resolve even where the system has no zone database
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// Schedule is a domain.Schedule checked and ready to be evaluated.
+type Schedule struct {
+	from     *time.Time
+	until    *time.Time
+	location *time.Location
+	windows  []window
+}
+
+// window is a RecurringWindow as offsets from the start of the day.
+type window struct {
+	days       [7]bool // Indexed by time.Weekday
+	start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// locations caches time zones by name, as loading one reads the zone database.
+var locations sync.Map
+
+// NewSchedule checks a schedule's time zone and windows. Days are named in
+// English, in full or by their first three letters, in any case.
+func NewSchedule(spec domain.Schedule) (*Schedule, error) {
+	if spec.From != nil && spec.Until != nil && !spec.From.Before(*spec.Until) {
+		return nil, errors.New("schedule must start before it ends")
+	}
+	location, err := loadLocation(spec.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &Schedule{from: spec.From, until: spec.Until, location: location}
+	for _, recurring := range spec.Windows {
+		w, err := newWindow(recurring)
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+	return schedule, nil
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s'", name)
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+func newWindow(recurring domain.RecurringWindow) (window, error) {
+	w := window{start: 0, end: 24 * time.Hour}
+	if len(recurring.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, name := range recurring.Days {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return window{}, fmt.Errorf("unknown day '%s'", name)
+		}
+		w.days[day] = true
+	}
+	var err error
+	if recurring.Start != "" {
+		if w.start, err = parseTimeOfDay(recurring.Start); err != nil {
+			return window{}, err
+		}
+	}
+	if recurring.End != "" {
+		if w.end, err = parseTimeOfDay(recurring.End); err != nil {
+			return window{}, err
+		}
+	}
+	if w.start == w.end {
+		return window{}, fmt.Errorf("window from %s to %s is empty", recurring.Start, recurring.End)
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses a time of day as "15:04", allowing "24:00" for the
+// end of the day.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s', expected HH:MM", value)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Active reports whether the schedule is active at a moment.
+func (s *Schedule) Active(at time.Time) bool {
+	if s.from != nil && at.Before(*s.from) {
+		return false
+	}
+	if s.until != nil && !at.Before(*s.until) {
+		return false
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	local := at.In(s.location)
+	day := local.Weekday()
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[day] && offset >= w.start && offset < w.end {
+				return true
+			}
+			continue
+		}
+		// The window runs past midnight, so it may have started the day before.
+		if (w.days[day] && offset >= w.start) || (w.days[(day+6)%7] && offset < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithSchedule limits a promotion to the times its schedule is active, keeping
+// its priority and exclusivity.
+func WithSchedule(promotion domain.Promotion, schedule *Schedule) domain.Promotion {
+	if c, ok := promotion.(configured); ok {
+		c.schedule = schedule
+		return c
+	}
+	return configured{Promotion: promotion, schedule: schedule}
+}
+
+// IsActive reports whether a promotion applies at a moment. Promotions with
+// no schedule always apply.
+func IsActive(promotion domain.Promotion, at time.Time) bool {
+	if s, ok := promotion.(interface{ ActiveAt(time.Time) bool }); ok {
+		return s.ActiveAt(at)
+	}
+	return true
+}
+
+// ActiveAt returns the promotions that apply at a moment, in order.
+func ActiveAt(promotions []domain.Promotion, at time.Time) []domain.Promotion {
+	active := make([]domain.Promotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		if IsActive(promotion, at) {
+			active = append(active, promotion)
+		}
+	}
+	return active
+}
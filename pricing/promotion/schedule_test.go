@@ -0,0 +1,96 @@
+package promotion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestScheduleActive(t *testing.T) {
+	from := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+	happyHour := domain.Schedule{
+		TimeZone: "Europe/London",
+		Windows:  []domain.RecurringWindow{{Days: []string{"Mon", "tue", "Wednesday", "thursday", "FRIDAY"}, Start: "17:00", End: "19:00"}},
+	}
+	lateNight := domain.Schedule{Windows: []domain.RecurringWindow{{Days: []string{"friday"}, Start: "22:00", End: "02:00"}}}
+
+	testCases := []struct {
+		name     string
+		schedule domain.Schedule
+		at       time.Time
+		expected bool
+	}{
+		{name: "No limits", schedule: domain.Schedule{}, at: from, expected: true},
+		{name: "Before the start", schedule: domain.Schedule{From: &from, Until: &until}, at: from.Add(-time.Second), expected: false},
+		{name: "At the start", schedule: domain.Schedule{From: &from, Until: &until}, at: from, expected: true},
+		{name: "At the end", schedule: domain.Schedule{From: &from, Until: &until}, at: until, expected: false},
+		// 7 July 2025 is a Monday, when London is an hour ahead of UTC.
+		{name: "Within the window in its time zone", schedule: happyHour, at: time.Date(2025, 7, 7, 16, 30, 0, 0, time.UTC), expected: true},
+		{name: "After the window in its time zone", schedule: happyHour, at: time.Date(2025, 7, 7, 18, 30, 0, 0, time.UTC), expected: false},
+		{name: "On a day without the window", schedule: happyHour, at: time.Date(2025, 7, 6, 16, 30, 0, 0, time.UTC), expected: false},
+		{name: "Overnight window before midnight", schedule: lateNight, at: time.Date(2025, 7, 11, 23, 0, 0, 0, time.UTC), expected: true},
+		{name: "Overnight window after midnight", schedule: lateNight, at: time.Date(2025, 7, 12, 1, 59, 0, 0, time.UTC), expected: true},
+		{name: "Overnight window started on the wrong day", schedule: lateNight, at: time.Date(2025, 7, 12, 23, 0, 0, 0, time.UTC), expected: false},
+		{name: "Overnight window at its end", schedule: lateNight, at: time.Date(2025, 7, 12, 2, 0, 0, 0, time.UTC), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := NewSchedule(tc.schedule)
+			if err != nil {
+				t.Fatalf("NewSchedule() returned an unexpected error: %v", err)
+			}
+			if got := schedule.Active(tc.at); got != tc.expected {
+				t.Errorf("Expected Active(%s) to be %v, got %v", tc.at, tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewScheduleValidation(t *testing.T) {
+	from := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	for name, schedule := range map[string]domain.Schedule{
+		"end before start":  {From: &from, Until: &from},
+		"unknown time zone": {TimeZone: "Mars/Olympus_Mons"},
+		"unknown day":       {Windows: []domain.RecurringWindow{{Days: []string{"someday"}}}},
+		"invalid time":      {Windows: []domain.RecurringWindow{{Start: "5pm"}}},
+		"empty window":      {Windows: []domain.RecurringWindow{{Start: "09:00", End: "09:00"}}},
+	} {
+		if _, err := NewSchedule(schedule); err == nil {
+			t.Errorf("Expected an error building a schedule with %s, but got nil", name)
+		}
+	}
+}
+
+func TestActiveAt(t *testing.T) {
+	weekend := spec("weekend", TypeMultiBuy, `{"sku":"A","quantity":2,"price":90}`)
+	weekend.Schedule = &domain.Schedule{Windows: []domain.RecurringWindow{{Days: []string{"sat", "sun"}}}}
+	weekend.Priority = 1
+	always := spec("always", TypeMultiBuy, `{"sku":"A","quantity":3,"price":130}`)
+	promotions, err := BuildAll([]domain.PromotionSpec{weekend, always})
+	if err != nil {
+		t.Fatalf("BuildAll() returned an unexpected error: %v", err)
+	}
+
+	saturday := time.Date(2025, 7, 12, 12, 0, 0, 0, time.UTC)
+	if active := ActiveAt(promotions, saturday); len(active) != 2 || PriorityOf(active[0]) != 1 {
+		t.Errorf("Expected both promotions on a Saturday, the first keeping its priority, got %d", len(active))
+	}
+	if active := ActiveAt(promotions, saturday.Add(48*time.Hour)); len(active) != 1 || active[0].ID() != "always" {
+		t.Errorf("Expected only the unscheduled promotion on a Monday, got %d", len(active))
+	}
+
+	ended, _ := NewSchedule(domain.Schedule{Until: &saturday})
+	scheduled := WithSchedule(promotions[0], ended)
+	if IsActive(scheduled, saturday) || PriorityOf(scheduled) != 1 {
+		t.Error("Expected WithSchedule to replace the schedule but keep the priority")
+	}
+
+	broken := spec("broken", TypeMultiBuy, `{"sku":"A","quantity":2,"price":90}`)
+	broken.Schedule = &domain.Schedule{TimeZone: "Nowhere"}
+	if _, err := Build(broken); err == nil {
+		t.Error("Expected an error building a promotion with an invalid schedule, but got nil")
+	}
+}
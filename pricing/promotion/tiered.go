@@ -0,0 +1,130 @@
+package promotion
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// TypeTiered is the type name of Tiered promotions.
+const TypeTiered = "tiered"
+
+func init() {
+	Register(TypeTiered, func(spec domain.PromotionSpec) (domain.Promotion, error) {
+		var params struct {
+			SKU   string             `json:"sku"`
+			Mode  domain.TierMode    `json:"mode"`
+			Tiers []domain.PriceTier `json:"tiers"`
+		}
+		if err := decodeParams(spec, &params); err != nil {
+			return nil, err
+		}
+		return NewTiered(spec.ID, params.SKU, domain.TieredPrice{Mode: params.Mode, Tiers: params.Tiers})
+	})
+}
+
+// Tiered charges lower unit prices for larger quantities of an SKU, such as
+// 1-9 at 50, 10-49 at 45 and 50 or more at 40. It is configured with the
+// params {"sku", "mode", "tiers"}, where mode is "all-units" or "graduated"
+// and each tier is {"minQuantity", "unitPrice"}.
+//
+// Each application takes a number of units of the SKU and discounts them from
+// the SKU's unit price down to their tiered price.
+type Tiered struct {
+	id    string
+	sku   string
+	mode  domain.TierMode
+	tiers []domain.PriceTier
+}
+
+// NewTiered creates a tiered price on sku. Tiers must be in increasing order
+// of minimum quantity, starting above one unit.
+func NewTiered(id, sku string, price domain.TieredPrice) (*Tiered, error) {
+	if sku == "" {
+		return nil, errors.New("tiered needs an sku")
+	}
+	switch price.Mode {
+	case domain.TierAllUnits, domain.TierGraduated:
+	default:
+		return nil, fmt.Errorf("unknown tier mode '%s', expected '%s' or '%s'", price.Mode, domain.TierAllUnits, domain.TierGraduated)
+	}
+	if len(price.Tiers) == 0 {
+		return nil, errors.New("tiered needs at least one tier")
+	}
+	previous := 1
+	for _, tier := range price.Tiers {
+		if tier.MinQuantity <= previous {
+			return nil, errors.New("tier minimum quantities must increase from 2")
+		}
+		if tier.UnitPrice.Amount < 0 {
+			return nil, errors.New("tier unit price must not be negative")
+		}
+		previous = tier.MinQuantity
+	}
+	return &Tiered{id: id, sku: sku, mode: price.Mode, tiers: append([]domain.PriceTier(nil), price.Tiers...)}, nil
+}
+
+// FromTiers re-expresses the tiered price of a pricing rule as a Tiered
+// promotion on sku.
+func FromTiers(sku string, price domain.TieredPrice) (*Tiered, error) {
+	return NewTiered(TypeTiered+"-"+sku, sku, price)
+}
+
+func (t *Tiered) ID() string   { return t.id }
+func (t *Tiered) Type() string { return TypeTiered }
+
+// Deals offers every quantity of the SKU that reaches the first tier, largest
+// first, so that the whole quantity is priced together unless units are
+// worth more to another promotion.
+func (t *Tiered) Deals(basket domain.Basket) []domain.Deal {
+	count := basket.Items[t.sku]
+	if count < t.tiers[0].MinQuantity {
+		return nil
+	}
+	base := basket.UnitPrices[t.sku]
+
+	// discounts[n] is the discount on n units. Graduated tiers dearer than
+	// the unit price take it below zero; once it is too far below to
+	// represent, the units left are not worth enough to bring it back above
+	// zero, so no larger quantity is offered. Nor is one whose discount is
+	// too large to represent, as the units are then worth more than any
+	// basket can be.
+	discounts := make([]int64, count+1)
+	for n := 1; n <= count; n++ {
+		price := t.priceAt(n, base)
+		var discount domain.Money
+		var err error
+		if t.mode == domain.TierAllUnits {
+			discount, err = domain.Money{Amount: max(base-price, 0)}.Mul(int64(n))
+		} else {
+			discount, err = domain.Money{Amount: discounts[n-1]}.Add(domain.Money{Amount: base - price})
+		}
+		if err != nil {
+			count = n - 1
+			break
+		}
+		discounts[n] = discount.Amount
+	}
+
+	var deals []domain.Deal
+	for n := count; n >= t.tiers[0].MinQuantity; n-- {
+		deals = append(deals, domain.Deal{
+			Items:     map[string]int{t.sku: n},
+			Discounts: map[string]int64{t.sku: discounts[n]},
+		})
+	}
+	return deals
+}
+
+// priceAt returns the unit price of the tier that quantity falls in.
+func (t *Tiered) priceAt(quantity int, base int64) int64 {
+	price := base
+	for _, tier := range t.tiers {
+		if quantity < tier.MinQuantity {
+			break
+		}
+		price = tier.UnitPrice.Amount
+	}
+	return price
+}
@@ -0,0 +1,75 @@
+package promotion
+
+import (
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+func TestTiered(t *testing.T) {
+	tiers := []domain.PriceTier{{MinQuantity: 10, UnitPrice: gbp(45)}, {MinQuantity: 50, UnitPrice: gbp(40)}}
+
+	testCases := []struct {
+		name            string
+		mode            domain.TierMode
+		quantity        int
+		expectedSavings int64
+	}{
+		{name: "All units below the first tier", mode: domain.TierAllUnits, quantity: 9, expectedSavings: 0},
+		{name: "All units at the first tier", mode: domain.TierAllUnits, quantity: 10, expectedSavings: 10 * 5},
+		{name: "All units at the top tier", mode: domain.TierAllUnits, quantity: 60, expectedSavings: 60 * 10},
+		{name: "Graduated below the first tier", mode: domain.TierGraduated, quantity: 9, expectedSavings: 0},
+		{name: "Graduated into the first tier", mode: domain.TierGraduated, quantity: 12, expectedSavings: 3 * 5},
+		{name: "Graduated into the top tier", mode: domain.TierGraduated, quantity: 60, expectedSavings: 40*5 + 11*10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tiered, err := FromTiers("A", domain.TieredPrice{Mode: tc.mode, Tiers: tiers})
+			if err != nil {
+				t.Fatalf("FromTiers() returned an unexpected error: %v", err)
+			}
+			basket := domain.Basket{Items: map[string]int{"A": tc.quantity}, UnitPrices: map[string]int64{"A": 50}}
+			result, err := Apply(basket, []domain.Promotion{tiered})
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if result.Discounts["A"] != tc.expectedSavings {
+				t.Errorf("Expected savings of %d, got %d", tc.expectedSavings, result.Discounts["A"])
+			}
+			if tc.expectedSavings > 0 && (len(result.Applied) != 1 || result.Applied[0].Applications != 1) {
+				t.Errorf("Expected the whole quantity to be priced in one application, got %+v", result.Applied)
+			}
+		})
+	}
+}
+
+func TestTieredLeavesUnitsToBetterOffers(t *testing.T) {
+	tiered, _ := FromTiers("A", domain.TieredPrice{Mode: domain.TierAllUnits, Tiers: []domain.PriceTier{{MinQuantity: 4, UnitPrice: gbp(45)}}})
+	multiBuy, _ := NewMultiBuy("a3", "A", 3, 132)
+	basket := domain.Basket{Items: map[string]int{"A": 7}, UnitPrices: map[string]int64{"A": 50}}
+
+	// Three units in the multi-buy save 18 and four at the tier price save 20,
+	// beating two multi-buys (36) or all seven at the tier price (35).
+	result, err := Apply(basket, []domain.Promotion{tiered, multiBuy})
+	if err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	if result.Discounts["A"] != 38 {
+		t.Errorf("Expected savings of 38, got %d (%+v)", result.Discounts["A"], result.Applied)
+	}
+}
+
+func TestNewTieredValidation(t *testing.T) {
+	for name, price := range map[string]domain.TieredPrice{
+		"unknown mode":       {Mode: "bulk", Tiers: []domain.PriceTier{{MinQuantity: 10, UnitPrice: gbp(45)}}},
+		"no tiers":           {Mode: domain.TierAllUnits},
+		"tier from one unit": {Mode: domain.TierAllUnits, Tiers: []domain.PriceTier{{MinQuantity: 1, UnitPrice: gbp(45)}}},
+		"unordered tiers":    {Mode: domain.TierGraduated, Tiers: []domain.PriceTier{{MinQuantity: 50, UnitPrice: gbp(40)}, {MinQuantity: 10, UnitPrice: gbp(45)}}},
+		"negative price":     {Mode: domain.TierGraduated, Tiers: []domain.PriceTier{{MinQuantity: 10, UnitPrice: gbp(-1)}}},
+	} {
+		if _, err := FromTiers("A", price); err == nil {
+			t.Errorf("Expected an error building tiers with %s, but got nil", name)
+		}
+	}
+}
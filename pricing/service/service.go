@@ -2,28 +2,53 @@ package pricing
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/barcode"
+	"github.com/TheFodfather/checkoutapi/pricing/promotion"
+	"github.com/TheFodfather/checkoutapi/pricing/tax"
 )
 
 // Service provides access to pricing rules
 type Service struct {
 	pricingFile string
-	rules       map[string]domain.PricingRule
+	currency    string                                   // Currency of the default price list
+	lists       map[string]pricingFile                   // Pricing in effect in each currency, with its changes still to come
+	stores      map[string]storeOverrides                // Overrides of each store, by store id
+	catalogues  map[string]map[string]*barcode.Catalogue // Barcodes of the current price lists, by store and currency
+	version     int
 	lastModTime time.Time
+	now         func() time.Time // Clock scheduled changes take effect by
 	sync.RWMutex
 }
 
+// Option configures a pricing service.
+type Option func(*Service)
+
+// WithClock sets the clock that decides when scheduled price changes take
+// effect. Services use the system clock by default.
+func WithClock(now func() time.Time) Option {
+	return func(s *Service) {
+		s.now = now
+	}
+}
+
 // New creates a new pricing service and loads pricing data
-func New(pricingFilePath string) (*Service, error) {
+func New(pricingFilePath string, opts ...Option) (*Service, error) {
 	s := &Service{
 		pricingFile: pricingFilePath,
-		rules:       make(map[string]domain.PricingRule),
+		lists:       make(map[string]pricingFile),
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
 	if err := s.loadPricingRules(); err != nil {
@@ -35,18 +60,495 @@ func New(pricingFilePath string) (*Service, error) {
 	return s, nil
 }
 
-// GetRules returns a copy of the current pricing rules.
-func (s *Service) GetRules() map[string]domain.PricingRule {
+// GetPriceList returns a copy of the current pricing rules, promotions,
+// basket discounts and tax rate table of the default price list along with
+// their currency and version. The version increases each time the rules are
+// reloaded or a scheduled change takes effect, in any currency. Every rule's
+// unit price is given in the currency.
+func (s *Service) GetPriceList() domain.PriceList {
+	s.advance()
 	s.RLock()
 	defer s.RUnlock()
+	return s.lists[s.currency].priceList(s.version)
+}
 
+// GetPriceListIn returns a copy of the current price list in a currency, as
+// GetPriceList does for the default one, or domain.ErrCurrencyNotPriced if
+// there is no price list in the currency.
+func (s *Service) GetPriceListIn(currency string) (domain.PriceList, error) {
+	return s.GetPriceListFor("", currency)
+}
+
+// GetPriceListFor returns a copy of the current price list of a store in a
+// currency: the price list in the currency with the store's overrides set
+// over it. The empty store is priced from the price list alone. It returns
+// domain.ErrUnknownStore if the store is not configured and
+// domain.ErrCurrencyNotPriced if there is no price list in the currency.
+func (s *Service) GetPriceListFor(store, currency string) (domain.PriceList, error) {
+	s.advance()
+	s.RLock()
+	defer s.RUnlock()
+	list, ok := s.lists[currency]
+	if !ok {
+		return domain.PriceList{}, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	if store == "" {
+		return list.priceList(s.version), nil
+	}
+	overrides, ok := s.stores[store]
+	if !ok {
+		return domain.PriceList{}, fmt.Errorf("store '%s' %w", store, domain.ErrUnknownStore)
+	}
+	return list.apply(overrides.in(currency, s.currency)).priceList(s.version), nil
+}
+
+// GetCatalogueFor returns the barcodes of the current price list of a store
+// in a currency, indexed once for each version of the price lists. It returns
+// the same errors as GetPriceListFor, and an error if the barcodes could not
+// be indexed.
+func (s *Service) GetCatalogueFor(store, currency string) (*barcode.Catalogue, error) {
+	s.advance()
+	s.RLock()
+	defer s.RUnlock()
+	if _, ok := s.lists[currency]; !ok {
+		return nil, fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+	}
+	if _, ok := s.stores[store]; store != "" && !ok {
+		return nil, fmt.Errorf("store '%s' %w", store, domain.ErrUnknownStore)
+	}
+	catalogue := s.catalogues[store][currency]
+	if catalogue == nil {
+		return nil, fmt.Errorf("barcodes of store '%s' in %s are not indexed", store, currency)
+	}
+	return catalogue, nil
+}
+
+// Upcoming returns the scheduled price changes that have not yet taken
+// effect in any currency, soonest first, each naming the currency of its
+// price list and with its amounts in that currency.
+func (s *Service) Upcoming() []domain.PriceChange {
+	s.advance()
+	s.RLock()
+	defer s.RUnlock()
+
+	currencies := make([]string, 0, len(s.lists))
+	for currency := range s.lists {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	var upcoming []domain.PriceChange
+	for _, currency := range currencies {
+		for _, change := range s.lists[currency].Scheduled {
+			// Scheduled changes were validated against their price list, so
+			// their amounts are all in its currency.
+			rules := make(map[string]*domain.PricingRule, len(change.Rules))
+			for sku, rule := range change.Rules {
+				if rule != nil {
+					priced, _ := rule.In(currency)
+					rule = &priced
+				}
+				rules[sku] = rule
+			}
+			change.Rules = rules
+			if change.BasketDiscounts != nil {
+				discounts := discountsIn(*change.BasketDiscounts, currency)
+				change.BasketDiscounts = &discounts
+			}
+			change.Currency = currency
+			upcoming = append(upcoming, change)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].EffectiveFrom.Before(upcoming[j].EffectiveFrom)
+	})
+	return upcoming
+}
+
+// advance puts the scheduled changes that are due into effect, all at once as
+// a single new version of the price lists.
+func (s *Service) advance() {
+	now := s.now()
+	s.RLock()
+	due := s.due(now)
+	s.RUnlock()
+	if !due {
+		return
+	}
+
+	s.Lock()
+	if !s.due(now) {
+		// Another caller put the changes into effect first.
+		s.Unlock()
+		return
+	}
+	for currency, list := range s.lists {
+		s.lists[currency] = list.advance(now)
+	}
+	catalogues, err := barcodeCatalogues(s.currency, s.lists, s.stores)
+	if err != nil {
+		// Every scheduled change was checked to leave the barcodes valid, so
+		// this should not happen; the barcodes indexed before it still resolve.
+		log.Printf("ERROR: Failed to index barcodes after a scheduled price change, keeping the previous ones: %v", err)
+	} else {
+		s.catalogues = catalogues
+	}
+	s.version++
+	version := s.version
+	s.Unlock()
+
+	log.Printf("✅ Scheduled price change took effect (version %d).", version)
+}
+
+// due reports whether a scheduled change in any currency takes effect by now.
+// The caller must hold the lock.
+func (s *Service) due(now time.Time) bool {
+	for _, list := range s.lists {
+		if len(list.Scheduled) > 0 && !now.Before(list.Scheduled[0].EffectiveFrom) {
+			return true
+		}
+	}
+	return false
+}
+
+// pricingFile is the layout of pricing.json. Files written before promotions
+// were supported hold only the rules map, without the "rules" key. Prices are
+// in Currency, or domain.DefaultCurrency when the file does not name one; it
+// is the default price list. PriceLists holds the price lists in other
+// currencies, keyed by currency, each laid out like the file itself. Stores
+// holds the overrides of each store, keyed by store id.
+type pricingFile struct {
+	Currency        string                        `json:"currency"`
+	Rules           map[string]domain.PricingRule `json:"rules"`
+	Promotions      []domain.PromotionSpec        `json:"promotions"`
+	BasketDiscounts []domain.BasketDiscount       `json:"basketDiscounts"`
+	Tax             *domain.TaxConfig             `json:"tax"`
+	Scheduled       []domain.PriceChange          `json:"scheduled"`
+	PriceLists      map[string]pricingFile        `json:"priceLists"`
+	Stores          map[string]storeOverrides     `json:"stores"`
+}
+
+// storeOverrides is the layout of a store in pricing.json: the change it
+// makes to the default price list and, under PriceLists, those it makes to
+// the price lists in other currencies, keyed by currency. A store inherits
+// whatever it does not override, and the whole of a price list it has no
+// change to.
+type storeOverrides struct {
+	domain.PriceChange
+	PriceLists map[string]domain.PriceChange `json:"priceLists"`
+}
+
+// in returns the change a store makes to its price list in a currency, given
+// the currency of the default price list.
+func (o storeOverrides) in(currency, defaultCurrency string) domain.PriceChange {
+	if currency == defaultCurrency {
+		return o.PriceChange
+	}
+	return o.PriceLists[currency]
+}
+
+// apply returns the pricing with a change made to it, leaving f unchanged.
+func (f pricingFile) apply(change domain.PriceChange) pricingFile {
+	rules := make(map[string]domain.PricingRule, len(f.Rules)+len(change.Rules))
+	for sku, rule := range f.Rules {
+		rules[sku] = rule
+	}
+	for sku, rule := range change.Rules {
+		if rule == nil {
+			delete(rules, sku)
+		} else {
+			rules[sku] = *rule
+		}
+	}
+	changed := pricingFile{Currency: f.Currency, Rules: rules, Promotions: f.Promotions, BasketDiscounts: f.BasketDiscounts, Tax: f.Tax}
+	if change.Promotions != nil {
+		changed.Promotions = *change.Promotions
+	}
+	if change.BasketDiscounts != nil {
+		changed.BasketDiscounts = *change.BasketDiscounts
+	}
+	if change.Tax != nil {
+		changed.Tax = change.Tax
+	}
+	return changed
+}
+
+// priceList copies the pricing as a price list of the given version, with
+// every amount in its currency. The pricing must have been validated.
+func (f pricingFile) priceList(version int) domain.PriceList {
 	rulesCopy := make(map[string]domain.PricingRule)
 
-	for k, v := range s.rules {
-		rulesCopy[k] = v
+	for k, v := range f.Rules {
+		rulesCopy[k], _ = v.In(f.Currency)
+	}
+
+	promotionsCopy := append([]domain.PromotionSpec(nil), f.Promotions...)
+	discountsCopy := discountsIn(f.BasketDiscounts, f.Currency)
+
+	var taxCopy *domain.TaxConfig
+	if f.Tax != nil {
+		taxCopy = &domain.TaxConfig{Mode: f.Tax.Mode, Rounding: f.Tax.Rounding, Rates: make(map[string]int, len(f.Tax.Rates)), DefaultCategory: f.Tax.DefaultCategory}
+		for category, rate := range f.Tax.Rates {
+			taxCopy.Rates[category] = rate
+		}
+	}
+
+	return domain.PriceList{Version: version, Currency: f.Currency, Rules: rulesCopy, Promotions: promotionsCopy, BasketDiscounts: discountsCopy, Tax: taxCopy}
+}
+
+// discountsIn copies validated basket discounts with their amounts in
+// currency, keeping an empty list of discounts apart from a missing one.
+func discountsIn(discounts []domain.BasketDiscount, currency string) []domain.BasketDiscount {
+	if discounts == nil {
+		return nil
+	}
+	priced := make([]domain.BasketDiscount, 0, len(discounts))
+	for _, discount := range discounts {
+		discount, _ = discount.In(currency)
+		priced = append(priced, discount)
+	}
+	return priced
+}
+
+// barcodeCatalogues indexes the barcodes of the price list in each currency,
+// keyed by store and then currency, with the empty store for the price lists
+// as they are.
+func barcodeCatalogues(defaultCurrency string, lists map[string]pricingFile, stores map[string]storeOverrides) (map[string]map[string]*barcode.Catalogue, error) {
+	catalogues := make(map[string]map[string]*barcode.Catalogue, len(stores)+1)
+	index := func(store string, overrides storeOverrides) error {
+		catalogues[store] = make(map[string]*barcode.Catalogue, len(lists))
+		for currency, list := range lists {
+			catalogue, err := barcode.NewCatalogue(list.apply(overrides.in(currency, defaultCurrency)).Rules)
+			if err != nil {
+				return fmt.Errorf("price list '%s': %w", currency, err)
+			}
+			catalogues[store][currency] = catalogue
+		}
+		return nil
+	}
+	if err := index("", storeOverrides{}); err != nil {
+		return nil, err
+	}
+	for store, overrides := range stores {
+		if err := index(store, overrides); err != nil {
+			return nil, fmt.Errorf("store '%s': %w", store, err)
+		}
+	}
+	return catalogues, nil
+}
+
+// advance applies the scheduled changes, soonest first, that take effect by
+// now, and returns the pricing with the changes still to come.
+func (f pricingFile) advance(now time.Time) pricingFile {
+	changes := f.Scheduled
+	for len(changes) > 0 && !now.Before(changes[0].EffectiveFrom) {
+		f = f.apply(changes[0])
+		changes = changes[1:]
 	}
+	f.Scheduled = changes
+	return f
+}
 
-	return rulesCopy
+// validate checks that every rule, promotion and basket discount is valid,
+// that every rule and basket discount is priced in the file's currency, that
+// no two rules share a barcode and that every rule is taxable when there is
+// a tax rate table.
+func (f pricingFile) validate() error {
+	if err := domain.ValidateCurrency(f.Currency); err != nil {
+		return err
+	}
+	for sku, rule := range f.Rules {
+		if _, err := rule.In(f.Currency); err != nil {
+			return fmt.Errorf("sku '%s': %w, price list is in %s", sku, err, f.Currency)
+		}
+		if err := validateRule(sku, rule); err != nil {
+			return fmt.Errorf("sku '%s': %w", sku, err)
+		}
+	}
+	if _, err := barcode.NewCatalogue(f.Rules); err != nil {
+		return err
+	}
+	if _, err := promotion.BuildAll(f.Promotions); err != nil {
+		return err
+	}
+	if err := promotion.ValidateBasketDiscounts(f.BasketDiscounts); err != nil {
+		return err
+	}
+	for _, discount := range f.BasketDiscounts {
+		if _, err := discount.In(f.Currency); err != nil {
+			return fmt.Errorf("basket discount '%s': %w, price list is in %s", discount.ID, err, f.Currency)
+		}
+	}
+	if f.Tax != nil {
+		if err := tax.Validate(*f.Tax, f.Rules); err != nil {
+			return fmt.Errorf("tax: %w", err)
+		}
+	}
+	return nil
+}
+
+// parsePricingFile reads the pricing rules, promotions and basket discounts
+// of every price list from the contents of pricing.json, checking that every
+// one of them is valid, along with the scheduled changes to them, sorted
+// soonest first. Each scheduled change must leave the pricing valid.
+func parsePricingFile(data []byte) (pricingFile, error) {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return pricingFile{}, err
+	}
+
+	var parsed pricingFile
+	if _, ok := sections["rules"]; ok {
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return pricingFile{}, err
+		}
+	} else if err := json.Unmarshal(data, &parsed.Rules); err != nil {
+		return pricingFile{}, err
+	}
+	if parsed.Currency == "" {
+		parsed.Currency = domain.DefaultCurrency
+	}
+
+	parsed, err := parsed.checked()
+	if err != nil {
+		return pricingFile{}, err
+	}
+	for currency, list := range parsed.PriceLists {
+		switch {
+		case currency == parsed.Currency:
+			return pricingFile{}, fmt.Errorf("price list '%s' is the default price list", currency)
+		case list.Currency != "" && list.Currency != currency:
+			return pricingFile{}, fmt.Errorf("price list '%s' is in %s", currency, list.Currency)
+		case list.PriceLists != nil:
+			return pricingFile{}, fmt.Errorf("price list '%s' holds other price lists", currency)
+		case list.Stores != nil:
+			return pricingFile{}, fmt.Errorf("price list '%s' holds stores; they belong at the top of the file", currency)
+		}
+		list.Currency = currency
+		if list, err = list.checked(); err != nil {
+			return pricingFile{}, fmt.Errorf("price list '%s': %w", currency, err)
+		}
+		parsed.PriceLists[currency] = list
+	}
+	for store, overrides := range parsed.Stores {
+		if store == "" {
+			return pricingFile{}, errors.New("store with an empty id")
+		}
+		if err := parsed.checkStore(overrides); err != nil {
+			return pricingFile{}, fmt.Errorf("store '%s': %w", store, err)
+		}
+	}
+	return parsed, nil
+}
+
+// checkStore checks that every change a store makes is to a price list that
+// exists, and leaves it valid both now and after each of its scheduled
+// changes.
+func (f pricingFile) checkStore(overrides storeOverrides) error {
+	if err := f.checkOverride(overrides.PriceChange); err != nil {
+		return err
+	}
+	for currency, change := range overrides.PriceLists {
+		if currency == f.Currency {
+			return fmt.Errorf("price list '%s' is the default price list; override it at the top of the store", currency)
+		}
+		list, ok := f.PriceLists[currency]
+		if !ok {
+			return fmt.Errorf("currency '%s' %w", currency, domain.ErrCurrencyNotPriced)
+		}
+		if err := list.checkOverride(change); err != nil {
+			return fmt.Errorf("price list '%s': %w", currency, err)
+		}
+	}
+	return nil
+}
+
+// checkOverride checks that a store's change to the pricing leaves it valid,
+// both now and after each of its scheduled changes.
+func (f pricingFile) checkOverride(change domain.PriceChange) error {
+	if !change.EffectiveFrom.IsZero() {
+		return errors.New("overrides cannot have an effectiveFrom; schedule the change to the price list instead")
+	}
+	if change.Currency != "" && change.Currency != f.Currency {
+		return fmt.Errorf("overrides are in %s, price list is in %s", change.Currency, f.Currency)
+	}
+	if err := f.apply(change).validate(); err != nil {
+		return err
+	}
+	changed := f
+	for _, scheduled := range f.Scheduled {
+		changed = changed.apply(scheduled)
+		if err := changed.apply(change).validate(); err != nil {
+			return fmt.Errorf("after change effective from %s: %w", scheduled.EffectiveFrom.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// checked returns the pricing with its scheduled changes sorted soonest
+// first, once it and every change to it are valid.
+func (f pricingFile) checked() (pricingFile, error) {
+	if err := f.validate(); err != nil {
+		return pricingFile{}, err
+	}
+
+	f.Scheduled = append([]domain.PriceChange(nil), f.Scheduled...)
+	sort.SliceStable(f.Scheduled, func(i, j int) bool {
+		return f.Scheduled[i].EffectiveFrom.Before(f.Scheduled[j].EffectiveFrom)
+	})
+	changed := f
+	for _, change := range f.Scheduled {
+		if change.EffectiveFrom.IsZero() {
+			return pricingFile{}, errors.New("scheduled change has no effectiveFrom")
+		}
+		if change.Currency != "" && change.Currency != f.Currency {
+			return pricingFile{}, fmt.Errorf("change effective from %s is in %s", change.EffectiveFrom.Format(time.RFC3339), change.Currency)
+		}
+		changed = changed.apply(change)
+		if err := changed.validate(); err != nil {
+			return pricingFile{}, fmt.Errorf("change effective from %s: %w", change.EffectiveFrom.Format(time.RFC3339), err)
+		}
+	}
+	return f, nil
+}
+
+// validateRule checks the unit of measure, offers and schedule of an SKU's
+// pricing rule. Special prices are not checked, as those that cannot be
+// applied have always been ignored, but an SKU sold by measure may have none.
+func validateRule(sku string, rule domain.PricingRule) error {
+	switch rule.Unit {
+	case "", domain.UnitEach, domain.UnitKilogram, domain.UnitLitre:
+	default:
+		return fmt.Errorf("unknown unit '%s', expected 'each', 'kg' or 'litre'", rule.Unit)
+	}
+	switch rule.Rounding {
+	case "", domain.RoundHalfUp, domain.RoundDown, domain.RoundUp:
+	default:
+		return fmt.Errorf("unknown rounding '%s', expected 'half-up', 'down' or 'up'", rule.Rounding)
+	}
+	if rule.Unit.Measured() {
+		if rule.SpecialPrice != nil || rule.BuyGet != nil || rule.Tiers != nil {
+			return fmt.Errorf("sold by the %s, so it cannot have offers counted in units", rule.Unit)
+		}
+	} else if rule.Rounding != "" {
+		return errors.New("rounding only applies to SKUs sold by measure")
+	}
+	if rule.Schedule != nil {
+		if _, err := promotion.NewSchedule(*rule.Schedule); err != nil {
+			return err
+		}
+	}
+	if rule.BuyGet != nil {
+		if _, err := promotion.FromBuyGet(sku, *rule.BuyGet); err != nil {
+			return err
+		}
+	}
+	if rule.Tiers != nil {
+		if _, err := promotion.FromTiers(sku, *rule.Tiers); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Service) loadPricingRules() error {
@@ -55,8 +557,8 @@ func (s *Service) loadPricingRules() error {
 		return err
 	}
 
-	var newRules map[string]domain.PricingRule
-	if err := json.Unmarshal(file, &newRules); err != nil {
+	parsed, err := parsePricingFile(file)
+	if err != nil {
 		return fmt.Errorf("failed to parse pricing json: %w", err)
 	}
 
@@ -65,12 +567,30 @@ func (s *Service) loadPricingRules() error {
 		return err
 	}
 
+	now := s.now()
+	lists := make(map[string]pricingFile, len(parsed.PriceLists)+1)
+	for currency, list := range parsed.PriceLists {
+		lists[currency] = list.advance(now)
+	}
+	stores := parsed.Stores
+	parsed.PriceLists, parsed.Stores = nil, nil
+	lists[parsed.Currency] = parsed.advance(now)
+	catalogues, err := barcodeCatalogues(parsed.Currency, lists, stores)
+	if err != nil {
+		return fmt.Errorf("failed to index barcodes: %w", err)
+	}
+
 	s.Lock()
-	s.rules = newRules
+	s.currency = parsed.Currency
+	s.lists = lists
+	s.stores = stores
+	s.catalogues = catalogues
+	s.version++
+	version := s.version
 	s.lastModTime = fileInfo.ModTime()
 	s.Unlock()
 
-	log.Println("✅ Successfully loaded new pricing rules.")
+	log.Printf("✅ Successfully loaded new pricing rules (version %d).", version)
 
 	return nil
 }
@@ -80,6 +600,8 @@ func (s *Service) watchPricingChanges() {
 	defer ticker.Stop()
 
 	for range ticker.C {
+		s.advance()
+
 		fileInfo, err := os.Stat(s.pricingFile)
 		if err != nil {
 			log.Printf("⚠️ Could not stat pricing file, keeping old rules: %v", err)
@@ -0,0 +1,292 @@
+package pricing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+	"github.com/TheFodfather/checkoutapi/pricing/barcode"
+)
+
+// writePricingFile writes the contents of a pricing.json to a temporary
+// directory and returns its path.
+func writePricingFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("Could not write pricing file: %v", err)
+	}
+	return path
+}
+
+func TestScheduledPriceChanges(t *testing.T) {
+	path := writePricingFile(t, `{
+		"rules": {"A": {"unitPrice": 50}, "B": {"unitPrice": 30}},
+		"promotions": [{"id": "a-pair", "type": "multibuy", "params": {"sku": "A", "quantity": 2, "price": 90}}],
+		"scheduled": [
+			{"effectiveFrom": "2025-03-01T00:00:00Z", "rules": {"B": null, "C": {"unitPrice": 20}}, "promotions": []},
+			{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"A": {"unitPrice": 55}}}
+		]
+	}`)
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	service, err := New(path, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+
+	upcoming := service.Upcoming()
+	if len(upcoming) != 2 || !upcoming[0].EffectiveFrom.Equal(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected two upcoming changes, soonest first, got %+v", upcoming)
+	}
+	prices := service.GetPriceList()
+	if prices.Version != 1 || prices.Currency != "GBP" || prices.Rules["A"].UnitPrice != (domain.Money{Amount: 50, Currency: "GBP"}) {
+		t.Errorf("Expected version 1 with A at 50 GBP before any change, got version %d with A at %v", prices.Version, prices.Rules["A"].UnitPrice)
+	}
+
+	now = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	prices = service.GetPriceList()
+	if prices.Version != 2 || prices.Rules["A"].UnitPrice.Amount != 55 || prices.Rules["B"].UnitPrice.Amount != 30 || len(prices.Promotions) != 1 {
+		t.Errorf("Expected version 2 with only A changed, got %+v", prices)
+	}
+	if upcoming := service.Upcoming(); len(upcoming) != 1 {
+		t.Errorf("Expected one upcoming change after the first cutover, got %d", len(upcoming))
+	}
+
+	now = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	prices = service.GetPriceList()
+	if _, withdrawn := prices.Rules["B"]; withdrawn || prices.Rules["C"].UnitPrice.Amount != 20 || prices.Rules["A"].UnitPrice.Amount != 55 {
+		t.Errorf("Expected B withdrawn, C added and A kept at 55, got %+v", prices.Rules)
+	}
+	if prices.Version != 3 || len(prices.Promotions) != 0 || len(service.Upcoming()) != 0 {
+		t.Errorf("Expected version 3 with no promotions or upcoming changes, got %+v", prices)
+	}
+}
+
+func TestScheduledChangesAlreadyDueOnLoad(t *testing.T) {
+	path := writePricingFile(t, `{
+		"rules": {"A": {"unitPrice": 50}},
+		"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"A": {"unitPrice": 55}}}]
+	}`)
+	service, err := New(path, WithClock(func() time.Time { return time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC) }))
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+	if prices := service.GetPriceList(); prices.Version != 1 || prices.Rules["A"].UnitPrice.Amount != 55 {
+		t.Errorf("Expected version 1 with A at 55, got version %d with A at %d", prices.Version, prices.Rules["A"].UnitPrice.Amount)
+	}
+}
+
+func TestPriceListsByCurrency(t *testing.T) {
+	path := writePricingFile(t, `{
+		"rules": {"A": {"unitPrice": 50}},
+		"priceLists": {
+			"EUR": {
+				"rules": {"A": {"unitPrice": 60}, "B": {"unitPrice": {"amount": 35, "currency": "EUR"}}},
+				"promotions": [{"id": "a-pair", "type": "multibuy", "params": {"sku": "A", "quantity": 2, "price": 110}}],
+				"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"A": {"unitPrice": 65}}}]
+			}
+		}
+	}`)
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	service, err := New(path, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+
+	if prices := service.GetPriceList(); prices.Currency != "GBP" || len(prices.Rules) != 1 || len(prices.Promotions) != 0 {
+		t.Errorf("Expected the default price list in GBP with only A, got %+v", prices)
+	}
+	euro, err := service.GetPriceListIn("EUR")
+	if err != nil {
+		t.Fatalf("GetPriceListIn() returned an unexpected error: %v", err)
+	}
+	if euro.Currency != "EUR" || euro.Rules["A"].UnitPrice != (domain.Money{Amount: 60, Currency: "EUR"}) || euro.Rules["B"].UnitPrice.Amount != 35 || len(euro.Promotions) != 1 {
+		t.Errorf("Expected the EUR price list with A at 60 EUR and B at 35 EUR, got %+v", euro)
+	}
+	if _, err := service.GetPriceListIn("USD"); !errors.Is(err, domain.ErrCurrencyNotPriced) {
+		t.Errorf("Expected ErrCurrencyNotPriced for USD, got %v", err)
+	}
+
+	upcoming := service.Upcoming()
+	if len(upcoming) != 1 || upcoming[0].Currency != "EUR" || !reflect.DeepEqual(*upcoming[0].Rules["A"], domain.PricingRule{UnitPrice: domain.Money{Amount: 65, Currency: "EUR"}}) {
+		t.Fatalf("Expected one upcoming change to the EUR price list, got %+v", upcoming)
+	}
+
+	now = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	euro, _ = service.GetPriceListIn("EUR")
+	if euro.Version != 2 || euro.Rules["A"].UnitPrice.Amount != 65 {
+		t.Errorf("Expected version 2 with A at 65 EUR, got version %d with A at %v", euro.Version, euro.Rules["A"].UnitPrice)
+	}
+	if prices := service.GetPriceList(); prices.Version != 2 || prices.Rules["A"].UnitPrice.Amount != 50 {
+		t.Errorf("Expected version 2 of the default price list with A still at 50, got %+v", prices)
+	}
+}
+
+func TestStorePricing(t *testing.T) {
+	path := writePricingFile(t, `{
+		"rules": {"A": {"unitPrice": 50}, "B": {"unitPrice": 30}, "D": {"unitPrice": 15}},
+		"promotions": [{"id": "a-pair", "type": "multibuy", "params": {"sku": "A", "quantity": 2, "price": 90}}],
+		"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"B": {"unitPrice": 32}}}],
+		"priceLists": {"EUR": {"rules": {"A": {"unitPrice": 60}}}},
+		"stores": {
+			"manchester": {
+				"rules": {"A": {"unitPrice": 45}, "D": null},
+				"promotions": [],
+				"priceLists": {"EUR": {"rules": {"A": {"unitPrice": 55}}}}
+			},
+			"leeds": {}
+		}
+	}`)
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	service, err := New(path, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+
+	manchester, err := service.GetPriceListFor("manchester", "GBP")
+	if err != nil {
+		t.Fatalf("GetPriceListFor() returned an unexpected error: %v", err)
+	}
+	if _, withdrawn := manchester.Rules["D"]; withdrawn || manchester.Rules["A"].UnitPrice.Amount != 45 || manchester.Rules["B"].UnitPrice.Amount != 30 || len(manchester.Promotions) != 0 {
+		t.Errorf("Expected A overridden at 45, B inherited at 30, D withdrawn and no promotions, got %+v", manchester)
+	}
+	if euro, _ := service.GetPriceListFor("manchester", "EUR"); euro.Rules["A"].UnitPrice != (domain.Money{Amount: 55, Currency: "EUR"}) {
+		t.Errorf("Expected A at 55 EUR in manchester, got %v", euro.Rules["A"].UnitPrice)
+	}
+	leeds, _ := service.GetPriceListFor("leeds", "GBP")
+	if base := service.GetPriceList(); !reflect.DeepEqual(leeds, base) {
+		t.Errorf("Expected a store without overrides to be priced from the price list %+v, got %+v", base, leeds)
+	}
+	if base, _ := service.GetPriceListFor("", "GBP"); base.Rules["A"].UnitPrice.Amount != 50 {
+		t.Errorf("Expected the empty store to be priced from the price list, got A at %v", base.Rules["A"].UnitPrice)
+	}
+
+	if _, err := service.GetPriceListFor("york", "GBP"); !errors.Is(err, domain.ErrUnknownStore) {
+		t.Errorf("Expected ErrUnknownStore for an unconfigured store, got %v", err)
+	}
+	if _, err := service.GetPriceListFor("manchester", "USD"); !errors.Is(err, domain.ErrCurrencyNotPriced) {
+		t.Errorf("Expected ErrCurrencyNotPriced for USD, got %v", err)
+	}
+
+	// Stores inherit the changes scheduled to the price list they override.
+	now = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	manchester, _ = service.GetPriceListFor("manchester", "GBP")
+	if manchester.Version != 2 || manchester.Rules["B"].UnitPrice.Amount != 32 || manchester.Rules["A"].UnitPrice.Amount != 45 {
+		t.Errorf("Expected version 2 with B changed to 32 and A still overridden at 45, got %+v", manchester)
+	}
+}
+
+func TestBarcodeCatalogues(t *testing.T) {
+	path := writePricingFile(t, `{
+		"rules": {"A": {"unitPrice": 50, "barcodes": ["4006381333931"]}, "B": {"unitPrice": 30}},
+		"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"B": {"unitPrice": 30, "barcodes": ["036000291452"]}}}],
+		"priceLists": {"EUR": {"rules": {"A": {"unitPrice": 60}}}},
+		"stores": {"leeds": {"rules": {"A": null, "C": {"unitPrice": 20, "barcodes": ["4006381333931"]}}}}
+	}`)
+	now := time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)
+	service, err := New(path, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		store    string
+		currency string
+		code     string
+		sku      string
+		expected error
+	}{
+		{name: "Barcode in the price list", currency: "GBP", code: "4006381333931", sku: "A"},
+		{name: "Barcode given to another SKU by a store", store: "leeds", currency: "GBP", code: "4006381333931", sku: "C"},
+		{name: "Barcode not in another currency", currency: "EUR", code: "4006381333931", expected: barcode.ErrUnknownBarcode},
+		{name: "Barcode not yet scheduled", currency: "GBP", code: "036000291452", expected: barcode.ErrUnknownBarcode},
+		{name: "Unknown store", store: "york", currency: "GBP", expected: domain.ErrUnknownStore},
+		{name: "Currency with no price list", currency: "USD", expected: domain.ErrCurrencyNotPriced},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			catalogue, err := service.GetCatalogueFor(tc.store, tc.currency)
+			if err == nil {
+				var item barcode.Item
+				item, err = catalogue.Lookup(tc.code)
+				if item.SKU != tc.sku {
+					t.Errorf("Expected sku %q, got %q", tc.sku, item.SKU)
+				}
+			}
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("Expected error %v, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	first, _ := service.GetCatalogueFor("", "GBP")
+	if again, _ := service.GetCatalogueFor("", "GBP"); again != first {
+		t.Error("Expected the catalogue to be indexed once for each version of the price lists")
+	}
+	now = time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	catalogue, _ := service.GetCatalogueFor("leeds", "GBP")
+	if item, err := catalogue.Lookup("036000291452"); err != nil || item.SKU != "B" {
+		t.Errorf("Expected the scheduled barcode of B to reach the store, got %+v (err %v)", item, err)
+	}
+
+	// Barcodes that failed to index are an error, never a nil catalogue.
+	service.catalogues = nil
+	if catalogue, err := service.GetCatalogueFor("leeds", "GBP"); err == nil || catalogue != nil {
+		t.Errorf("Expected an error for barcodes that are not indexed, got %v (err %v)", catalogue, err)
+	}
+}
+
+func TestParsePricingFile(t *testing.T) {
+	legacy, err := parsePricingFile([]byte(`{"A": {"unitPrice": 50, "specialPrice": {"quantity": 3, "price": 130}}}`))
+	if err != nil {
+		t.Fatalf("Expected the legacy rules map to parse, got %v", err)
+	}
+	if legacy.Rules["A"].UnitPrice.Amount != 50 || legacy.Currency != domain.DefaultCurrency || len(legacy.Scheduled) != 0 {
+		t.Errorf("Expected rule A at 50 with no scheduled changes, got %+v", legacy)
+	}
+
+	for name, contents := range map[string]string{
+		"change without effectiveFrom": `{"rules": {"A": {"unitPrice": 50}}, "scheduled": [{"rules": {"A": {"unitPrice": 55}}}]}`,
+		"change with an invalid rule":  `{"rules": {"A": {"unitPrice": 50}}, "scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "rules": {"A": {"unitPrice": 50, "buyGet": {"buy": 0, "get": 1}}}}]}`,
+		"change with an unknown promotion type": `{"rules": {"A": {"unitPrice": 50}}, "scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z",
+			"promotions": [{"id": "x", "type": "mystery", "params": {}}]}]}`,
+		"currency that is not ISO 4217":                       `{"currency": "QQQ", "rules": {"A": {"unitPrice": 50}}}`,
+		"rule priced in another currency":                     `{"currency": "EUR", "rules": {"A": {"unitPrice": {"amount": 50, "currency": "GBP"}}}}`,
+		"price list in another currency":                      `{"rules": {}, "priceLists": {"EUR": {"currency": "USD", "rules": {}}}}`,
+		"price list keyed by an unknown currency":             `{"rules": {}, "priceLists": {"QQQ": {"rules": {}}}}`,
+		"price list in the default currency":                  `{"currency": "EUR", "rules": {}, "priceLists": {"EUR": {"rules": {}}}}`,
+		"price list holding other price lists":                `{"rules": {}, "priceLists": {"EUR": {"rules": {}, "priceLists": {"USD": {"rules": {}}}}}}`,
+		"price list with an invalid rule":                     `{"rules": {}, "priceLists": {"EUR": {"rules": {"A": {"unitPrice": 50, "buyGet": {"buy": 0, "get": 1}}}}}}`,
+		"store overriding a currency with no price list":      `{"rules": {}, "stores": {"leeds": {"priceLists": {"EUR": {"rules": {}}}}}}`,
+		"store overriding the default price list by currency": `{"rules": {}, "stores": {"leeds": {"priceLists": {"GBP": {"rules": {}}}}}}`,
+		"store with an invalid rule":                          `{"rules": {}, "stores": {"leeds": {"rules": {"A": {"unitPrice": 50, "buyGet": {"buy": 0, "get": 1}}}}}}`,
+		"store override with effectiveFrom":                   `{"rules": {}, "stores": {"leeds": {"effectiveFrom": "2025-02-01T00:00:00Z"}}}`,
+		"store with an empty id":                              `{"rules": {}, "stores": {"": {}}}`,
+		"price list holding stores":                           `{"rules": {}, "priceLists": {"EUR": {"rules": {}, "stores": {"leeds": {}}}}}`,
+		"store rule untaxable after a scheduled change": `{"rules": {"A": {"unitPrice": 50, "taxCategory": "standard"}},
+			"tax": {"mode": "inclusive", "rounding": "line", "rates": {"standard": 2000, "reduced": 500}},
+			"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "tax": {"mode": "inclusive", "rounding": "line", "rates": {"standard": 2000}}}],
+			"stores": {"leeds": {"rules": {"B": {"unitPrice": 30, "taxCategory": "reduced"}}}}}`,
+		"rule with an unknown unit":                  `{"rules": {"A": {"unitPrice": 50, "unit": "pound"}}}`,
+		"measured rule with an unknown rounding":     `{"rules": {"A": {"unitPrice": 50, "unit": "kg", "rounding": "nearest"}}}`,
+		"measured rule with a special price":         `{"rules": {"A": {"unitPrice": 50, "unit": "kg", "specialPrice": {"quantity": 3, "price": 130}}}}`,
+		"measured rule with tiered prices":           `{"rules": {"A": {"unitPrice": 50, "unit": "litre", "tiers": {"mode": "all-units", "tiers": [{"minQuantity": 2, "unitPrice": 40}]}}}}`,
+		"barcode shared by two rules":                `{"rules": {"A": {"unitPrice": 50, "barcodes": ["4006381333931"]}, "B": {"unitPrice": 30, "barcodes": ["4006381333931"]}}}`,
+		"barcode with a wrong check digit":           `{"rules": {"A": {"unitPrice": 50, "barcodes": ["4006381333932"]}}}`,
+		"item reference on a rule sold each":         `{"rules": {"A": {"unitPrice": 50, "itemReference": "12345"}}}`,
+		"store giving a rule another rule's barcode": `{"rules": {"A": {"unitPrice": 50, "barcodes": ["4006381333931"]}}, "stores": {"leeds": {"rules": {"B": {"unitPrice": 30, "barcodes": ["4006381333931"]}}}}}`,
+		"rule sold each with a rounding":             `{"rules": {"A": {"unitPrice": 50, "rounding": "down"}}}`,
+		"rule with no tax category":                  `{"rules": {"A": {"unitPrice": 50}}, "tax": {"mode": "inclusive", "rounding": "line", "rates": {"standard": 2000}}}`,
+		"change to a tax table missing a rate": `{"rules": {"A": {"unitPrice": 50, "taxCategory": "reduced"}},
+			"tax": {"mode": "inclusive", "rounding": "line", "rates": {"reduced": 500}},
+			"scheduled": [{"effectiveFrom": "2025-02-01T00:00:00Z", "tax": {"mode": "inclusive", "rounding": "line", "rates": {"standard": 2000}}}]}`,
+	} {
+		if _, err := parsePricingFile([]byte(contents)); err == nil {
+			t.Errorf("Expected an error parsing a pricing file with a %s, but got nil", name)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package tax works out the tax charged on a checkout from the tax rate table
+// of its price list.
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+// Validate checks a tax rate table and that the tax category of every rule
+// is in it.
+func Validate(config domain.TaxConfig, rules map[string]domain.PricingRule) error {
+	switch config.Mode {
+	case domain.TaxInclusive, domain.TaxExclusive:
+	default:
+		return fmt.Errorf("unknown tax mode '%s', expected '%s' or '%s'", config.Mode, domain.TaxInclusive, domain.TaxExclusive)
+	}
+	switch config.Rounding {
+	case domain.RoundPerLine, domain.RoundPerInvoice:
+	default:
+		return fmt.Errorf("unknown tax rounding '%s', expected '%s' or '%s'", config.Rounding, domain.RoundPerLine, domain.RoundPerInvoice)
+	}
+	if len(config.Rates) == 0 {
+		return errors.New("tax needs at least one rate")
+	}
+	for category, rate := range config.Rates {
+		if rate < 0 {
+			return fmt.Errorf("tax category '%s': rate must not be negative", category)
+		}
+	}
+	if config.DefaultCategory != "" {
+		if _, exists := config.Rates[config.DefaultCategory]; !exists {
+			return fmt.Errorf("default tax category '%s' has no rate", config.DefaultCategory)
+		}
+	}
+	for sku, rule := range rules {
+		if rule.TaxCategory == "" && config.DefaultCategory == "" {
+			return fmt.Errorf("sku '%s' has no tax category and there is no default", sku)
+		}
+		if _, exists := config.Rates[CategoryOf(config, rule)]; !exists {
+			return fmt.Errorf("sku '%s': tax category '%s' has no rate", sku, rule.TaxCategory)
+		}
+	}
+	return nil
+}
+
+// CategoryOf returns the tax category an SKU's rule is taxed in.
+func CategoryOf(config domain.TaxConfig, rule domain.PricingRule) string {
+	if rule.TaxCategory != "" {
+		return rule.TaxCategory
+	}
+	return config.DefaultCategory
+}
+
+// Calculate works out the tax on lines, each of which carries its tax
+// category, after discount is taken from their total. The discount is shared
+// between the lines in proportion to their totals, so that it lowers the
+// amount taxed at each rate fairly.
+//
+// Tax is rounded half up, on each line or on the total at each rate as the
+// config says. In inclusive mode the gross is the discounted total and the
+// tax is worked out from it; in exclusive mode the discounted total is net
+// and the tax is added to it. Every amount is in the currency of discount,
+// and Calculate returns domain.ErrOverflow if any is too large to represent.
+func Calculate(config domain.TaxConfig, lines []domain.LineItem, discount domain.Money) (domain.TaxSummary, error) {
+	amounts, err := share(lines, discount)
+	if err != nil {
+		return domain.TaxSummary{}, err
+	}
+	zero := domain.Money{Currency: discount.Currency}
+	byCategory := make(map[string]*domain.TaxLine)
+	for i, line := range lines {
+		if amounts[i].Amount == 0 {
+			continue
+		}
+		category := byCategory[line.TaxCategory]
+		if category == nil {
+			category = &domain.TaxLine{Category: line.TaxCategory, Rate: config.Rates[line.TaxCategory], Net: zero, Tax: zero, Gross: zero}
+			byCategory[line.TaxCategory] = category
+		}
+		if config.Mode == domain.TaxInclusive {
+			category.Gross, err = category.Gross.Add(amounts[i])
+		} else {
+			category.Net, err = category.Net.Add(amounts[i])
+		}
+		if err == nil && config.Rounding == domain.RoundPerLine {
+			var lineTax domain.Money
+			if lineTax, err = taxOn(config.Mode, amounts[i], category.Rate); err == nil {
+				category.Tax, err = category.Tax.Add(lineTax)
+			}
+		}
+		if err != nil {
+			return domain.TaxSummary{}, fmt.Errorf("sku '%s' %w", line.SKU, err)
+		}
+	}
+
+	summary := domain.TaxSummary{Mode: config.Mode, Rates: make([]domain.TaxLine, 0, len(byCategory)), Net: zero, Tax: zero, Gross: zero}
+	for _, category := range byCategory {
+		if config.Rounding == domain.RoundPerInvoice {
+			taxed := category.Net
+			if config.Mode == domain.TaxInclusive {
+				taxed = category.Gross
+			}
+			if category.Tax, err = taxOn(config.Mode, taxed, category.Rate); err != nil {
+				return domain.TaxSummary{}, fmt.Errorf("tax category '%s': %w", category.Category, err)
+			}
+		}
+		if config.Mode == domain.TaxInclusive {
+			category.Net, err = category.Gross.Sub(category.Tax)
+		} else {
+			category.Gross, err = category.Net.Add(category.Tax)
+		}
+		if err == nil {
+			summary.Net, err = summary.Net.Add(category.Net)
+		}
+		if err == nil {
+			summary.Tax, err = summary.Tax.Add(category.Tax)
+		}
+		if err == nil {
+			summary.Gross, err = summary.Gross.Add(category.Gross)
+		}
+		if err != nil {
+			return domain.TaxSummary{}, fmt.Errorf("tax category '%s': %w", category.Category, err)
+		}
+		summary.Rates = append(summary.Rates, *category)
+	}
+	sort.Slice(summary.Rates, func(i, j int) bool { return summary.Rates[i].Category < summary.Rates[j].Category })
+	return summary, nil
+}
+
+// taxOn returns the tax, rounded half up, on an amount at a rate in basis
+// points. In inclusive mode the amount includes the tax.
+func taxOn(mode domain.TaxMode, amount domain.Money, rate int) (domain.Money, error) {
+	divisor := int64(10000)
+	if mode == domain.TaxInclusive {
+		divisor += int64(rate)
+	}
+	tax, remainder, err := amount.MulDiv(int64(rate), divisor)
+	if err != nil || remainder < divisor-remainder {
+		return tax, err
+	}
+	return tax.Add(domain.Money{Amount: 1, Currency: tax.Currency})
+}
+
+// share returns the total of each line once discount is shared between them
+// in proportion to their totals. Units left over from rounding down go to
+// the lines with the largest remainders, then to the earliest lines.
+func share(lines []domain.LineItem, discount domain.Money) ([]domain.Money, error) {
+	amounts := make([]domain.Money, len(lines))
+	total := domain.Money{Currency: discount.Currency}
+	for i, line := range lines {
+		amounts[i] = line.LineTotal
+		var err error
+		if total, err = total.Add(line.LineTotal); err != nil {
+			return nil, fmt.Errorf("sku '%s' %w", line.SKU, err)
+		}
+	}
+	if discount.Amount <= 0 || total.Amount == 0 {
+		return amounts, nil
+	}
+	if discount.Amount > total.Amount {
+		discount = total
+	}
+
+	remainders := make([]int64, len(lines))
+	order := make([]int, len(lines))
+	left := discount.Amount
+	for i := range lines {
+		taken, remainder, err := discount.MulDiv(amounts[i].Amount, total.Amount)
+		if err == nil {
+			amounts[i], err = amounts[i].Sub(taken)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sku '%s' %w", lines[i].SKU, err)
+		}
+		remainders[i] = remainder
+		left -= taken.Amount
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:left] {
+		amounts[i].Amount--
+	}
+	return amounts, nil
+}
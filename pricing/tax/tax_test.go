@@ -0,0 +1,140 @@
+package tax
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TheFodfather/checkoutapi/domain"
+)
+
+var rates = map[string]int{"standard": 2000, "reduced": 500, "zero": 0}
+
+func TestCalculate(t *testing.T) {
+	basket := []domain.LineItem{
+		{SKU: "A", LineTotal: gbp(130), TaxCategory: "standard"},
+		{SKU: "B", LineTotal: gbp(45), TaxCategory: "reduced"},
+		{SKU: "C", LineTotal: gbp(20), TaxCategory: "zero"},
+	}
+	pair := []domain.LineItem{
+		{SKU: "A", LineTotal: gbp(3), TaxCategory: "standard"},
+		{SKU: "B", LineTotal: gbp(3), TaxCategory: "standard"},
+	}
+
+	testCases := []struct {
+		name     string
+		mode     domain.TaxMode
+		rounding domain.TaxRounding
+		lines    []domain.LineItem
+		discount int64
+		expected domain.TaxSummary
+	}{
+		{
+			name: "Inclusive prices at several rates", mode: domain.TaxInclusive, rounding: domain.RoundPerInvoice, lines: basket,
+			expected: domain.TaxSummary{Mode: domain.TaxInclusive, Net: gbp(171), Tax: gbp(24), Gross: gbp(195), Rates: []domain.TaxLine{
+				{Category: "reduced", Rate: 500, Net: gbp(43), Tax: gbp(2), Gross: gbp(45)},
+				{Category: "standard", Rate: 2000, Net: gbp(108), Tax: gbp(22), Gross: gbp(130)},
+				{Category: "zero", Rate: 0, Net: gbp(20), Tax: gbp(0), Gross: gbp(20)},
+			}},
+		},
+		{
+			name: "Exclusive prices at several rates", mode: domain.TaxExclusive, rounding: domain.RoundPerInvoice, lines: basket,
+			expected: domain.TaxSummary{Mode: domain.TaxExclusive, Net: gbp(195), Tax: gbp(28), Gross: gbp(223), Rates: []domain.TaxLine{
+				{Category: "reduced", Rate: 500, Net: gbp(45), Tax: gbp(2), Gross: gbp(47)},
+				{Category: "standard", Rate: 2000, Net: gbp(130), Tax: gbp(26), Gross: gbp(156)},
+				{Category: "zero", Rate: 0, Net: gbp(20), Tax: gbp(0), Gross: gbp(20)},
+			}},
+		},
+		{
+			name: "Rounded per line", mode: domain.TaxExclusive, rounding: domain.RoundPerLine, lines: pair,
+			expected: domain.TaxSummary{Mode: domain.TaxExclusive, Net: gbp(6), Tax: gbp(2), Gross: gbp(8), Rates: []domain.TaxLine{
+				{Category: "standard", Rate: 2000, Net: gbp(6), Tax: gbp(2), Gross: gbp(8)},
+			}},
+		},
+		{
+			name: "Rounded per invoice", mode: domain.TaxExclusive, rounding: domain.RoundPerInvoice, lines: pair,
+			expected: domain.TaxSummary{Mode: domain.TaxExclusive, Net: gbp(6), Tax: gbp(1), Gross: gbp(7), Rates: []domain.TaxLine{
+				{Category: "standard", Rate: 2000, Net: gbp(6), Tax: gbp(1), Gross: gbp(7)},
+			}},
+		},
+		{
+			name: "Discount shared between rates", mode: domain.TaxExclusive, rounding: domain.RoundPerInvoice, discount: 30,
+			lines: []domain.LineItem{{SKU: "A", LineTotal: gbp(100), TaxCategory: "standard"}, {SKU: "C", LineTotal: gbp(50), TaxCategory: "zero"}},
+			expected: domain.TaxSummary{Mode: domain.TaxExclusive, Net: gbp(120), Tax: gbp(16), Gross: gbp(136), Rates: []domain.TaxLine{
+				{Category: "standard", Rate: 2000, Net: gbp(80), Tax: gbp(16), Gross: gbp(96)},
+				{Category: "zero", Rate: 0, Net: gbp(40), Tax: gbp(0), Gross: gbp(40)},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := domain.TaxConfig{Mode: tc.mode, Rounding: tc.rounding, Rates: rates}
+			got, err := Calculate(config, tc.lines, gbp(tc.discount))
+			if err != nil {
+				t.Fatalf("Calculate() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCalculateLargeAmounts(t *testing.T) {
+	config := domain.TaxConfig{Mode: domain.TaxExclusive, Rounding: domain.RoundPerLine, Rates: rates}
+	lines := []domain.LineItem{{SKU: "A", LineTotal: gbp(3e18), TaxCategory: "standard"}}
+	summary, err := Calculate(config, lines, gbp(0))
+	if err != nil {
+		t.Fatalf("Calculate() returned an unexpected error: %v", err)
+	}
+	if summary.Tax != gbp(6e17) || summary.Gross != gbp(36e17) {
+		t.Errorf("Expected tax of 6e17 and a gross of 36e17, got %v and %v", summary.Tax, summary.Gross)
+	}
+
+	lines[0].LineTotal = gbp(8e18)
+	if _, err := Calculate(config, lines, gbp(0)); !errors.Is(err, domain.ErrOverflow) {
+		t.Errorf("Expected error %v adding tax past the largest amount, got %v", domain.ErrOverflow, err)
+	}
+}
+
+func gbp(amount int64) domain.Money {
+	return domain.Money{Amount: amount, Currency: "GBP"}
+}
+
+func TestShare(t *testing.T) {
+	lines := []domain.LineItem{{LineTotal: gbp(10)}, {LineTotal: gbp(10)}, {LineTotal: gbp(10)}, {LineTotal: gbp(0)}}
+	if got, _ := share(lines, gbp(10)); !reflect.DeepEqual(got, []domain.Money{gbp(6), gbp(7), gbp(7), gbp(0)}) {
+		t.Errorf("Expected %v, got %v", []domain.Money{gbp(6), gbp(7), gbp(7), gbp(0)}, got)
+	}
+	if got, _ := share(lines, gbp(50)); !reflect.DeepEqual(got, []domain.Money{gbp(0), gbp(0), gbp(0), gbp(0)}) {
+		t.Errorf("Expected a discount over the total to take every line to zero, got %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := domain.TaxConfig{Mode: domain.TaxInclusive, Rounding: domain.RoundPerLine, Rates: rates, DefaultCategory: "standard"}
+	rules := map[string]domain.PricingRule{"A": {UnitPrice: domain.Money{Amount: 50}}, "B": {UnitPrice: domain.Money{Amount: 30}, TaxCategory: "reduced"}}
+	if err := Validate(valid, rules); err != nil {
+		t.Errorf("Validate() returned an unexpected error: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		mutate func(*domain.TaxConfig)
+		rules  map[string]domain.PricingRule
+	}{
+		"unknown mode":             {mutate: func(c *domain.TaxConfig) { c.Mode = "gross" }},
+		"unknown rounding":         {mutate: func(c *domain.TaxConfig) { c.Rounding = "item" }},
+		"no rates":                 {mutate: func(c *domain.TaxConfig) { c.Rates = nil; c.DefaultCategory = "" }},
+		"negative rate":            {mutate: func(c *domain.TaxConfig) { c.Rates = map[string]int{"standard": -1} }},
+		"default without a rate":   {mutate: func(c *domain.TaxConfig) { c.DefaultCategory = "luxury" }},
+		"rule without a category":  {mutate: func(c *domain.TaxConfig) { c.DefaultCategory = "" }, rules: rules},
+		"rule with an unknown one": {mutate: func(*domain.TaxConfig) {}, rules: map[string]domain.PricingRule{"A": {TaxCategory: "luxury"}}},
+	} {
+		config := valid
+		tc.mutate(&config)
+		if err := Validate(config, tc.rules); err == nil {
+			t.Errorf("Expected an error validating tax with %s, but got nil", name)
+		}
+	}
+}